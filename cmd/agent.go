@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/client"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent <entity>...",
+	Short: "Watch entity certificates and renew them before they expire",
+	Long: `Watches <entity>-cert.pem in the store for every entity and renews the
+certificate from the CA service once the configured fraction of its lifetime
+has elapsed. Renewals authenticate with the current certificate, or with the
+PSK once it has expired. The new certificate, chain and key replace the old
+files atomically, after which the hook command is run and the signal is sent.`,
+	RunE: runAgent,
+	Args: cobra.MinimumNArgs(1),
+}
+
+// agent renews the certificates of entities
+type agent struct {
+	cmd       *cobra.Command
+	opts      client.Options
+	psk       string
+	renewAt   float64 // fraction of the lifetime after which certificates are renewed
+	jitter    float64 // largest random fraction of the lifetime renewals are moved forward by
+	rotateKey bool
+	hook      string
+	signal    os.Signal
+	pid       int
+	pidFile   string
+
+	// renewal times by entity, computed once per certificate serial
+	schedule map[string]scheduledRenewal
+}
+
+type scheduledRenewal struct {
+	serial string
+	at     time.Time
+}
+
+// renewalTime returns when cert is due for renewal. The jitter spreads the
+// renewals of certificates issued at the same time.
+func (a *agent) renewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	at := cert.NotBefore.Add(time.Duration(a.renewAt * float64(lifetime)))
+	return at.Add(-time.Duration(rand.Float64() * a.jitter * float64(lifetime)))
+}
+
+// due reports whether the certificate of entity has to be renewed at now
+func (a *agent) due(entity string, now time.Time) (bool, error) {
+	der, err := gen.ReadCertificatePEM(gen.StorePath(entity + "-cert.pem"))
+	if err != nil {
+		return false, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return false, err
+	}
+
+	serial := cert.SerialNumber.Text(16)
+	s, ok := a.schedule[entity]
+	if !ok || s.serial != serial {
+		s = scheduledRenewal{serial: serial, at: a.renewalTime(cert)}
+		a.schedule[entity] = s
+		log.Printf("%s: certificate %s expires %s, renewing at %s", entity, serial,
+			cert.NotAfter.Format(time.RFC3339), s.at.Format(time.RFC3339))
+	}
+	return !now.Before(s.at), nil
+}
+
+// renew requests a new certificate for entity and replaces its files
+func (a *agent) renew(entity string) error {
+	identity, err := entityIdentity(entity)
+	if err != nil {
+		return err
+	}
+	key := identity.PrivateKey.(crypto.Signer)
+	if a.rotateKey {
+		if key, err = gen.GenerateKeyLike(key); err != nil {
+			return fmt.Errorf("error generating key : %v", err)
+		}
+	}
+
+	csrBytes, err := gen.GenerateRenewalCSR(identity.Leaf, key)
+	if err != nil {
+		return fmt.Errorf("error generating CSR : %v", err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
+
+	c, err := newClient(a.cmd)
+	if err != nil {
+		return err
+	}
+	c.Retry = client.RetryPolicy{Attempts: 3, Backoff: 5 * time.Second, MaxBackoff: time.Minute}
+
+	var resp *api.SignResponse
+	if time.Now().After(identity.Leaf.NotAfter) {
+		if a.psk == "" {
+			return fmt.Errorf("certificate expired %s and no PSK was given",
+				identity.Leaf.NotAfter.Format(time.RFC3339))
+		}
+		c.PSK, c.HMAC = a.psk, true
+		resp, err = c.Sign(context.Background(), b, a.opts)
+	} else {
+		c.Certificate = identity
+		resp, err = c.Renew(context.Background(), b, a.opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the key is replaced last so it only changes once the certificate matching it is in place
+	files := []pendingFile{
+		{path: gen.StorePath(entity + "-cert.pem"), data: []byte(resp.Certificate)},
+		{path: gen.StorePath(entity + "-chain.pem"), data: []byte(resp.Chain)},
+	}
+	if a.rotateKey {
+		// a leftover from an interrupted renewal would keep its permissions
+		_ = gen.AppFs.Remove(gen.StorePath(entity + "-key.pem.tmp"))
+		if err := gen.WritePrivateKey(gen.StorePath(entity+"-key.pem.tmp"), key); err != nil {
+			return err
+		}
+		files = append(files, pendingFile{path: gen.StorePath(entity + "-key.pem")})
+	}
+	if err := swapFiles(files); err != nil {
+		return err
+	}
+	log.Printf("%s: renewed certificate, valid for %s until %s", entity, resp.Validity,
+		resp.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// pendingFile is a file replaced by swapFiles
+type pendingFile struct {
+	path string
+	data []byte // nil when path.tmp has already been written
+}
+
+// swapFiles writes the content of every file next to it and renames them into
+// place in order once all have been written, so readers never see a partial
+// file. When a rename fails the files already replaced get their previous
+// content back, only a failure to restore them leaves old and new files mixed.
+func swapFiles(files []pendingFile) error {
+	for _, f := range files {
+		if f.data == nil {
+			continue
+		}
+		if err := afero.WriteFile(gen.AppFs, f.path+".tmp", f.data, 0644); err != nil {
+			return err
+		}
+	}
+
+	// kept to restore the files already replaced when a later rename fails
+	previous := make([]*savedFile, len(files))
+	for i, f := range files {
+		info, err := gen.AppFs.Stat(f.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := afero.ReadFile(gen.AppFs, f.path)
+		if err != nil {
+			return err
+		}
+		previous[i] = &savedFile{data: data, mode: info.Mode().Perm()}
+	}
+
+	for i, f := range files {
+		if err := gen.AppFs.Rename(f.path+".tmp", f.path); err != nil {
+			for _, left := range files[i:] {
+				_ = gen.AppFs.Remove(left.path + ".tmp")
+			}
+			if restoreErr := restoreFiles(files[:i], previous[:i]); restoreErr != nil {
+				return fmt.Errorf("error replacing %s : %v, restoring the previous files failed : %v",
+					f.path, err, restoreErr)
+			}
+			return fmt.Errorf("error replacing %s, kept the previous files : %v", f.path, err)
+		}
+	}
+	return nil
+}
+
+// savedFile is the content of a file before swapFiles replaced it
+type savedFile struct {
+	data []byte
+	mode os.FileMode
+}
+
+// restoreFiles puts back the previous content of files replaced by swapFiles,
+// nil for those that did not exist
+func restoreFiles(files []pendingFile, previous []*savedFile) error {
+	for i := len(files) - 1; i >= 0; i-- {
+		if previous[i] == nil {
+			if err := gen.AppFs.Remove(files[i].path); err != nil {
+				return err
+			}
+			continue
+		}
+		tmp := files[i].path + ".tmp"
+		_ = gen.AppFs.Remove(tmp)
+		if err := afero.WriteFile(gen.AppFs, tmp, previous[i].data, previous[i].mode); err != nil {
+			return err
+		}
+		if err := gen.AppFs.Rename(tmp, files[i].path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify runs the hook and signals the process configured to reload certificates
+func (a *agent) notify(renewed []string) {
+	if a.hook != "" {
+		hook := exec.Command("sh", "-c", a.hook)
+		hook.Env = append(os.Environ(), "RENEWED_ENTITIES="+strings.Join(renewed, " "))
+		out, err := hook.CombinedOutput()
+		if err != nil {
+			log.Printf("[error] hook %q failed : %v\n%s", a.hook, err, out)
+		} else {
+			log.Printf("ran hook %q", a.hook)
+		}
+	}
+
+	pid := a.pid
+	if a.pidFile != "" {
+		b, err := os.ReadFile(a.pidFile)
+		if err != nil {
+			log.Printf("[error] reading pid file : %v", err)
+			return
+		}
+		if pid, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			log.Printf("[error] %s does not hold a pid : %v", a.pidFile, err)
+			return
+		}
+	}
+	if pid > 0 {
+		// FindProcess always succeeds on unix, Signal reports a missing process
+		p, err := os.FindProcess(pid)
+		if err == nil {
+			err = p.Signal(a.signal)
+		}
+		if err != nil {
+			log.Printf("[error] sending %s to %d : %v", a.signal, pid, err)
+		} else {
+			log.Printf("sent %s to %d", a.signal, pid)
+		}
+	}
+}
+
+// check renews every entity that is due and reports whether all renewals succeeded
+func (a *agent) check(entities []string) bool {
+	ok := true
+	var renewed []string
+	for _, entity := range entities {
+		due, err := a.due(entity, time.Now())
+		if err != nil {
+			log.Printf("[error] %s: reading certificate : %v", entity, err)
+			ok = false
+			continue
+		}
+		if !due {
+			continue
+		}
+		if err := a.renew(entity); err != nil {
+			log.Printf("[error] %s: renewal failed, retrying on the next check : %v", entity, err)
+			ok = false
+			continue
+		}
+		renewed = append(renewed, entity)
+	}
+	if len(renewed) > 0 {
+		a.notify(renewed)
+	}
+	return ok
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+
+	renewAt, err := cmd.Flags().GetFloat64("renew-at")
+	if err != nil {
+		return err
+	}
+	jitter, err := cmd.Flags().GetFloat64("jitter")
+	if err != nil {
+		return err
+	}
+	if renewAt <= 0 || renewAt >= 1 || jitter < 0 || jitter >= renewAt {
+		return fmt.Errorf("--renew-at must be between 0 and 1 and --jitter between 0 and --renew-at")
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		return err
+	}
+	rotateKey, err := cmd.Flags().GetBool("rotate-key")
+	if err != nil {
+		return err
+	}
+	var signal os.Signal
+	if getInt(cmd, "pid") > 0 || getString(cmd, "pid-file") != "" {
+		var ok bool
+		signal, ok = signals[strings.TrimPrefix(strings.ToUpper(getString(cmd, "signal")), "SIG")]
+		if !ok {
+			return fmt.Errorf("unsupported signal %s", getString(cmd, "signal"))
+		}
+	}
+	opts, err := requestOptions(cmd)
+	if err != nil {
+		return err
+	}
+	psk, _, err := readPSK(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	a := &agent{
+		cmd:       cmd,
+		opts:      opts,
+		psk:       psk,
+		renewAt:   renewAt,
+		jitter:    jitter,
+		rotateKey: rotateKey,
+		hook:      getString(cmd, "hook"),
+		signal:    signal,
+		pid:       getInt(cmd, "pid"),
+		pidFile:   getString(cmd, "pid-file"),
+		schedule:  map[string]scheduledRenewal{},
+	}
+
+	if once {
+		if !a.check(args) {
+			return fmt.Errorf("not every certificate could be renewed")
+		}
+		return nil
+	}
+	log.Printf("Watching %s, checking every %s", strings.Join(args, ", "), interval)
+	for {
+		a.check(args)
+		time.Sleep(interval)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.Flags().String("url", "", "CA service URL")
+	_ = agentCmd.MarkFlagRequired("url")
+	agentCmd.Flags().String("ca", "", "CA certificate used to verify CA service")
+	addPSKFlags(agentCmd, "PSK used to renew certificates that have already expired")
+	agentCmd.Flags().String("profile", "",
+		"Certificate profile, e.g. server, client or peer. The server default is used when empty")
+	agentCmd.Flags().Duration("validity", 0,
+		"Requested certificate lifetime, e.g. 720h. The server may apply a shorter maximum")
+	agentCmd.Flags().Float64("renew-at", defaultRenewAt, "Fraction of the certificate lifetime after which it is renewed")
+	agentCmd.Flags().Float64("jitter", defaultRenewJitter,
+		"Largest random fraction of the lifetime a renewal is moved forward by")
+	agentCmd.Flags().Duration("interval", defaultAgentInterval, "Time between certificate checks")
+	agentCmd.Flags().Bool("once", false, "Renew the certificates that are due and exit")
+	agentCmd.Flags().Bool("rotate-key", false, "Generate a new key of the same type for every renewal")
+	agentCmd.Flags().String("hook", "", "Command run with sh -c after renewals, "+
+		"RENEWED_ENTITIES holds the renewed entities")
+	agentCmd.Flags().Int("pid", 0, "Process to signal after renewals")
+	agentCmd.Flags().String("pid-file", "", "File holding the process to signal after renewals")
+	agentCmd.Flags().String("signal", "HUP", "Signal sent to --pid or --pid-file, one of HUP, INT, TERM, USR1, USR2. "+
+		"Only available on unix, use --hook elsewhere")
+}
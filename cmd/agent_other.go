@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cmd
+
+import "os"
+
+// signals is empty where processes can not be asked to reload certificates
+// with a signal, --hook has to be used instead
+var signals = map[string]os.Signal{}
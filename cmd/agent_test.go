@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+const testStorePath = "/test/.pki"
+
+func testStore(t *testing.T) {
+	gen.AppFs = afero.NewMemMapFs()
+	if err := gen.InitStorage(testStorePath); err != nil {
+		t.Fatalf("error creating storage directory: %v", err)
+	}
+}
+
+// agentTestCertificate writes a self-signed certificate valid from notBefore to
+// notAfter and its key as the files of entity
+func agentTestCertificate(t *testing.T, entity string, serial int64, notBefore, notAfter time.Time) {
+	key, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: entity},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}, &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: entity}},
+		key.Public(), key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	if err := gen.WriteCertificate(gen.StorePath(entity+"-cert.pem"), der); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	if err := gen.WritePrivateKey(gen.StorePath(entity+"-key.pem"), key); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+	// the chain of an earlier renewal would be used instead of the certificate
+	_ = gen.AppFs.Remove(gen.StorePath(entity + "-chain.pem"))
+}
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(100 * time.Hour)}
+
+	a := &agent{renewAt: 0.75}
+	if at := a.renewalTime(cert); !at.Equal(notBefore.Add(75 * time.Hour)) {
+		t.Fatalf("expected a renewal after 75h, got %s", at.Sub(notBefore))
+	}
+	a.jitter = 0.25
+	for i := 0; i < 100; i++ {
+		at := a.renewalTime(cert)
+		if at.Before(notBefore.Add(50*time.Hour)) || at.After(notBefore.Add(75*time.Hour)) {
+			t.Fatalf("renewal after %s is outside the jitter", at.Sub(notBefore))
+		}
+	}
+}
+
+func TestDue(t *testing.T) {
+	testStore(t)
+	now := time.Now().Truncate(time.Second)
+	agentTestCertificate(t, "node", 1, now, now.Add(10*time.Hour))
+	a := &agent{renewAt: 0.5, schedule: map[string]scheduledRenewal{}}
+
+	if due, err := a.due("node", now.Add(4*time.Hour)); err != nil || due {
+		t.Fatalf("certificate is due before half its lifetime: %v", err)
+	}
+	if due, _ := a.due("node", now.Add(5*time.Hour)); !due {
+		t.Fatalf("certificate is not due after half its lifetime")
+	}
+
+	// a new certificate gets a new renewal time
+	agentTestCertificate(t, "node", 2, now.Add(5*time.Hour), now.Add(15*time.Hour))
+	if due, _ := a.due("node", now.Add(5*time.Hour)); due {
+		t.Fatalf("renewed certificate was not rescheduled")
+	}
+	if s := a.schedule["node"]; s.serial != "2" || !s.at.Equal(now.Add(10*time.Hour)) {
+		t.Fatalf("unexpected schedule %+v", s)
+	}
+
+	if _, err := a.due("missing", now); err == nil {
+		t.Fatalf("missing certificate was not reported")
+	}
+}
+
+func TestSwapFiles(t *testing.T) {
+	testStore(t)
+	cert, key := gen.StorePath("node-cert.pem"), gen.StorePath("node-key.pem")
+	_ = afero.WriteFile(gen.AppFs, cert, []byte("old cert"), 0644)
+	_ = afero.WriteFile(gen.AppFs, key, []byte("old key"), 0600)
+
+	// the key file was not written, renaming it fails after the certificate was replaced
+	err := swapFiles([]pendingFile{
+		{path: cert, data: []byte("new cert")},
+		{path: gen.StorePath("node-chain.pem"), data: []byte("new chain")},
+		{path: key},
+	})
+	if err == nil {
+		t.Fatalf("missing key file was not reported")
+	}
+	if b, _ := afero.ReadFile(gen.AppFs, cert); string(b) != "old cert" {
+		t.Fatalf("certificate was not restored: %q", b)
+	}
+	if exists, _ := afero.Exists(gen.AppFs, gen.StorePath("node-chain.pem")); exists {
+		t.Fatalf("chain that did not exist before was kept")
+	}
+	for _, f := range []string{cert, key, gen.StorePath("node-chain.pem")} {
+		if exists, _ := afero.Exists(gen.AppFs, f+".tmp"); exists {
+			t.Fatalf("temporary file %s.tmp was left behind", f)
+		}
+	}
+
+	_ = afero.WriteFile(gen.AppFs, key+".tmp", []byte("new key"), 0600)
+	err = swapFiles([]pendingFile{
+		{path: cert, data: []byte("new cert")},
+		{path: key},
+	})
+	if err != nil {
+		t.Fatalf("error swapping files: %v", err)
+	}
+	for f, expected := range map[string]string{cert: "new cert", key: "new key"} {
+		if b, _ := afero.ReadFile(gen.AppFs, f); string(b) != expected {
+			t.Fatalf("%s holds %q, expected %q", f, b, expected)
+		}
+	}
+}
+
+func TestAgentRenew(t *testing.T) {
+	var path string
+	var received api.SignRequest
+	var clientCertificate bool
+	var srv *httptest.Server
+	srv = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		clientCertificate = len(req.TLS.PeerCertificates) > 0
+		received = api.SignRequest{}
+		_ = json.NewDecoder(req.Body).Decode(&received)
+		// any certificate will do, the agent does not parse it
+		issued := string(gen.EncodeCertificatesPEM(srv.Certificate().Raw))
+		_ = json.NewEncoder(w).Encode(api.SignResponse{Certificate: issued, Chain: issued})
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	testStore(t)
+	_ = gen.WriteCertificate("/test/ca.pem", srv.Certificate().Raw)
+	cmd := &cobra.Command{}
+	cmd.Flags().String("url", srv.URL, "")
+	cmd.Flags().String("ca", "/test/ca.pem", "")
+	a := &agent{cmd: cmd, schedule: map[string]scheduledRenewal{}}
+
+	// a valid certificate authenticates its renewal
+	agentTestCertificate(t, "node", 1, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err := a.renew("node"); err != nil {
+		t.Fatalf("error renewing: %v", err)
+	}
+	if path != "/csr/v1/renew" || !clientCertificate {
+		t.Fatalf("renewal was sent to %s, client certificate presented: %v", path, clientCertificate)
+	}
+	if b, _ := afero.ReadFile(gen.AppFs, gen.StorePath("node-cert.pem")); string(b) != string(gen.EncodeCertificatesPEM(srv.Certificate().Raw)) {
+		t.Fatalf("certificate was not replaced: %q", b)
+	}
+
+	// an expired certificate falls back to the PSK
+	agentTestCertificate(t, "node", 2, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err := a.renew("node"); err == nil {
+		t.Fatalf("expired certificate was renewed without a PSK")
+	}
+	a.psk = "secret"
+	if err := a.renew("node"); err != nil {
+		t.Fatalf("error renewing expired certificate: %v", err)
+	}
+	if path != "/csr/v1/sign" || received.Psk != "" || received.MAC == "" {
+		t.Fatalf("expired certificate was not renewed with an HMAC signed request to %s: %+v", path, received)
+	}
+}
@@ -1,6 +1,30 @@
 package cmd
 
+import (
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
 const (
-	keyLength        = 2048
-	defaultOutputDir = "./.dcos-pki"
+	defaultKeyType     = gen.KeyTypeRSA
+	defaultKeyBits     = 2048
+	defaultOutputDir   = "./.dcos-pki"
+	defaultCRLValidity = 24 * time.Hour
+	defaultCRLFile     = "crl.pem"
+
+	defaultOCSPValidity       = time.Hour
+	defaultOCSPSignerValidity = 365 * 24 * time.Hour
+
+	defaultTokenTTL = time.Hour
+
+	defaultAuthFailures   = 5
+	defaultAuthBackoff    = time.Second
+	defaultAuthMaxBackoff = 15 * time.Minute
+
+	defaultACMEHTTP01Port = 80
+
+	defaultRenewAt       = 2.0 / 3
+	defaultRenewJitter   = 0.1
+	defaultAgentInterval = time.Minute
 )
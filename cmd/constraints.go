@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+// addNameConstraintFlags registers the CA name constraint flags on c
+func addNameConstraintFlags(c *cobra.Command) {
+	c.Flags().StringSlice("permitted-dns", []string{},
+		"DNS domains the CA may issue for, including subdomains. A leading dot only permits subdomains")
+	c.Flags().StringSlice("excluded-dns", []string{}, "DNS domains the CA may not issue for")
+	c.Flags().StringSlice("permitted-ip", []string{}, "IP ranges in CIDR notation the CA may issue for")
+	c.Flags().StringSlice("permitted-email", []string{},
+		"Email addresses, hosts or .domains the CA may issue for")
+}
+
+// nameConstraints returns the constraints configured by the flags registered in addNameConstraintFlags
+func nameConstraints(cmd *cobra.Command) (gen.NameConstraints, error) {
+	return gen.ParseNameConstraints(
+		getSlice(cmd, "permitted-dns"),
+		getSlice(cmd, "excluded-dns"),
+		getSlice(cmd, "permitted-ip"),
+		getSlice(cmd, "permitted-email"),
+	)
+}
@@ -1,17 +1,17 @@
 package cmd
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"encoding/pem"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/client"
 	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
-	"github.com/mesosphere/dcos-bootstrap-ca/pkg/server"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
-	"log"
-	"net/http"
-	"net/url"
-	"path"
 )
 
 var initCSRCmd = &cobra.Command{
@@ -21,35 +21,76 @@ var initCSRCmd = &cobra.Command{
 	Args:  cobra.MinimumNArgs(1),
 }
 
+// newClient returns a client for the service at --url, verified with the
+// certificate in --ca or the root CA of the store
+func newClient(cmd *cobra.Command) (*client.Client, error) {
+	certPool, err := gen.GetCACertPool(getString(cmd, "ca"))
+	if err != nil {
+		return nil, fmt.Errorf("error creating cert pool : %v", err)
+	}
+	return client.New(getString(cmd, "url"), certPool)
+}
+
+// requestOptions returns the profile and lifetime requested with --profile and --validity
+func requestOptions(cmd *cobra.Command) (client.Options, error) {
+	validity, err := cmd.Flags().GetDuration("validity")
+	if err != nil {
+		return client.Options{}, err
+	}
+	return client.Options{Profile: getString(cmd, "profile"), Validity: validity}, nil
+}
+
 func csrSign(cmd *cobra.Command, args []string) error {
 	d, err := cmd.Flags().GetString("output-dir")
-
 	if err != nil {
 		return err
 	}
 
 	entity := args[0]
 	entityKeyFile := entity + "-key.pem"
-	entityCertFile := entity + "-cert.pem"
 
-	baseURL := getString(cmd, "url")
-	u, err := url.Parse(baseURL)
+	psk, _, err := readPSK(cmd)
 	if err != nil {
-		log.Fatalf("error parsing url : %v", err)
+		return err
+	}
+	bootstrapToken := getString(cmd, "token")
+	if psk == "" && bootstrapToken == "" {
+		return fmt.Errorf("a PSK or --token is required")
+	}
+	useHMAC, err := cmd.Flags().GetBool("hmac")
+	if err != nil {
+		return err
+	}
+	requestCA, err := cmd.Flags().GetBool("request-ca")
+	if err != nil {
+		return err
+	}
+	opts, err := requestOptions(cmd)
+	if err != nil {
+		return err
 	}
-	u.Path = path.Join(u.Path, "csr", "v1", "sign")
-
-	psk := getString(cmd, "psk")
-	caFile := getString(cmd, "ca")
 
 	if err := gen.InitStorage(d); err != nil {
 		return err
 	}
 
-	clientKey, err := gen.ReadPrivateKey(gen.StorePath(entityKeyFile))
+	if fingerprint := getString(cmd, "ca-fingerprint"); fingerprint != "" {
+		if getString(cmd, "ca") != "" {
+			return fmt.Errorf("--ca and --ca-fingerprint are mutually exclusive")
+		}
+		if err := fetchPinnedRoot(getString(cmd, "url"), fingerprint); err != nil {
+			return err
+		}
+	}
+	c, err := newClient(cmd)
 	if err != nil {
+		return err
+	}
+	c.PSK, c.HMAC, c.Token = psk, useHMAC, bootstrapToken
 
-		log.Fatalf("could not read private key at %s : %v", gen.StorePath(entityKeyFile), err)
+	clientKey, err := gen.ReadPrivateKey(gen.StorePath(entityKeyFile))
+	if err != nil {
+		return fmt.Errorf("could not read private key at %s : %v", gen.StorePath(entityKeyFile), err)
 	}
 
 	config := gen.MakeCSRConfig(
@@ -61,44 +102,38 @@ func csrSign(cmd *cobra.Command, args []string) error {
 		getSlice(cmd, "sans"),
 		getSlice(cmd, "email-addresses"),
 	)
+	if requestCA {
+		config.RequestCA(getInt(cmd, "ca-path-len"))
+	}
 
 	csrBytes, err := gen.GenerateCSR(config, clientKey)
 	if err != nil {
-		log.Fatalf("error generating CSR: %v", err)
+		return fmt.Errorf("error generating CSR : %v", err)
 	}
 
 	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
-	j, err := json.Marshal(server.SignRequest{Psk: psk, Csr: string(b)})
-
-	certPool, err := gen.GetCACertPool(caFile)
-	if err != nil {
-		log.Fatalf("error creating cert")
-	}
-
-	tlsConfig := &tls.Config{RootCAs: certPool}
-	tr := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: tr}
-
-	resp, err := client.Post(u.String(), "application/json", bytes.NewReader(j))
+	resp, err := c.Sign(context.Background(), b, opts)
 	if err != nil {
-		log.Fatalf("error talking to service : %v", err)
+		return fmt.Errorf("error signing certificate : %v", err)
 	}
+	return writeSignResponse(entity, resp)
+}
 
-	respJSON := &server.SignResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(respJSON); err != nil {
-		log.Fatalf("error parsing json : %v", err)
-	}
+// writeSignResponse stores the certificate and chain of entity in the store
+func writeSignResponse(entity string, resp *api.SignResponse) error {
+	entityCertFile := gen.StorePath(entity + "-cert.pem")
+	entityChainFile := gen.StorePath(entity + "-chain.pem")
 
-	f, err := gen.AppFs.Create(gen.StorePath(entityCertFile))
-	if err != nil {
-		log.Fatalf("error creating file : %v", err)
+	if err := afero.WriteFile(gen.AppFs, entityCertFile, []byte(resp.Certificate), 0644); err != nil {
+		return fmt.Errorf("could not write signed certificate : %v", err)
 	}
-	_, err = f.Write([]byte(respJSON.Certificate))
+	log.Printf("wrote client certificate: %s, valid for %s until %s",
+		entityCertFile, resp.Validity, resp.NotAfter.Format(time.RFC3339))
 
-	if err != nil {
-		log.Fatalf("could not write signed certficate : %v", err)
+	if err := afero.WriteFile(gen.AppFs, entityChainFile, []byte(resp.Chain), 0644); err != nil {
+		return fmt.Errorf("could not write certificate chain : %v", err)
 	}
-	log.Printf("wrote client certificate: %s", gen.StorePath(entityCertFile))
+	log.Printf("wrote certificate chain: %s", entityChainFile)
 	return nil
 }
 
@@ -106,9 +141,14 @@ func init() {
 	rootCmd.AddCommand(initCSRCmd)
 	initCSRCmd.Flags().String("url", "", "CA service URL. Start the service with URL")
 	_ = initCSRCmd.MarkFlagRequired("url")
-	initCSRCmd.Flags().String("psk", "", "The PSK that the server was started with")
-	_ = initCSRCmd.MarkFlagRequired("psk")
+	addPSKFlags(initCSRCmd, "The PSK that the server was started with")
+	initCSRCmd.Flags().Bool("hmac", false,
+		"Authenticate with an HMAC keyed by the PSK instead of sending the PSK to the service")
+	initCSRCmd.Flags().String("token", "", "One-time bootstrap token created with the token command, "+
+		"used instead of the PSK")
 	initCSRCmd.Flags().String("ca", "", "CA certificate used to verify CA service")
+	initCSRCmd.Flags().String("ca-fingerprint", "",
+		"SHA-256 fingerprint of the root CA, which is fetched and stored like fetch-ca when given")
 	initCSRCmd.Flags().String("common-name", "client", "Root certificate common name")
 	initCSRCmd.Flags().String("country", "US", "Country name")
 	initCSRCmd.Flags().String("state", "CA", "State or Provence")
@@ -117,4 +157,10 @@ func init() {
 	initCSRCmd.Flags().StringSlice("email-addresses", []string{"security@mesosphere.com"},
 		"A list of administrative email addresses")
 	initCSRCmd.Flags().StringSlice("sans", []string{}, "Subject Alternative Names")
+	initCSRCmd.Flags().String("profile", "",
+		"Certificate profile, e.g. server, client or peer. The server default is used when empty")
+	initCSRCmd.Flags().Bool("request-ca", false, "Request a CA certificate, requires a profile allowing CAs")
+	initCSRCmd.Flags().Int("ca-path-len", 0, "Path length requested with --request-ca, negative for no limit")
+	initCSRCmd.Flags().Duration("validity", 0,
+		"Requested certificate lifetime, e.g. 720h. The server may apply a shorter maximum")
 }
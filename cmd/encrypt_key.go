@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var encryptKeyCmd = &cobra.Command{
+	Use:   "encrypt-key [key-file]...",
+	Short: "Encrypt private keys in the store with the key passphrase",
+	Long: `Rewrites private keys in the store as encrypted PKCS#8, with an AES-256-GCM
+key derived from the key passphrase by scrypt. Every *-key.pem file in the
+store is encrypted when no key file is given. Keys that are already encrypted
+are decrypted with the key passphrase and re-encrypted with the new passphrase
+given by --new-key-passphrase-file or --new-key-passphrase-env, or with the key
+passphrase again when none is.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rewriteKeys(cmd, args, true)
+	},
+}
+
+var decryptKeyCmd = &cobra.Command{
+	Use:   "decrypt-key [key-file]...",
+	Short: "Decrypt private keys in the store",
+	Long: `Rewrites encrypted private keys in the store unencrypted, in the format
+selected by --key-format. Every *-key.pem file in the store is decrypted when no
+key file is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rewriteKeys(cmd, args, false)
+	},
+}
+
+// rewriteKeys reads the key files, relative to the store, and writes them
+// encrypted with the key passphrase or unencrypted. Every key is read before
+// any is written.
+func rewriteKeys(cmd *cobra.Command, keyFiles []string, encrypt bool) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	passphrase := keyPassphrase
+	if encrypt {
+		newPassphrase, err := readNewKeyPassphrase(cmd)
+		if err != nil {
+			return err
+		}
+		if newPassphrase != nil {
+			passphrase = newPassphrase
+		}
+	}
+	if encrypt && len(passphrase) == 0 {
+		return fmt.Errorf("a key passphrase is required, set --key-passphrase-file, --key-passphrase-env, " +
+			"--key-passphrase-stdin, --new-key-passphrase-file or --new-key-passphrase-env")
+	}
+	if !encrypt && len(keyPassphrase) == 0 {
+		return fmt.Errorf("a key passphrase is required, set --key-passphrase-file, " +
+			"--key-passphrase-env or --key-passphrase-stdin")
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	if len(keyFiles) == 0 {
+		if keyFiles, err = afero.Glob(gen.AppFs, gen.StorePath("*-key.pem")); err != nil {
+			return err
+		}
+		for i, f := range keyFiles {
+			keyFiles[i] = path.Base(f)
+		}
+	}
+	if len(keyFiles) == 0 {
+		return fmt.Errorf("no private keys in %s", d)
+	}
+
+	keys := map[string]crypto.Signer{}
+	for _, f := range keyFiles {
+		encrypted, err := gen.IsEncryptedPrivateKey(gen.StorePath(f))
+		if err != nil {
+			return err
+		}
+		if !encrypt && !encrypted {
+			log.Printf("%s is not encrypted", f)
+			continue
+		}
+		if keys[f], err = gen.ReadPrivateKey(gen.StorePath(f)); err != nil {
+			return err
+		}
+	}
+
+	if encrypt {
+		gen.SetKeyPassphrase(passphrase)
+	} else {
+		gen.SetKeyPassphrase(nil)
+	}
+	var files []pendingFile
+	for f, key := range keys {
+		filePath := gen.StorePath(f)
+		// a leftover from an interrupted run would keep its permissions
+		_ = gen.AppFs.Remove(filePath + ".tmp")
+		if err := gen.WritePrivateKey(filePath+".tmp", key); err != nil {
+			return err
+		}
+		files = append(files, pendingFile{path: filePath})
+	}
+	if err := swapFiles(files); err != nil {
+		return err
+	}
+	for f := range keys {
+		if encrypt {
+			log.Printf("encrypted %s", gen.StorePath(f))
+		} else {
+			log.Printf("decrypted %s", gen.StorePath(f))
+		}
+	}
+	return nil
+}
+
+// readNewKeyPassphrase returns the passphrase encrypt-key encrypts keys with
+// instead of the key passphrase, nil when neither --new-key-passphrase-file nor
+// --new-key-passphrase-env is set
+func readNewKeyPassphrase(cmd *cobra.Command) ([]byte, error) {
+	if f := getString(cmd, "new-key-passphrase-file"); f != "" {
+		passphrase, err := gen.ReadSecretFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading new key passphrase : %v", err)
+		}
+		return []byte(passphrase), nil
+	}
+	if env := getString(cmd, "new-key-passphrase-env"); env != "" {
+		passphrase, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+func init() {
+	rootCmd.AddCommand(encryptKeyCmd)
+	rootCmd.AddCommand(decryptKeyCmd)
+	encryptKeyCmd.Flags().String("new-key-passphrase-file", "",
+		"Passphrase keys are encrypted with instead of the key passphrase, read from this file. "+
+			"The file must not be world-readable")
+	encryptKeyCmd.Flags().String("new-key-passphrase-env", "",
+		"Passphrase keys are encrypted with instead of the key passphrase, read from this environment variable")
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+func TestEncryptKeyNewPassphrase(t *testing.T) {
+	testStore(t)
+	keyFile := gen.StorePath(gen.RootKeyFile)
+	key, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	keyPassphrase = []byte("old passphrase")
+	gen.SetKeyPassphrase(keyPassphrase)
+	t.Cleanup(func() {
+		keyPassphrase = nil
+		gen.SetKeyPassphrase(nil)
+	})
+	if err := gen.WritePrivateKey(keyFile, key); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+
+	t.Setenv("TEST_NEW_KEY_PASSPHRASE", "new passphrase")
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output-dir", testStorePath, "")
+	cmd.Flags().String("new-key-passphrase-file", "", "")
+	cmd.Flags().String("new-key-passphrase-env", "TEST_NEW_KEY_PASSPHRASE", "")
+	if err := rewriteKeys(cmd, nil, true); err != nil {
+		t.Fatalf("error changing the key passphrase: %v", err)
+	}
+
+	gen.SetKeyPassphrase(keyPassphrase)
+	if _, err := gen.ReadPrivateKey(keyFile); err == nil {
+		t.Fatalf("key can still be decrypted with the old passphrase")
+	}
+	gen.SetKeyPassphrase([]byte("new passphrase"))
+	if _, err := gen.ReadPrivateKey(keyFile); err != nil {
+		t.Fatalf("key can not be decrypted with the new passphrase: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/client"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var fetchCACmd = &cobra.Command{
+	Use:   "fetch-ca",
+	Short: "Fetch the root CA certificate from the CA service, pinned by its fingerprint",
+	Long: `Connects to the CA service without a trusted root, verifies that the root it
+presents matches --fingerprint and stores it as root-cert.pem. The fingerprint
+is logged by init-ca and serve.`,
+	RunE: fetchCA,
+}
+
+// fetchPinnedRoot fetches the root CA from url, verifying the service against
+// the root with fingerprint, and stores it as root-cert.pem. An existing root
+// is kept when it matches and is an error otherwise.
+func fetchPinnedRoot(url, fingerprint string) error {
+	pin, err := gen.ParseFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	c, err := client.New(url, nil)
+	if err != nil {
+		return err
+	}
+	c.RootFingerprint = pin
+
+	root, err := c.FetchRoot(context.Background())
+	if err != nil {
+		return fmt.Errorf("error fetching root CA : %v", err)
+	}
+
+	rootFile := gen.StorePath(gen.RootCAFile)
+	if ok, _ := afero.Exists(gen.AppFs, rootFile); ok {
+		existing, err := gen.ReadCertificatePEM(rootFile)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(existing, root.Raw) {
+			return fmt.Errorf("%s holds a different root CA %s, remove it to replace it",
+				rootFile, gen.Fingerprint(existing))
+		}
+		log.Printf("root CA %s is already stored at %s", pin, rootFile)
+		return nil
+	}
+
+	if err := gen.WriteCertificate(rootFile, root.Raw); err != nil {
+		return fmt.Errorf("could not write root CA : %v", err)
+	}
+	log.Printf("wrote root CA %s - fingerprint: %s", rootFile, pin)
+	return nil
+}
+
+func fetchCA(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+	return fetchPinnedRoot(getString(cmd, "url"), getString(cmd, "fingerprint"))
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCACmd)
+	fetchCACmd.Flags().String("url", "", "CA service URL")
+	_ = fetchCACmd.MarkFlagRequired("url")
+	fetchCACmd.Flags().String("fingerprint", "", "SHA-256 fingerprint of the root CA, e.g. sha256:<hex>")
+	_ = fetchCACmd.MarkFlagRequired("fingerprint")
+}
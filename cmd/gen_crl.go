@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var genCRLCmd = &cobra.Command{
+	Use:   "gen-crl",
+	Short: "Generate a signed CRL of revoked certificates",
+	RunE:  generateCRL,
+}
+
+func generateCRL(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	validity, err := cmd.Flags().GetDuration("validity")
+	if err != nil {
+		return err
+	}
+
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		defer signer.Close()
+	}
+	ca, err := gen.LoadSigningCA(signer)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := ledger.Open().Revoked()
+	if err != nil {
+		return err
+	}
+	entries, err := ledger.RevocationEntries(revoked)
+	if err != nil {
+		return err
+	}
+
+	crl, err := gen.GenerateCRL(entries, ca.Certificate, ca.Key, validity)
+	if err != nil {
+		return err
+	}
+
+	out := getString(cmd, "out")
+	if out == "" {
+		out = gen.StorePath(defaultCRLFile)
+	}
+	if err := afero.WriteFile(gen.AppFs, out, gen.EncodeCRLPEM(crl), 0644); err != nil {
+		return err
+	}
+	log.Printf("wrote CRL: %s", out)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(genCRLCmd)
+	addSignerFlags(genCRLCmd)
+	genCRLCmd.Flags().String("out", "", "CRL output path, defaults to "+defaultCRLFile+" in the output directory")
+	genCRLCmd.Flags().Duration("validity", defaultCRLValidity, "Time until the next CRL update")
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"log"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var importCACmd = &cobra.Command{
+	Use:   "import-ca",
+	Short: "Import a CA issued by an external PKI instead of generating one",
+	Long: `Installs an existing CA certificate and key in the store. The key must match
+the certificate, which must be a CA allowed to sign certificates, and the
+certificate must chain to a root in --chain. A self-signed CA replaces the root,
+any other CA is installed as the intermediate serve signs with, and the chain
+up to the corporate root is returned to clients.
+
+serve presents the signing CA as its TLS certificate, so the imported
+certificate needs subject alternative names for the service. With
+--signer pkcs11 the key is taken from the token instead of --key.`,
+	RunE: importCA,
+}
+
+// checkNoCA refuses to replace a CA in the store unless --force is set
+func checkNoCA(cmd *cobra.Command) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	if exists, _ := afero.Exists(gen.AppFs, gen.StorePath(gen.RootCAFile)); exists && !force {
+		return fmt.Errorf("%s already holds a CA, use --force to replace it and remove its keys",
+			getString(cmd, "output-dir"))
+	}
+	return nil
+}
+
+// readCAChain returns the CA certificate of --cert and its issuers, those
+// following it in --cert and those of --chain
+func readCAChain(cmd *cobra.Command) ([]byte, [][]byte, error) {
+	certFile := getString(cmd, "cert")
+	certs, err := gen.ReadCertificateChainPEM(certFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s : %v", certFile, err)
+	}
+	chain := certs[1:]
+	for _, f := range getSlice(cmd, "chain") {
+		issuers, err := gen.ReadCertificateChainPEM(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s : %v", f, err)
+		}
+		chain = append(chain, issuers...)
+	}
+	return certs[0], chain, nil
+}
+
+// installCA verifies the CA certificate cert with key and its issuers in chain
+// and installs it in the store, see gen.InstallCA. The key is only written to
+// the store when inToken is false.
+func installCA(cert []byte, chain [][]byte, key crypto.Signer, inToken bool) error {
+	verified, err := gen.VerifyImportedCA(cert, key, chain)
+	if err != nil {
+		return err
+	}
+	ca, _ := x509.ParseCertificate(verified[0])
+	if len(ca.DNSNames) == 0 && len(ca.IPAddresses) == 0 {
+		log.Printf("warning: %s has no subject alternative names, clients can not verify serve", ca.Subject)
+	}
+
+	if inToken {
+		key = nil
+	}
+	if err := gen.InstallCA(verified, key); err != nil {
+		return err
+	}
+
+	if len(verified) == 1 {
+		log.Printf("Installed root CA %s", ca.Subject)
+	} else {
+		log.Printf("Installed CA %s as the intermediate, chain of %d certificates", ca.Subject, len(verified))
+	}
+	log.Printf("Root CA fingerprint: %s", gen.Fingerprint(verified[len(verified)-1]))
+	if exists, _ := afero.Exists(gen.AppFs, gen.StorePath(gen.OCSPCertFile)); exists {
+		log.Printf("%s was issued by the replaced CA, run init-ocsp-signer again",
+			gen.StorePath(gen.OCSPCertFile))
+	}
+	return nil
+}
+
+func importCA(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+	if err := checkNoCA(cmd); err != nil {
+		return err
+	}
+	cert, chain, err := readCAChain(cmd)
+	if err != nil {
+		return err
+	}
+
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		defer signer.Close()
+		key, err := signer.LoadKey()
+		if err != nil {
+			return fmt.Errorf("error loading CA key : %v", err)
+		}
+		return installCA(cert, chain, key, true)
+	}
+
+	keyFile := getString(cmd, "key")
+	if keyFile == "" {
+		return fmt.Errorf("--key is required by the %s signer", signerFile)
+	}
+	key, err := gen.ReadPrivateKey(keyFile)
+	if err != nil {
+		return fmt.Errorf("error reading %s : %v", keyFile, err)
+	}
+	return installCA(cert, chain, key, false)
+}
+
+func init() {
+	rootCmd.AddCommand(importCACmd)
+	importCACmd.Flags().String("cert", "", "CA certificate, optionally followed by its issuers")
+	_ = importCACmd.MarkFlagRequired("cert")
+	importCACmd.Flags().String("key", "", "Private key of the CA certificate")
+	importCACmd.Flags().StringSlice("chain", []string{},
+		"PEM bundles of the issuers of the CA, up to and including the root")
+	importCACmd.Flags().Bool("force", false, "Replace an existing CA, removing its keys from the store")
+	addSignerFlags(importCACmd)
+}
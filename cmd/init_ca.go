@@ -1,11 +1,13 @@
 package cmd
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"crypto"
+	"fmt"
+	"log"
+
 	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
-	"log"
 )
 
 // initCACmd represents the initCA command
@@ -25,11 +27,37 @@ func initializeCA(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	csrOnly, err := cmd.Flags().GetBool("csr-only")
+	if err != nil {
+		return err
+	}
+	keyFile := gen.RootKeyFile
+	if csrOnly {
+		if err := checkNoPendingCA(cmd); err != nil {
+			return err
+		}
+		keyFile = gen.PendingCAKeyFile
+	} else if err := checkNoCA(cmd); err != nil {
+		return err
+	}
+
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	if signer == nil {
+		signer = gen.FileSigner{Path: gen.StorePath(keyFile)}
+	}
+	defer signer.Close()
+
 	log.Printf("Generating private key\n")
-	pKey, err := rsa.GenerateKey(rand.Reader, keyLength)
+	pKey, err := signer.GenerateKey(getString(cmd, "key-type"), getInt(cmd, "key-bits"))
 	if err != nil {
 		return err
 	}
+	if csrOnly {
+		return writeCACSR(cmd, pKey)
+	}
 
 	config := gen.MakeCertificateConfig(
 		getString(cmd, "common-name"),
@@ -41,20 +69,81 @@ func initializeCA(cmd *cobra.Command, args []string) error {
 		getSlice(cmd, "email-addresses"),
 		true,
 	)
+	validity, err := cmd.Flags().GetDuration("validity")
+	if err != nil {
+		return err
+	}
+	config.SetValidity(validity)
 
-	cert, err := gen.GenerateCertificate(config, nil, pKey)
+	constraints, err := nameConstraints(cmd)
 	if err != nil {
 		return err
 	}
+	config.SetNameConstraints(constraints)
+	config.SetMaxPathLen(getInt(cmd, "path-len"))
 
-	if err := gen.WritePrivateKey(gen.StorePath(gen.RootKeyFile), pKey); err != nil {
+	cert, err := gen.GenerateCertificate(config, nil, pKey)
+	if err != nil {
 		return err
 	}
 
 	if err := gen.WriteCertificate(gen.StorePath(gen.RootCAFile), cert); err != nil {
 		return err
 	}
+	// an intermediate of a replaced CA no longer chains to the root
+	if err := removeIntermediate(); err != nil {
+		return err
+	}
+	log.Printf("Root CA fingerprint: %s", gen.Fingerprint(cert))
+
+	return nil
+}
+
+// checkNoPendingCA refuses to replace the key and CSR of an earlier
+// init-ca --csr-only, which may still be signed by the external CA, unless
+// --force is set
+func checkNoPendingCA(cmd *cobra.Command) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	for _, f := range []string{gen.PendingCAKeyFile, gen.PendingCACSRFile} {
+		if exists, _ := afero.Exists(gen.AppFs, gen.StorePath(f)); exists && !force {
+			return fmt.Errorf("%s already exists, install its signed certificate with install-ca-cert or use "+
+				"--force to generate a new pending CA key", gen.StorePath(f))
+		}
+	}
+	return nil
+}
 
+// writeCACSR writes a CSR for a CA certificate with the key pKey, requesting
+// the path length and name constraints set on the command line
+func writeCACSR(cmd *cobra.Command, pKey crypto.Signer) error {
+	config := gen.MakeCSRConfig(
+		getString(cmd, "common-name"),
+		getString(cmd, "country"),
+		getString(cmd, "state"),
+		getString(cmd, "locality"),
+		getString(cmd, "organization"),
+		getSlice(cmd, "sans"),
+		getSlice(cmd, "email-addresses"),
+	)
+	config.RequestCA(getInt(cmd, "path-len"))
+	constraints, err := nameConstraints(cmd)
+	if err != nil {
+		return err
+	}
+	config.SetNameConstraints(constraints)
+
+	csr, err := gen.GenerateCSR(config, pKey)
+	if err != nil {
+		return err
+	}
+	if err := gen.WriteCertificateRequest(gen.StorePath(gen.PendingCACSRFile), csr); err != nil {
+		return err
+	}
+	log.Printf("wrote CA CSR: %s, have it signed by the external CA and run install-ca-cert",
+		gen.StorePath(gen.PendingCACSRFile))
 	return nil
 }
 
@@ -68,4 +157,14 @@ func init() {
 	initCACmd.Flags().StringSlice("email-addresses", []string{"security@mesosphere.com"},
 		"A list of administrative email addresses")
 	initCACmd.Flags().StringSlice("sans", []string{}, "Subject Alternative Names")
+	initCACmd.Flags().Int("path-len", -1,
+		"Maximum number of intermediate CAs below the root, negative for no limit")
+	initCACmd.Flags().Duration("validity", gen.DefaultValidity, "Root certificate lifetime, e.g. 87600h")
+	initCACmd.Flags().Bool("csr-only", false, "Generate the CA key and a CSR for an external CA to sign "+
+		"instead of a self-signed root, see install-ca-cert")
+	initCACmd.Flags().Bool("force", false, "Replace an existing CA, removing its intermediate from the store. "+
+		"With --csr-only, replace the pending CA key and CSR of an earlier run")
+	addNameConstraintFlags(initCACmd)
+	addKeyFlags(initCACmd)
+	addSignerFlags(initCACmd)
 }
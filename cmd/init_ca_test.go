@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+)
+
+func TestCheckNoPendingCA(t *testing.T) {
+	testStore(t)
+	if err := checkNoPendingCA(initCACmd); err != nil {
+		t.Fatalf("empty store was refused: %v", err)
+	}
+
+	for _, f := range []string{gen.PendingCAKeyFile, gen.PendingCACSRFile} {
+		testStore(t)
+		_ = afero.WriteFile(gen.AppFs, gen.StorePath(f), []byte("pending"), 0600)
+		if err := checkNoPendingCA(initCACmd); err == nil {
+			t.Fatalf("pending %s was replaced without --force", f)
+		}
+	}
+
+	_ = initCACmd.Flags().Set("force", "true")
+	t.Cleanup(func() { _ = initCACmd.Flags().Set("force", "false") })
+	if err := checkNoPendingCA(initCACmd); err != nil {
+		t.Fatalf("pending CA was refused with --force: %v", err)
+	}
+}
+
+// runCommand runs the command line args against the test store
+func runCommand(args ...string) error {
+	rootCmd.SetArgs(append(args, "--output-dir", testStorePath))
+	return rootCmd.Execute()
+}
+
+func TestInitCAForce(t *testing.T) {
+	testStore(t)
+	t.Cleanup(func() {
+		_ = initCACmd.Flags().Set("force", "false")
+		_ = initIntermediateCmd.Flags().Set("force", "false")
+	})
+	if err := runCommand("init-ca", "--key-type", gen.KeyTypeECDSAP256); err != nil {
+		t.Fatalf("error initializing CA: %v", err)
+	}
+	if err := runCommand("init-intermediate", "--key-type", gen.KeyTypeECDSAP256); err != nil {
+		t.Fatalf("error initializing intermediate: %v", err)
+	}
+	root, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.RootKeyFile))
+	intermediate, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.IntermediateKeyFile))
+
+	if err := runCommand("init-intermediate", "--key-type", gen.KeyTypeECDSAP256); err == nil {
+		t.Fatalf("intermediate was replaced without --force")
+	}
+	if err := runCommand("init-ca", "--key-type", gen.KeyTypeECDSAP256); err == nil {
+		t.Fatalf("CA was replaced without --force")
+	}
+	if b, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.RootKeyFile)); string(b) != string(root) {
+		t.Fatalf("refused init-ca replaced the root key")
+	}
+	if b, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.IntermediateKeyFile)); string(b) != string(intermediate) {
+		t.Fatalf("refused init-intermediate replaced the intermediate key")
+	}
+
+	if err := runCommand("init-intermediate", "--key-type", gen.KeyTypeECDSAP256, "--force"); err != nil {
+		t.Fatalf("error replacing intermediate: %v", err)
+	}
+	if b, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.IntermediateKeyFile)); string(b) == string(intermediate) {
+		t.Fatalf("intermediate key was not replaced")
+	}
+	// the intermediate of the replaced root is removed, it would not chain to the new one
+	if err := runCommand("init-ca", "--key-type", gen.KeyTypeECDSAP256, "--force"); err != nil {
+		t.Fatalf("error replacing CA: %v", err)
+	}
+	if b, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.RootKeyFile)); string(b) == string(root) {
+		t.Fatalf("root key was not replaced")
+	}
+	if exists, _ := afero.Exists(gen.AppFs, gen.StorePath(gen.IntermediateCAFile)); exists {
+		t.Fatalf("intermediate of the replaced root was kept")
+	}
+}
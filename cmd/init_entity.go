@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
 	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
 	"github.com/spf13/cobra"
 	"log"
@@ -18,6 +16,7 @@ var initClientCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(initClientCmd)
+	addKeyFlags(initClientCmd)
 }
 
 func initializeClient(cmd *cobra.Command, args []string) error {
@@ -35,7 +34,7 @@ func initializeClient(cmd *cobra.Command, args []string) error {
 
 	log.Printf("Initializing new entity key at %s\n", gen.StorePath(entityFile))
 
-	pKey, err := rsa.GenerateKey(rand.Reader, keyLength)
+	pKey, err := generateKey(cmd)
 	if err != nil {
 		return err
 	}
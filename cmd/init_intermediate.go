@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// initIntermediateCmd represents the init-intermediate command
+var initIntermediateCmd = &cobra.Command{
+	Use:   "init-intermediate",
+	Short: "Initialize an intermediate certificate authority signed by the root",
+	Long: `Creates an intermediate CA signed by the root generated with init-ca.
+Once the intermediate exists serve signs with it, so the root key can be
+removed from the output directory and kept offline.`,
+	RunE: initializeIntermediate,
+}
+
+func initializeIntermediate(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	if err := checkNoIntermediate(cmd); err != nil {
+		return err
+	}
+
+	rootBytes, err := gen.ReadCertificatePEM(gen.StorePath(gen.RootCAFile))
+	if err != nil {
+		return fmt.Errorf("error reading root certificate, have you run init-ca? : %v", err)
+	}
+	rootCert, err := x509.ParseCertificate(rootBytes)
+	if err != nil {
+		return err
+	}
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	inStore := signer == nil
+	if inStore {
+		signer = gen.FileSigner{Path: gen.StorePath(gen.RootKeyFile)}
+	}
+	defer signer.Close()
+	rootKey, err := signer.LoadKey()
+	if err != nil {
+		return fmt.Errorf("error reading root key : %v", err)
+	}
+
+	pathLen := getInt(cmd, "path-len")
+	if !gen.CanIssueIntermediate(rootCert) {
+		return fmt.Errorf("root certificate path length constraint does not allow intermediates")
+	}
+	if rootCert.MaxPathLen > 0 && (pathLen < 0 || pathLen >= rootCert.MaxPathLen) {
+		return fmt.Errorf("path length must be less than the root path length of %d", rootCert.MaxPathLen)
+	}
+
+	log.Printf("Initializing new intermediate CA at %s\n", d)
+	log.Printf("Generating private key\n")
+	pKey, err := generateKey(cmd)
+	if err != nil {
+		return err
+	}
+
+	config := gen.MakeCertificateConfig(
+		getString(cmd, "common-name"),
+		getString(cmd, "country"),
+		getString(cmd, "state"),
+		getString(cmd, "locality"),
+		getString(cmd, "organization"),
+		getSlice(cmd, "sans"),
+		getSlice(cmd, "email-addresses"),
+		true,
+	)
+	validity, err := cmd.Flags().GetDuration("validity")
+	if err != nil {
+		return err
+	}
+	config.SetValidity(validity)
+
+	constraints, err := nameConstraints(cmd)
+	if err != nil {
+		return err
+	}
+	config.SetNameConstraints(constraints)
+	config.SetMaxPathLen(pathLen)
+
+	cert, err := gen.IssueCertificate(config, pKey.Public(), rootCert, rootKey)
+	if err != nil {
+		return err
+	}
+
+	// the issuers of an imported intermediate do not sign this one
+	if err := removeIntermediate(); err != nil {
+		return err
+	}
+	if err := gen.WritePrivateKey(gen.StorePath(gen.IntermediateKeyFile), pKey); err != nil {
+		return err
+	}
+
+	if err := gen.WriteCertificate(gen.StorePath(gen.IntermediateCAFile), cert); err != nil {
+		return err
+	}
+
+	if inStore {
+		log.Printf("Intermediate CA created, %s may now be moved offline", gen.StorePath(gen.RootKeyFile))
+	} else {
+		log.Printf("Intermediate CA created")
+	}
+	return nil
+}
+
+// checkNoIntermediate refuses to replace an intermediate CA in the store unless
+// --force is set
+func checkNoIntermediate(cmd *cobra.Command) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	if exists, _ := afero.Exists(gen.AppFs, gen.StorePath(gen.IntermediateCAFile)); exists && !force {
+		return fmt.Errorf("%s already holds an intermediate CA, use --force to replace it and remove its key",
+			getString(cmd, "output-dir"))
+	}
+	return nil
+}
+
+// removeIntermediate removes the intermediate CA, its key and issuers from the store
+func removeIntermediate() error {
+	for _, f := range []string{gen.IntermediateCAFile, gen.IntermediateKeyFile, gen.IntermediateChainFile} {
+		if err := gen.AppFs.Remove(gen.StorePath(f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(initIntermediateCmd)
+	initIntermediateCmd.Flags().String("common-name", "INTERMEDIATE", "Intermediate certificate common name")
+	initIntermediateCmd.Flags().String("country", "US", "Country name")
+	initIntermediateCmd.Flags().String("state", "CA", "State or Provence")
+	initIntermediateCmd.Flags().String("locality", "San Francisco", "Locality")
+	initIntermediateCmd.Flags().String("organization", "Mesosphere Inc.", "organization")
+	initIntermediateCmd.Flags().StringSlice("email-addresses", []string{"security@mesosphere.com"},
+		"A list of administrative email addresses")
+	initIntermediateCmd.Flags().StringSlice("sans", []string{}, "Subject Alternative Names")
+	initIntermediateCmd.Flags().Int("path-len", 0,
+		"Maximum number of intermediate CAs below this one, negative for no limit")
+	initIntermediateCmd.Flags().Duration("validity", gen.DefaultValidity,
+		"Intermediate certificate lifetime, never longer than the root")
+	initIntermediateCmd.Flags().Bool("force", false, "Replace an existing intermediate CA, removing its key")
+	addNameConstraintFlags(initIntermediateCmd)
+	addKeyFlags(initIntermediateCmd)
+	addSignerFlags(initIntermediateCmd)
+}
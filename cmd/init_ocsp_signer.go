@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+var initOCSPSignerCmd = &cobra.Command{
+	Use:   "init-ocsp-signer",
+	Short: "Initialize a delegated OCSP signing certificate",
+	Long: `Creates a key and certificate, issued by the signing CA, which serve
+uses to sign OCSP responses when started with --ocsp-delegated.`,
+	RunE: initializeOCSPSigner,
+}
+
+func initializeOCSPSigner(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	validity, err := cmd.Flags().GetDuration("validity")
+	if err != nil {
+		return err
+	}
+
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		defer signer.Close()
+	}
+	ca, err := gen.LoadSigningCA(signer)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Generating private key\n")
+	pKey, err := generateKey(cmd)
+	if err != nil {
+		return err
+	}
+
+	cert, err := gen.IssueOCSPSigner(
+		getString(cmd, "common-name"), pKey.Public(), ca.Certificate, ca.Key, validity)
+	if err != nil {
+		return err
+	}
+
+	if err := gen.WritePrivateKey(gen.StorePath(gen.OCSPKeyFile), pKey); err != nil {
+		return err
+	}
+
+	return gen.WriteCertificate(gen.StorePath(gen.OCSPCertFile), cert)
+}
+
+func init() {
+	rootCmd.AddCommand(initOCSPSignerCmd)
+	initOCSPSignerCmd.Flags().String("common-name", "OCSP Responder", "OCSP signing certificate common name")
+	initOCSPSignerCmd.Flags().Duration("validity", defaultOCSPSignerValidity, "OCSP signing certificate lifetime")
+	addKeyFlags(initOCSPSignerCmd)
+	addSignerFlags(initOCSPSignerCmd)
+}
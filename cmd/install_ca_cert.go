@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+var installCACertCmd = &cobra.Command{
+	Use:   "install-ca-cert",
+	Short: "Install the CA certificate signed for the CSR of init-ca --csr-only",
+	Long: `Takes the certificate an external CA issued for the CSR written by
+init-ca --csr-only, verifies that it matches the pending CA key and chains to a
+root in --chain, and installs it as the intermediate serve signs with. The
+chain up to the external root is returned to clients.`,
+	RunE: installCACert,
+}
+
+func installCACert(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+	if err := checkNoCA(cmd); err != nil {
+		return err
+	}
+	cert, chain, err := readCAChain(cmd)
+	if err != nil {
+		return err
+	}
+
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	inToken := signer != nil
+	if !inToken {
+		signer = gen.FileSigner{Path: gen.StorePath(gen.PendingCAKeyFile)}
+	}
+	defer signer.Close()
+	key, err := signer.LoadKey()
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no pending CA key in %s, have you run init-ca --csr-only?", d)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading pending CA key : %v", err)
+	}
+
+	if err := installCA(cert, chain, key, inToken); err != nil {
+		return err
+	}
+	for _, f := range []string{gen.PendingCAKeyFile, gen.PendingCACSRFile} {
+		if err := gen.AppFs.Remove(gen.StorePath(f)); err != nil && !os.IsNotExist(err) {
+			log.Printf("could not remove %s : %v", gen.StorePath(f), err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(installCACertCmd)
+	installCACertCmd.Flags().String("cert", "", "CA certificate issued for the CSR, optionally followed by its issuers")
+	_ = installCACertCmd.MarkFlagRequired("cert")
+	installCACertCmd.Flags().StringSlice("chain", []string{},
+		"PEM bundles of the issuers of the CA, up to and including the root")
+	installCACertCmd.Flags().Bool("force", false, "Replace an existing CA, removing its keys from the store")
+	addSignerFlags(installCACertCmd)
+}
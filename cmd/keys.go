@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"crypto"
+	"strings"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+// addKeyFlags registers the private key generation flags on c
+func addKeyFlags(c *cobra.Command) {
+	c.Flags().String("key-type", defaultKeyType,
+		"Private key type, one of: "+strings.Join(gen.KeyTypes, ", "))
+	c.Flags().Int("key-bits", defaultKeyBits, "RSA key size in bits, ignored for other key types")
+}
+
+// generateKey creates a private key as configured by the flags registered in addKeyFlags
+func generateKey(cmd *cobra.Command) (crypto.Signer, error) {
+	return gen.GenerateKey(getString(cmd, "key-type"), getInt(cmd, "key-bits"))
+}
+
+// addKeyFormatFlag registers the format private keys are written in on c
+func addKeyFormatFlag(c *cobra.Command) {
+	c.PersistentFlags().String("key-format", gen.KeyFormatPKCS1, "Format of unencrypted private keys "+
+		"written, pkcs1 for PKCS#1 RSA and SEC 1 ECDSA keys or pkcs8 for PKCS#8. Encrypted keys are always "+
+		"PKCS#8, keys of any format are read")
+}
+
+// setKeyFormat sets the format of the private keys written by every command
+func setKeyFormat(cmd *cobra.Command) error {
+	return gen.SetKeyFormat(getString(cmd, "key-format"))
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+	"github.com/spf13/cobra"
+)
+
+var listIssuedCmd = &cobra.Command{
+	Use:   "list-issued",
+	Short: "List certificates issued by the CA service",
+	RunE:  listIssued,
+}
+
+func listIssued(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	expiresWithin, err := cmd.Flags().GetDuration("expires-within")
+	if err != nil {
+		return err
+	}
+
+	filter := ledger.Filter{
+		CommonName:    getString(cmd, "cn"),
+		SAN:           getString(cmd, "san"),
+		ExpiresWithin: expiresWithin,
+	}
+
+	records, err := ledger.Open().Records()
+	if err != nil {
+		return err
+	}
+	records = filter.Apply(records, time.Now())
+
+	switch format := getString(cmd, "format"); format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if records == nil {
+			records = []ledger.Record{}
+		}
+		return enc.Encode(records)
+	case "table":
+		return writeRecordTable(records)
+	default:
+		return fmt.Errorf("unknown output format %q, must be json or table", format)
+	}
+}
+
+func writeRecordTable(records []ledger.Record) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tCOMMON NAME\tSANS\tNOT AFTER\tREQUESTER\tSTATUS")
+	for _, r := range records {
+		status := "valid"
+		if r.Revoked() {
+			status = "revoked"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Serial,
+			r.CommonName,
+			strings.Join(r.SANs(), ","),
+			r.NotAfter.Format(time.RFC3339),
+			r.Requester,
+			status,
+		)
+	}
+	return w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(listIssuedCmd)
+	listIssuedCmd.Flags().String("cn", "", "Only list certificates whose common name matches this glob")
+	listIssuedCmd.Flags().String("san", "", "Only list certificates with a subject alternative name matching this glob")
+	listIssuedCmd.Flags().Duration("expires-within", 0,
+		"Only list certificates expiring within this duration, e.g. 720h")
+	listIssuedCmd.Flags().String("format", "table", "Output format, json or table")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+// addKeyPassphraseFlags registers the sources of the passphrase that encrypts
+// private keys at rest. A key encryption key is kept in a file like any other
+// passphrase.
+func addKeyPassphraseFlags(c *cobra.Command) {
+	usage := "Passphrase or key encryption key protecting private keys in the store"
+	c.PersistentFlags().String("key-passphrase-file", "",
+		usage+", read from this file. The file must not be world-readable")
+	c.PersistentFlags().String("key-passphrase-env", "", usage+", read from this environment variable")
+	c.PersistentFlags().Bool("key-passphrase-stdin", false, usage+", read from the first line of stdin")
+}
+
+// readKeyPassphrase returns the key passphrase from the first source set, in
+// order of precedence --key-passphrase-file, --key-passphrase-env and
+// --key-passphrase-stdin. Nil is returned when no source is set.
+func readKeyPassphrase(cmd *cobra.Command) ([]byte, error) {
+	if f := getString(cmd, "key-passphrase-file"); f != "" {
+		passphrase, err := gen.ReadSecretFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key passphrase : %v", err)
+		}
+		return []byte(passphrase), nil
+	}
+
+	if env := getString(cmd, "key-passphrase-env"); env != "" {
+		passphrase, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		return []byte(passphrase), nil
+	}
+
+	fromStdin, err := cmd.Flags().GetBool("key-passphrase-stdin")
+	if err != nil {
+		return nil, err
+	}
+	if fromStdin {
+		passphrase, err := readStdinLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading key passphrase from stdin : %v", err)
+		}
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+// keyPassphrase is the passphrase given on the command line, nil when none was
+var keyPassphrase []byte
+
+// setKeyPassphrase unlocks encrypted keys for every command
+func setKeyPassphrase(cmd *cobra.Command, args []string) error {
+	var err error
+	if keyPassphrase, err = readKeyPassphrase(cmd); err != nil {
+		return err
+	}
+	gen.SetKeyPassphrase(keyPassphrase)
+	return nil
+}
+
+// promptKeyPassphrase asks for the passphrase of the encrypted key at keyFile on
+// the terminal, without echoing it
+func promptKeyPassphrase(keyFile string) error {
+	// stty fails unless stdin is a terminal
+	if err := stty("-echo"); err != nil {
+		return fmt.Errorf("%s is encrypted, set --key-passphrase-file, --key-passphrase-env or "+
+			"--key-passphrase-stdin", keyFile)
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", keyFile)
+	passphrase, err := readStdinLine()
+	_ = stty("echo")
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("error reading key passphrase : %v", err)
+	}
+	gen.SetKeyPassphrase([]byte(passphrase))
+	return nil
+}
+
+func stty(arg string) error {
+	c := exec.Command("stty", arg)
+	c.Stdin = os.Stdin
+	return c.Run()
+}
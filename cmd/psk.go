@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+// stdin is shared by every secret read from stdin, so each reads its own line
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// readStdinLine returns the next line of stdin without the line break
+func readStdinLine() (string, error) {
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// addPSKFlags registers the PSK source flags on c. The plain --psk flag is
+// deprecated because the secret shows up in ps output and shell history.
+func addPSKFlags(c *cobra.Command, usage string) {
+	c.Flags().String("psk-file", "", usage+", read from this file. The file must not be world-readable")
+	c.Flags().String("psk-env", "", usage+", read from this environment variable")
+	c.Flags().Bool("psk-stdin", false, usage+", read from the first line of stdin, "+
+		"the second when --key-passphrase-stdin is set as well")
+	c.Flags().String("psk", "", usage)
+	_ = c.Flags().MarkDeprecated("psk", "the PSK is visible to other users, use --psk-file, --psk-env or --psk-stdin")
+}
+
+// readPSK returns the PSK from the first source set, in order of precedence
+// --psk-file, --psk-env, --psk-stdin and --psk, along with the file it was read
+// from, if any. An empty PSK is returned when no source is set.
+func readPSK(cmd *cobra.Command) (string, string, error) {
+	if f := getString(cmd, "psk-file"); f != "" {
+		psk, err := gen.ReadSecretFile(f)
+		if err != nil {
+			return "", "", fmt.Errorf("error reading PSK : %v", err)
+		}
+		return psk, f, nil
+	}
+
+	if env := getString(cmd, "psk-env"); env != "" {
+		psk, ok := os.LookupEnv(env)
+		if !ok {
+			return "", "", fmt.Errorf("environment variable %s is not set", env)
+		}
+		return psk, "", nil
+	}
+
+	stdin, err := cmd.Flags().GetBool("psk-stdin")
+	if err != nil {
+		return "", "", err
+	}
+	if stdin {
+		psk, err := readStdinLine()
+		if err != nil {
+			return "", "", fmt.Errorf("error reading PSK from stdin : %v", err)
+		}
+		return psk, "", nil
+	}
+
+	return getString(cmd, "psk"), "", nil
+}
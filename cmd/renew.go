@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var renewCmd = &cobra.Command{
+	Use:   "renew <entity>",
+	Short: "Renew a certificate from the CA service, authenticating with the current certificate",
+	RunE:  renew,
+	Args:  cobra.ExactArgs(1),
+}
+
+// entityIdentity returns the TLS client identity of entity from its key and its
+// chain file, falling back to the certificate file when there is no chain
+func entityIdentity(entity string) (*tls.Certificate, error) {
+	key, err := gen.ReadPrivateKey(gen.StorePath(entity + "-key.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	chainFile := gen.StorePath(entity + "-chain.pem")
+	if ok, _ := afero.Exists(gen.AppFs, chainFile); !ok {
+		chainFile = gen.StorePath(entity + "-cert.pem")
+	}
+	chain, err := gen.ReadCertificateChainPEM(chainFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate in %s", chainFile)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func renew(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	opts, err := requestOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	entity := args[0]
+	identity, err := entityIdentity(entity)
+	if err != nil {
+		return fmt.Errorf("could not load the current certificate of %s : %v", entity, err)
+	}
+
+	c, err := newClient(cmd)
+	if err != nil {
+		return err
+	}
+	c.Certificate = identity
+
+	csrBytes, err := gen.GenerateRenewalCSR(identity.Leaf, identity.PrivateKey.(crypto.Signer))
+	if err != nil {
+		return fmt.Errorf("error generating CSR : %v", err)
+	}
+
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
+	resp, err := c.Renew(context.Background(), b, opts)
+	if err != nil {
+		return fmt.Errorf("error renewing certificate : %v", err)
+	}
+	return writeSignResponse(entity, resp)
+}
+
+func init() {
+	rootCmd.AddCommand(renewCmd)
+	renewCmd.Flags().String("url", "", "CA service URL")
+	_ = renewCmd.MarkFlagRequired("url")
+	renewCmd.Flags().String("ca", "", "CA certificate used to verify CA service")
+	renewCmd.Flags().String("profile", "",
+		"Certificate profile, e.g. server, client or peer. The server default is used when empty")
+	renewCmd.Flags().Duration("validity", 0,
+		"Requested certificate lifetime, e.g. 720h. The server may apply a shorter maximum")
+}
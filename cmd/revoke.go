@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+	"github.com/spf13/cobra"
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke <serial>",
+	Short: "Revoke a certificate issued by the CA service",
+	RunE:  revoke,
+	Args:  cobra.ExactArgs(1),
+}
+
+func reasonNames() string {
+	var names []string
+	for name := range ledger.ReasonCodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func revoke(cmd *cobra.Command, args []string) error {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return err
+	}
+
+	reasonName := getString(cmd, "reason")
+	reason, ok := ledger.ReasonCodes[reasonName]
+	if !ok {
+		return fmt.Errorf("unknown revocation reason %q, must be one of: %s", reasonName, reasonNames())
+	}
+
+	r, err := ledger.Open().Revoke(args[0], reason)
+	if err != nil {
+		return err
+	}
+	log.Printf("Revoked certificate - SN: %s CN: %s reason: %s", r.Serial, r.CommonName, reasonName)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(revokeCmd)
+	revokeCmd.Flags().String("reason", "unspecified", "Revocation reason, one of: "+reasonNames())
+}
@@ -36,6 +36,14 @@ func Execute() {
 func init() {
 	rootCmd.PersistentFlags().StringP(
 		"output-dir", "d", defaultOutputDir, "Path to store program files")
+	addKeyPassphraseFlags(rootCmd)
+	addKeyFormatFlag(rootCmd)
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := setKeyFormat(cmd); err != nil {
+			return err
+		}
+		return setKeyPassphrase(cmd, args)
+	}
 }
 
 func getString(cmd *cobra.Command, s string) string {
@@ -53,3 +61,11 @@ func getSlice(cmd *cobra.Command, s string) []string {
 	}
 	return v
 }
+
+func getInt(cmd *cobra.Command, s string) int {
+	v, err := cmd.Flags().GetInt(s)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	return v
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"errors"
+
 	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
 	"github.com/mesosphere/dcos-bootstrap-ca/pkg/server"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
@@ -22,7 +25,100 @@ func runServer(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	server.RunServer(getString(cmd, "address"), getString(cmd, "psk"))
+	signer, err := openSigner(cmd)
+	if err != nil {
+		return err
+	}
+	if signer == nil {
+		// the signing key is unlocked at startup when no passphrase was given
+		keyFile := gen.StorePath(gen.RootKeyFile)
+		if ok, _ := afero.Exists(gen.AppFs, gen.StorePath(gen.IntermediateKeyFile)); ok {
+			keyFile = gen.StorePath(gen.IntermediateKeyFile)
+		}
+		if _, err := gen.ReadPrivateKey(keyFile); errors.Is(err, gen.ErrKeyLocked) {
+			if err := promptKeyPassphrase(keyFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	crlValidity, err := cmd.Flags().GetDuration("crl-validity")
+	if err != nil {
+		return err
+	}
+
+	ocspValidity, err := cmd.Flags().GetDuration("ocsp-validity")
+	if err != nil {
+		return err
+	}
+
+	ocspDelegated, err := cmd.Flags().GetBool("ocsp-delegated")
+	if err != nil {
+		return err
+	}
+
+	maxValidity, err := cmd.Flags().GetDuration("max-validity")
+	if err != nil {
+		return err
+	}
+
+	backdate, err := cmd.Flags().GetDuration("backdate")
+	if err != nil {
+		return err
+	}
+
+	authBackoff, err := cmd.Flags().GetDuration("auth-backoff")
+	if err != nil {
+		return err
+	}
+
+	authMaxBackoff, err := cmd.Flags().GetDuration("auth-max-backoff")
+	if err != nil {
+		return err
+	}
+
+	insecureNoAuth, err := cmd.Flags().GetBool("insecure-no-auth")
+	if err != nil {
+		return err
+	}
+
+	acme, err := cmd.Flags().GetBool("acme")
+	if err != nil {
+		return err
+	}
+
+	acmeHTTP01, err := cmd.Flags().GetBool("acme-http01")
+	if err != nil {
+		return err
+	}
+
+	psk, pskFile, err := readPSK(cmd)
+	if err != nil {
+		return err
+	}
+
+	server.RunServer(server.Config{
+		Address:        getString(cmd, "address"),
+		Psk:            psk,
+		PskFile:        pskFile,
+		InsecureNoAuth: insecureNoAuth,
+		CRLURL:         getString(cmd, "crl-url"),
+		CRLValidity:    crlValidity,
+		OCSPURL:        getString(cmd, "ocsp-url"),
+		OCSPDelegated:  ocspDelegated,
+		OCSPValidity:   ocspValidity,
+		MaxValidity:    maxValidity,
+		Backdate:       backdate,
+		AuthFailures:   getInt(cmd, "auth-failures"),
+		AuthBackoff:    authBackoff,
+		AuthMaxBackoff: authMaxBackoff,
+		ProfilesFile:   getString(cmd, "profiles"),
+		PolicyFile:     getString(cmd, "policy"),
+		ACME:           acme,
+		ACMEHTTP01:     acmeHTTP01,
+		ACMEHTTP01Port: getInt(cmd, "acme-http01-port"),
+		Signer:         signer,
+	})
 
 	return nil
 }
@@ -30,6 +126,37 @@ func runServer(cmd *cobra.Command, args []string) error {
 func init() {
 	rootCmd.AddCommand(initServeCmd)
 	initServeCmd.Flags().String("address", ":8443", "The address to listen on")
-	initServeCmd.Flags().String("psk", "", "Pre-shared Key to start the server with. Clients must "+
-		"authenticate using this Key")
+	addPSKFlags(initServeCmd, "Pre-shared Key clients must authenticate with")
+	initServeCmd.Flags().Bool("insecure-no-auth", false,
+		"Start without a PSK and sign requests from anyone. Only meant for testing")
+	initServeCmd.Flags().Int("auth-failures", defaultAuthFailures,
+		"Failed authentications allowed per source IP before it is locked out")
+	initServeCmd.Flags().Duration("auth-backoff", defaultAuthBackoff,
+		"First lockout after too many failed authentications, doubled for every further failure. 0 disables lockouts")
+	initServeCmd.Flags().Duration("auth-max-backoff", defaultAuthMaxBackoff, "Longest lockout of a source IP")
+	initServeCmd.Flags().String("crl-url", "", "CRL distribution point embedded in issued certificates, "+
+		"e.g. https://bootstrap:8443/crl/v1/current")
+	initServeCmd.Flags().Duration("crl-validity", defaultCRLValidity, "Time until the next CRL update")
+	initServeCmd.Flags().String("ocsp-url", "", "Enables the OCSP responder and embeds this URL in issued "+
+		"certificates, e.g. https://bootstrap:8443/ocsp")
+	initServeCmd.Flags().Bool("ocsp-delegated", false,
+		"Sign OCSP responses with the certificate created by init-ocsp-signer instead of the CA key")
+	initServeCmd.Flags().Duration("max-validity", gen.DefaultValidity,
+		"Maximum lifetime of signed certificates, clients may request shorter lifetimes")
+	initServeCmd.Flags().Duration("backdate", 0,
+		"Start signed certificate validity this far in the past to tolerate clock skew")
+	initServeCmd.Flags().String("profiles", "",
+		"YAML or JSON file defining certificate profiles, extends the built-in "+
+			"server, client, peer and code-signing profiles. CA certificates are only issued with a profile "+
+			"from this file setting allow_ca")
+	initServeCmd.Flags().String("policy", "",
+		"YAML or JSON signing policy restricting names, subjects and keys of signed CSRs")
+	initServeCmd.Flags().Duration("ocsp-validity", defaultOCSPValidity, "Time until the next OCSP response update")
+	initServeCmd.Flags().Bool("acme", false, "Serve an ACME directory at /acme/directory. Accounts are bound "+
+		"through External Account Binding to the PSK, with key ID psk and the base64url encoded PSK as HMAC key, "+
+		"or to a bootstrap token as printed by token create, each certificate uses it once")
+	initServeCmd.Flags().Bool("acme-http01", false,
+		"Validate ACME identifiers with http-01 challenges instead of trusting the account binding")
+	initServeCmd.Flags().Int("acme-http01-port", defaultACMEHTTP01Port, "Port http-01 challenges are fetched from")
+	addSignerFlags(initServeCmd)
 }
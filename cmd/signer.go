@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/cobra"
+)
+
+// Signer backends selected with --signer
+const (
+	signerFile   = "file"
+	signerPKCS11 = "pkcs11"
+)
+
+// addSignerFlags registers the flags selecting where the CA key is kept on c
+func addSignerFlags(c *cobra.Command) {
+	c.Flags().String("signer", signerFile, "Where the CA key is kept, file for the store or pkcs11 for a "+
+		"PKCS#11 token")
+	c.Flags().String("pkcs11-module", "", "PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so")
+	c.Flags().Uint("pkcs11-slot", 0, "PKCS#11 slot of the token holding the CA key")
+	c.Flags().String("pkcs11-label", "", "Label of the CA key pair in the PKCS#11 token")
+	c.Flags().String("pkcs11-pin-file", "", "User PIN of the PKCS#11 token, read from this file. "+
+		"The file must not be world-readable")
+	c.Flags().String("pkcs11-pin-env", "", "User PIN of the PKCS#11 token, read from this environment variable")
+}
+
+// openSigner returns the signer backend selected by the flags registered in
+// addSignerFlags, nil when the CA key is kept in the store
+func openSigner(cmd *cobra.Command) (gen.SignerBackend, error) {
+	switch signer := getString(cmd, "signer"); signer {
+	case signerFile:
+		return nil, nil
+	case signerPKCS11:
+	default:
+		return nil, fmt.Errorf("unsupported signer %s, must be %s or %s", signer, signerFile, signerPKCS11)
+	}
+
+	config := gen.PKCS11Config{
+		Module: getString(cmd, "pkcs11-module"),
+		Label:  getString(cmd, "pkcs11-label"),
+	}
+	if config.Module == "" || config.Label == "" {
+		return nil, fmt.Errorf("--pkcs11-module and --pkcs11-label are required by the %s signer", signerPKCS11)
+	}
+	var err error
+	if config.Slot, err = cmd.Flags().GetUint("pkcs11-slot"); err != nil {
+		return nil, err
+	}
+
+	if f := getString(cmd, "pkcs11-pin-file"); f != "" {
+		if config.PIN, err = gen.ReadSecretFile(f); err != nil {
+			return nil, fmt.Errorf("error reading PKCS#11 PIN : %v", err)
+		}
+	} else if env := getString(cmd, "pkcs11-pin-env"); env != "" {
+		var ok bool
+		if config.PIN, ok = os.LookupEnv(env); !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+	}
+	return gen.OpenPKCS11(config)
+}
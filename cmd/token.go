@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/token"
+	"github.com/spf13/cobra"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage one-time bootstrap tokens accepted by the CA service",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a bootstrap token and print it to stdout",
+	RunE:  tokenCreate,
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List usable bootstrap tokens",
+	RunE:  tokenList,
+}
+
+var tokenDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete a bootstrap token",
+	RunE:  tokenDelete,
+	Args:  cobra.ExactArgs(1),
+}
+
+func openTokenStore(cmd *cobra.Command) (*token.Store, error) {
+	d, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return nil, err
+	}
+	if err := gen.InitStorage(d); err != nil {
+		return nil, err
+	}
+	return token.Open(), nil
+}
+
+func tokenCreate(cmd *cobra.Command, args []string) error {
+	store, err := openTokenStore(cmd)
+	if err != nil {
+		return err
+	}
+
+	ttl, err := cmd.Flags().GetDuration("ttl")
+	if err != nil {
+		return err
+	}
+
+	secret, t, err := store.Create(ttl, getInt(cmd, "uses"), getSlice(cmd, "allowed-cn"))
+	if err != nil {
+		return err
+	}
+	log.Printf("Created token %s - uses: %d expires: %s", t.ID, t.Uses, t.ExpiresAt.Format(time.RFC3339))
+	log.Printf("ACME external account binding - key ID: %s HMAC key: %s",
+		t.ID, base64.RawURLEncoding.EncodeToString(token.EABKey(secret)))
+	fmt.Println(secret)
+	return nil
+}
+
+func tokenList(cmd *cobra.Command, args []string) error {
+	store, err := openTokenStore(cmd)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSES LEFT\tEXPIRES\tALLOWED CN")
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n",
+			t.ID,
+			t.Remaining(),
+			t.ExpiresAt.Format(time.RFC3339),
+			strings.Join(t.AllowedCN, ","),
+		)
+	}
+	return w.Flush()
+}
+
+func tokenDelete(cmd *cobra.Command, args []string) error {
+	store, err := openTokenStore(cmd)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(args[0]); err != nil {
+		return err
+	}
+	log.Printf("Deleted token %s", args[0])
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenDeleteCmd)
+	tokenCreateCmd.Flags().Duration("ttl", defaultTokenTTL, "Time until the token expires")
+	tokenCreateCmd.Flags().Int("uses", 1, "Number of certificates the token may request")
+	tokenCreateCmd.Flags().StringSlice("allowed-cn", []string{},
+		"Globs the common name, DNS names and IP addresses of requested certificates must match, e.g. 'master-*'. Any name when empty")
+}
@@ -1,10 +1,18 @@
 module github.com/mesosphere/dcos-bootstrap-ca
 
-go 1.12
+go 1.21
 
 require (
+	github.com/miekg/pkcs11 v1.1.2
 	github.com/pavel-v-chernykh/keystore-go v2.1.0+incompatible
 	github.com/spf13/afero v1.1.2
 	github.com/spf13/cobra v0.0.5
-	golang.org/x/text v0.3.2 // indirect
+	golang.org/x/crypto v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
@@ -0,0 +1,87 @@
+// Request and response types of the CA service HTTP API, shared by the service
+// and its clients
+
+package api
+
+import "time"
+
+// Formats of the key and certificate returned by the /csr/v1/issue endpoint
+const (
+	IssueFormatPEM    = "pem"
+	IssueFormatPKCS12 = "pkcs12"
+)
+
+// SignRequest represents the JSON payload for the /csr/v1/sign endpoint
+type SignRequest struct {
+	Psk      string `json:"psk,omitempty"`
+	Token    string `json:"token,omitempty"` // one-time bootstrap token, used instead of the PSK when set
+	Csr      string `json:"csr"`
+	Validity string `json:"validity,omitempty"` // requested lifetime, e.g. "720h"
+	Profile  string `json:"profile,omitempty"`  // certificate profile, the server default when empty
+
+	// HMAC authentication set by SignWithPSK, used instead of the PSK when MAC is set
+	Timestamp int64  `json:"timestamp,omitempty"` // Unix time the request was signed at
+	Nonce     string `json:"nonce,omitempty"`
+	MAC       string `json:"mac,omitempty"` // hex encoded HMAC-SHA256
+}
+
+// SignResponse represents the JSON response for the /csr/v1/sign endpoint. Chain
+// contains the signed certificate followed by every issuer up to the root.
+type SignResponse struct {
+	Certificate string    `json:"certificate"`
+	Chain       string    `json:"chain"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Validity    string    `json:"validity"` // effective lifetime after server limits were applied
+}
+
+// CACertificate describes a certificate of the issuer chain
+type CACertificate struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the DER encoding, see gen.Fingerprint
+	NotAfter    time.Time `json:"not_after"`
+	Root        bool      `json:"root"`
+	Certificate string    `json:"certificate"` // PEM encoded
+}
+
+// CACertificatesResponse represents the JSON response of the /ca/v1/certificates
+// endpoint. Certificates start at the signing CA and end at the root.
+type CACertificatesResponse struct {
+	Certificates []CACertificate `json:"certificates"`
+}
+
+// IssueRequest represents the JSON payload for the /csr/v1/issue endpoint, which
+// generates the key on behalf of the client. It authenticates like SignRequest.
+type IssueRequest struct {
+	Psk   string `json:"psk,omitempty"`
+	Token string `json:"token,omitempty"` // one-time bootstrap token, used instead of the PSK when set
+
+	CommonName     string   `json:"common_name"`
+	Country        string   `json:"country,omitempty"`
+	State          string   `json:"state,omitempty"`
+	Locality       string   `json:"locality,omitempty"`
+	Organization   string   `json:"organization,omitempty"`
+	Sans           []string `json:"sans,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+
+	KeyType  string `json:"key_type,omitempty"` // one of gen.KeyTypes, ecdsa-p256 when empty
+	KeyBits  int    `json:"key_bits,omitempty"` // RSA modulus size, gen.MinRSAKeyBits when zero
+	Validity string `json:"validity,omitempty"` // requested lifetime, e.g. "720h"
+	Profile  string `json:"profile,omitempty"`  // certificate profile, the server default when empty
+
+	Format   string `json:"format,omitempty"`   // IssueFormatPEM when empty or IssueFormatPKCS12
+	Password string `json:"password,omitempty"` // protects the key store, required for IssueFormatPKCS12
+
+	// HMAC authentication set by SignWithPSK, used instead of the PSK when MAC is set
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	MAC       string `json:"mac,omitempty"`
+}
+
+// IssueResponse represents the JSON response of the /csr/v1/issue endpoint for
+// IssueFormatPEM. PrivateKey is PEM encoded like the keys written by init-entity.
+type IssueResponse struct {
+	SignResponse
+	PrivateKey string `json:"private_key"`
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MACMaxSkew is the largest difference between the timestamp of an HMAC signed
+// request and the server clock that is accepted
+const MACMaxSkew = 5 * time.Minute
+
+// MACRequest is a request that can be authenticated with an HMAC keyed by the PSK
+type MACRequest interface {
+	// MACMessage returns the data authenticated by the MAC
+	MACMessage() []byte
+	MACFields() (timestamp int64, nonce, mac string)
+}
+
+// MACMessage is the data authenticated by the MAC of a SignRequest
+func (r *SignRequest) MACMessage() []byte {
+	return []byte(strings.Join([]string{
+		strconv.FormatInt(r.Timestamp, 10),
+		r.Nonce,
+		r.Csr,
+		r.Validity,
+		r.Profile,
+	}, "\n"))
+}
+
+// MACFields returns the timestamp, nonce and MAC of the request
+func (r *SignRequest) MACFields() (int64, string, string) {
+	return r.Timestamp, r.Nonce, r.MAC
+}
+
+// SignWithPSK authenticates the request with an HMAC-SHA256 keyed by psk over a
+// timestamp, a random nonce, the CSR and the requested options. The PSK itself
+// is not sent.
+func (r *SignRequest) SignWithPSK(psk string) error {
+	nonce, err := newMACNonce()
+	if err != nil {
+		return err
+	}
+	r.Psk = ""
+	r.Timestamp = time.Now().Unix()
+	r.Nonce = nonce
+	r.MAC = ComputeMAC(r, psk)
+	return nil
+}
+
+// MACMessage is the data authenticated by the MAC of an IssueRequest
+func (r *IssueRequest) MACMessage() []byte {
+	return []byte(strings.Join([]string{
+		strconv.FormatInt(r.Timestamp, 10),
+		r.Nonce,
+		r.CommonName,
+		r.Country,
+		r.State,
+		r.Locality,
+		r.Organization,
+		strings.Join(r.Sans, ","),
+		strings.Join(r.EmailAddresses, ","),
+		r.KeyType,
+		strconv.Itoa(r.KeyBits),
+		r.Validity,
+		r.Profile,
+		r.Format,
+		r.Password,
+	}, "\n"))
+}
+
+// MACFields returns the timestamp, nonce and MAC of the request
+func (r *IssueRequest) MACFields() (int64, string, string) {
+	return r.Timestamp, r.Nonce, r.MAC
+}
+
+// SignWithPSK authenticates the request with an HMAC-SHA256 keyed by psk over a
+// timestamp, a random nonce and every requested property. The PSK itself is not
+// sent.
+func (r *IssueRequest) SignWithPSK(psk string) error {
+	nonce, err := newMACNonce()
+	if err != nil {
+		return err
+	}
+	r.Psk = ""
+	r.Timestamp = time.Now().Unix()
+	r.Nonce = nonce
+	r.MAC = ComputeMAC(r, psk)
+	return nil
+}
+
+// ComputeMAC returns the hex encoded HMAC-SHA256 of r keyed by psk
+func ComputeMAC(r MACRequest, psk string) string {
+	mac := hmac.New(sha256.New, []byte(psk))
+	mac.Write(r.MACMessage())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newMACNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// VerifyMAC checks the MAC of r against psk and that its timestamp is within
+// MACMaxSkew of now. Replayed nonces are left to the caller.
+func VerifyMAC(r MACRequest, psk string, now time.Time) error {
+	timestamp, nonce, mac := r.MACFields()
+	if nonce == "" {
+		return errors.New("request nonce is missing")
+	}
+	ts := time.Unix(timestamp, 0)
+	if skew := now.Sub(ts); skew > MACMaxSkew || skew < -MACMaxSkew {
+		return fmt.Errorf("request timestamp %s is outside the accepted window of %s", ts.UTC(), MACMaxSkew)
+	}
+	expected, _ := hex.DecodeString(ComputeMAC(r, psk))
+	actual, err := hex.DecodeString(mac)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return errors.New("request MAC is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+// Client for the CA service signing API
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/policy"
+)
+
+// DefaultTimeout bounds a single attempt when Client.Timeout is zero
+const DefaultTimeout = 30 * time.Second
+
+// maxResponseBody bounds the size of responses read from the service
+const maxResponseBody = 1 << 20
+
+// RetryPolicy controls how requests failing with a network error, a 5xx or a
+// 429 status are retried. Other errors are returned immediately. Requests
+// carrying a bootstrap token are never retried, the service may have redeemed
+// the token for an attempt whose response was lost.
+type RetryPolicy struct {
+	Attempts   int           // total number of attempts, a single attempt when zero
+	Backoff    time.Duration // wait before the first retry, doubled for every further retry
+	MaxBackoff time.Duration // longest wait between attempts, unbounded when zero
+}
+
+// Client talks to the CA service at BaseURL. Sign authenticates with Token when
+// set, otherwise with PSK, which is only sent in the clear unless HMAC is set.
+// Renew authenticates with Certificate. The fields must not be changed once a
+// request has been made.
+type Client struct {
+	BaseURL *url.URL
+	RootCAs *x509.CertPool // verifies the service, the system roots are used when nil
+	// SHA-256 fingerprint of the root CA, see gen.ParseFingerprint. When set the
+	// service is verified against the root it presents if that root matches,
+	// which allows a first contact before the root has been distributed.
+	RootFingerprint string
+	PSK             string           // pre-shared key the service was started with
+	HMAC            bool             // authenticate with an HMAC keyed by PSK instead of sending it
+	Token           string           // one-time bootstrap token, used instead of the PSK
+	Certificate     *tls.Certificate // client certificate presented to the service
+	Timeout         time.Duration    // bound of a single attempt, DefaultTimeout when zero
+	Retry           RetryPolicy
+
+	httpClient *http.Client
+}
+
+// Options are the certificate properties requested with a CSR
+type Options struct {
+	Profile  string        // certificate profile, the service default when empty
+	Validity time.Duration // requested lifetime, the service maximum when zero
+}
+
+// New returns a Client for the service at baseURL, verified with rootCAs
+func New(baseURL string, rootCAs *x509.CertPool) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing url : %v", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("CA service url must be an https URL, got %q", baseURL)
+	}
+	return &Client{BaseURL: u, RootCAs: rootCAs}, nil
+}
+
+func (c *Client) endpoint(elem ...string) string {
+	u := *c.BaseURL
+	u.Path = path.Join(append([]string{u.Path}, elem...)...)
+	return u.String()
+}
+
+func (c *Client) client() *http.Client {
+	if c.httpClient == nil {
+		tlsConfig := &tls.Config{RootCAs: c.RootCAs, MinVersion: tls.VersionTLS12}
+		if c.RootFingerprint != "" {
+			// verification is done by verifyPinned instead
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+				return verifyPinned(cs, c.RootFingerprint, c.BaseURL.Hostname())
+			}
+		}
+		if c.Certificate != nil {
+			tlsConfig.Certificates = []tls.Certificate{*c.Certificate}
+		}
+		c.httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}}
+	}
+	return c.httpClient
+}
+
+// do sends the request built by newRequest, retrying it according to the retry
+// policy when retry is set, and returns the body of the first successful
+// response. newRequest is called for every attempt.
+func (c *Client) do(ctx context.Context, retry bool,
+	newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	backoff := c.Retry.Backoff
+
+	for attempt := 1; ; attempt++ {
+		body, wait, err := c.attempt(ctx, timeout, newRequest)
+		if err == nil {
+			return body, nil
+		}
+		var e *Error
+		if !retry || errors.As(err, &e) && !e.temporary() || attempt >= c.Retry.Attempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		if wait < backoff {
+			wait = backoff
+		}
+		if c.Retry.MaxBackoff > 0 && wait > c.Retry.MaxBackoff {
+			wait = c.Retry.MaxBackoff
+		}
+		backoff *= 2
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt sends a single request. The Retry-After delay of the response is
+// returned along with errors.
+func (c *Client) attempt(ctx context.Context, timeout time.Duration,
+	newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error talking to service : %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading response : %v", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return body, 0, nil
+	}
+
+	e := &Error{StatusCode: resp.StatusCode, Message: string(body)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if resp.StatusCode == http.StatusForbidden && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		v := &policy.Violation{}
+		if json.Unmarshal(body, v) == nil {
+			e.Violation = v
+		}
+	}
+	return nil, e.RetryAfter, e
+}
+
+// postSignRequest sends signReq to the endpoint below /csr/v1. HMAC requests
+// are signed again for every attempt so retries carry a fresh nonce.
+func (c *Client) postSignRequest(ctx context.Context, endpoint string, signReq api.SignRequest) (*api.SignResponse, error) {
+	body, err := c.do(ctx, signReq.Token == "", func(ctx context.Context) (*http.Request, error) {
+		r := signReq
+		if c.HMAC && r.Psk != "" {
+			if err := r.SignWithPSK(c.PSK); err != nil {
+				return nil, err
+			}
+		}
+		j, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint("csr", "v1", endpoint), bytes.NewReader(j))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.SignResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("error parsing json : %v", err)
+	}
+	return resp, nil
+}
+
+func signRequest(csrPEM []byte, opts Options) api.SignRequest {
+	r := api.SignRequest{Csr: string(csrPEM), Profile: opts.Profile}
+	if opts.Validity > 0 {
+		r.Validity = opts.Validity.String()
+	}
+	return r
+}
+
+// Sign requests a certificate for a PEM encoded CSR, authenticating with the
+// bootstrap token or the PSK
+func (c *Client) Sign(ctx context.Context, csrPEM []byte, opts Options) (*api.SignResponse, error) {
+	r := signRequest(csrPEM, opts)
+	switch {
+	case c.Token != "":
+		r.Token = c.Token
+	case c.PSK != "":
+		r.Psk = c.PSK
+	default:
+		return nil, errors.New("a PSK or a bootstrap token is required")
+	}
+	return c.postSignRequest(ctx, "sign", r)
+}
+
+// Renew requests a certificate for a PEM encoded CSR, authenticating with the
+// client certificate. The CSR must have the subject and names of that
+// certificate, see gen.GenerateRenewalCSR.
+func (c *Client) Renew(ctx context.Context, csrPEM []byte, opts Options) (*api.SignResponse, error) {
+	if c.Certificate == nil {
+		return nil, errors.New("a client certificate is required for renewals")
+	}
+	return c.postSignRequest(ctx, "renew", signRequest(csrPEM, opts))
+}
+
+// FetchCA returns the issuer chain of the service, the signing CA first and
+// the root last
+func (c *Client) FetchCA(ctx context.Context) ([]*x509.Certificate, error) {
+	body, err := c.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", c.endpoint("ca", "v1", "certificates"), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []*x509.Certificate
+	for block, rest := pem.Decode(body); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CA certificates : %v", err)
+		}
+		certificates = append(certificates, cert)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("the service returned no CA certificates")
+	}
+	return certificates, nil
+}
+
+// FetchRoot returns the root CA of the service. When RootFingerprint is set the
+// root must match it.
+func (c *Client) FetchRoot(ctx context.Context) (*x509.Certificate, error) {
+	chain, err := c.FetchCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+	root := chain[len(chain)-1]
+	if c.RootFingerprint != "" && gen.Fingerprint(root.Raw) != c.RootFingerprint {
+		return nil, fmt.Errorf("root CA fingerprint %s does not match %s", gen.Fingerprint(root.Raw), c.RootFingerprint)
+	}
+	return root, nil
+}
+
+// verifyPinned accepts a TLS connection whose peer chain verifies up to a
+// presented certificate with the fingerprint pin
+func verifyPinned(cs tls.ConnectionState, pin, host string) error {
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	pinned := false
+	for _, cert := range cs.PeerCertificates {
+		if gen.Fingerprint(cert.Raw) == pin {
+			roots.AddCert(cert)
+			pinned = true
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if !pinned {
+		return fmt.Errorf("the service did not present a CA with fingerprint %s", pin)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	c, err := New(srv.URL, pool)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	return c
+}
+
+func TestSign(t *testing.T) {
+	var received api.SignRequest
+	c := testClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/csr/v1/sign" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		received = api.SignRequest{}
+		_ = json.NewDecoder(req.Body).Decode(&received)
+		_ = json.NewEncoder(w).Encode(api.SignResponse{Certificate: "cert", Validity: "1h0m0s"})
+	})
+
+	if _, err := c.Sign(context.Background(), []byte("csr"), Options{}); err == nil {
+		t.Fatalf("request without credentials was sent")
+	}
+
+	c.PSK = "secret"
+	resp, err := c.Sign(context.Background(), []byte("csr"), Options{Profile: "server", Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	if resp.Certificate != "cert" || received.Psk != "secret" || received.Profile != "server" ||
+		received.Validity != "1h0m0s" {
+		t.Fatalf("unexpected request %+v or response %+v", received, resp)
+	}
+
+	c.HMAC = true
+	if _, err := c.Sign(context.Background(), []byte("csr"), Options{}); err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	if received.Psk != "" || received.MAC == "" || received.Nonce == "" {
+		t.Fatalf("HMAC request sent the PSK or no MAC: %+v", received)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	status := http.StatusUnauthorized
+	c := testClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if status == http.StatusForbidden {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"scope":"global","rule":"allowed_dns","value":"a.b","message":"not allowed"}`))
+			return
+		}
+		http.Error(w, "Key is invalid", status)
+	})
+	c.PSK = "wrong"
+
+	_, err := c.Sign(context.Background(), []byte("csr"), Options{})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected an authentication error, got %v", err)
+	}
+
+	status = http.StatusForbidden
+	_, err = c.Sign(context.Background(), []byte("csr"), Options{})
+	var e *Error
+	if !errors.Is(err, ErrForbidden) || !errors.As(err, &e) || e.Violation == nil || e.Violation.Rule != "allowed_dns" {
+		t.Fatalf("expected a policy violation, got %v", err)
+	}
+
+	if _, err := c.Renew(context.Background(), []byte("csr"), Options{}); err == nil {
+		t.Fatalf("renewal without a client certificate was sent")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var attempts int32
+	status := http.StatusServiceUnavailable
+	c := testClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			http.Error(w, "unavailable", status)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.SignResponse{Certificate: "cert"})
+	})
+	c.PSK = "secret"
+	c.Retry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	if _, err := c.Sign(context.Background(), []byte("csr"), Options{}); err != nil {
+		t.Fatalf("error after retries: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	status = http.StatusBadRequest
+	_, err := c.Sign(context.Background(), []byte("csr"), Options{})
+	if !errors.Is(err, ErrBadRequest) || attempts != 1 {
+		t.Fatalf("bad request was retried or not reported: %d attempts, %v", attempts, err)
+	}
+	// the token may have been redeemed by a request whose response failed
+	atomic.StoreInt32(&attempts, 0)
+	status = http.StatusServiceUnavailable
+	c.Token = "token"
+	if _, err := c.Sign(context.Background(), []byte("csr"), Options{}); err == nil || attempts != 1 {
+		t.Fatalf("request with a bootstrap token was retried: %d attempts, %v", attempts, err)
+	}
+}
+
+func TestFetchRootPinned(t *testing.T) {
+	// the test server presents a self-signed certificate, which is its root
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/ca/v1/certificates" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(gen.EncodeCertificatesPEM(srv.Certificate().Raw))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	c.RootFingerprint = gen.Fingerprint(srv.Certificate().Raw)
+	root, err := c.FetchRoot(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching pinned root: %v", err)
+	}
+	if !root.Equal(srv.Certificate()) {
+		t.Fatalf("fetched root %s, expected %s", root.Subject, srv.Certificate().Subject)
+	}
+
+	c, _ = New(srv.URL, nil)
+	c.RootFingerprint = gen.Fingerprint([]byte("another root"))
+	if _, err := c.FetchRoot(context.Background()); err == nil {
+		t.Fatalf("service was trusted without presenting the pinned root")
+	}
+}
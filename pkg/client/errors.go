@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/policy"
+)
+
+// Errors an *Error unwraps to, depending on the HTTP status of the response
+var (
+	ErrBadRequest   = errors.New("request rejected as invalid")
+	ErrUnauthorized = errors.New("authentication failed")
+	ErrForbidden    = errors.New("request not allowed")
+	ErrNotFound     = errors.New("endpoint not found")
+	ErrRateLimited  = errors.New("too many failed authentications")
+	ErrServer       = errors.New("CA service error")
+)
+
+// Error is returned when the CA service answers with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+	Violation  *policy.Violation // the signing policy rule the CSR failed, if any
+	RetryAfter time.Duration     // wait requested by the service with a 429 or 503 status
+}
+
+func (e *Error) Error() string {
+	if e.Violation != nil {
+		return fmt.Sprintf("%d %s: %v", e.StatusCode, http.StatusText(e.StatusCode), e.Violation)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.TrimSpace(e.Message))
+}
+
+// Unwrap maps the status code to one of the error variables of this package so
+// callers can test errors with errors.Is
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServer
+	default:
+		return ErrBadRequest
+	}
+}
+
+// temporary reports whether the request may succeed when retried
+func (e *Error) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
@@ -0,0 +1,99 @@
+// Signing certificate authority
+
+package gen
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/spf13/afero"
+)
+
+// SigningCA is the certificate authority used to issue certificates. It is the
+// intermediate CA when one has been initialized, otherwise the root CA.
+type SigningCA struct {
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+	Chain       [][]byte // DER encoded chain starting at Certificate and ending at the root
+	Root        *x509.Certificate
+}
+
+// keyMatches reports whether key is the private key of cert
+func keyMatches(cert *x509.Certificate, key crypto.Signer) bool {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+// IsIntermediate reports whether the signing CA is an intermediate
+func (ca *SigningCA) IsIntermediate() bool {
+	return len(ca.Chain) > 1
+}
+
+// LoadSigningCA reads the signing CA from the store. When an intermediate is
+// present the root key is never read, so it can be kept offline. The issuers
+// between an imported intermediate and its root are read from
+// IntermediateChainFile. The key is taken from keys, or read from the store when
+// keys is nil, and must belong to the signing certificate.
+func LoadSigningCA(keys SignerBackend) (*SigningCA, error) {
+	rootBytes, err := ReadCertificatePEM(StorePath(RootCAFile))
+	if err != nil {
+		return nil, err
+	}
+	root, err := x509.ParseCertificate(rootBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	certFile, keyFile := RootCAFile, RootKeyFile
+	intermediate, err := afero.Exists(AppFs, StorePath(IntermediateCAFile))
+	if err != nil {
+		return nil, err
+	}
+	if intermediate {
+		certFile, keyFile = IntermediateCAFile, IntermediateKeyFile
+	}
+
+	certBytes, err := ReadCertificatePEM(StorePath(certFile))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys == nil {
+		keys = FileSigner{Path: StorePath(keyFile)}
+	}
+	key, err := keys.LoadKey()
+	if err != nil {
+		return nil, err
+	}
+	if !keyMatches(cert, key) {
+		// e.g. a token holding the root key while an intermediate has been initialized
+		return nil, fmt.Errorf("CA key does not match %s %s", StorePath(certFile), cert.Subject)
+	}
+
+	ca := &SigningCA{
+		Certificate: cert,
+		Key:         key,
+		Chain:       [][]byte{certBytes},
+		Root:        root,
+	}
+	if intermediate {
+		issuers, err := afero.Exists(AppFs, StorePath(IntermediateChainFile))
+		if err != nil {
+			return nil, err
+		}
+		if issuers {
+			chain, err := ReadCertificateChainPEM(StorePath(IntermediateChainFile))
+			if err != nil {
+				return nil, err
+			}
+			ca.Chain = append(ca.Chain, chain...)
+		}
+		ca.Chain = append(ca.Chain, rootBytes)
+	}
+	return ca, nil
+}
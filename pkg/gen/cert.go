@@ -3,24 +3,29 @@
 package gen
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
 	"log"
 	"math/big"
 	"net"
 	"time"
 )
 
-const validFor = time.Hour * 24 * 365 * 100
+// DefaultValidity is the certificate lifetime used when none is configured
+const DefaultValidity = time.Hour * 24 * 365 * 100
 
 // BasicCertificateConfig DI for certificate generation
 type BasicCertificateConfig struct {
-	name           pkix.Name // Subject data for x509 certificates
-	isCA           bool      // true if the certificate can sign other certificates
-	hosts          []string  // A list of DNS names and ip addresses
-	emailAddresses []string  // administrative email address associated with the certificate
+	name           pkix.Name     // Subject data for x509 certificates
+	isCA           bool          // true if the certificate can sign other certificates
+	maxPathLen     int           // CA path length constraint, negative when unconstrained
+	validity       time.Duration // certificate lifetime
+	constraints    NameConstraints
+	hosts          []string // A list of DNS names and ip addresses
+	emailAddresses []string // administrative email address associated with the certificate
 }
 
 // MakeCertificateConfig packs a pkix.Name struct and returns a BasicCertificateConfig structure
@@ -39,11 +44,47 @@ func MakeCertificateConfig(name, country, state, locality, organization string,
 		name:           dn,
 		emailAddresses: emailAddresses,
 		isCA:           ca,
+		maxPathLen:     -1,
+		validity:       DefaultValidity,
 		hosts:          hosts,
 	}
 
 }
 
+// SetMaxPathLen limits the number of intermediate CAs that may follow a CA
+// certificate. A negative value leaves the path length unconstrained.
+func (c *BasicCertificateConfig) SetMaxPathLen(n int) {
+	c.maxPathLen = n
+}
+
+// SetValidity sets the certificate lifetime
+func (c *BasicCertificateConfig) SetValidity(d time.Duration) {
+	c.validity = d
+}
+
+// validityPeriod returns the notBefore and notAfter times for a certificate valid
+// for validity, starting backdate before now. Issued certificates are clamped to
+// the issuer's validity period so they never outlive it.
+func validityPeriod(validity, backdate time.Duration, issuer *x509.Certificate) (time.Time, time.Time) {
+	now := time.Now()
+	notBefore := now.Add(-backdate)
+	notAfter := now.Add(validity)
+	if issuer != nil {
+		if notBefore.Before(issuer.NotBefore) {
+			notBefore = issuer.NotBefore
+		}
+		if notAfter.After(issuer.NotAfter) {
+			notAfter = issuer.NotAfter
+		}
+	}
+	return notBefore, notAfter
+}
+
+// SetNameConstraints restricts the names a CA certificate may issue certificates for
+func (c *BasicCertificateConfig) SetNameConstraints(nc NameConstraints) {
+	c.constraints = nc
+}
+
 func generateSerialNumber() (*big.Int, error) {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	return rand.Int(rand.Reader, serialNumberLimit)
@@ -51,8 +92,22 @@ func generateSerialNumber() (*big.Int, error) {
 
 // GenerateCertificate simplifies certificate generation. Certificates are returned as a byte slice.
 func GenerateCertificate(
-	config BasicCertificateConfig, issuer *x509.Certificate, key *rsa.PrivateKey) ([]byte, error) {
-	var pubKey *rsa.PublicKey
+	config BasicCertificateConfig, issuer *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	// Self-signed
+	if issuer == nil {
+		return IssueCertificate(config, key.Public(), nil, key)
+	}
+	return IssueCertificate(config, issuer.PublicKey, issuer, key)
+}
+
+// IssueCertificate creates a certificate for pub signed by issuerKey. When issuer is nil
+// the certificate is self-signed and pub must belong to issuerKey. Issued certificates
+// never outlive their issuer.
+func IssueCertificate(config BasicCertificateConfig, pub crypto.PublicKey,
+	issuer *x509.Certificate, issuerKey crypto.Signer) ([]byte, error) {
+	if config.validity <= 0 {
+		return nil, fmt.Errorf("certificate validity must be positive, got %s", config.validity)
+	}
 
 	serialNumber, err := generateSerialNumber()
 	if err != nil {
@@ -60,8 +115,7 @@ func GenerateCertificate(
 		return nil, err
 	}
 
-	notBefore := time.Now()
-	notAfter := notBefore.Add(validFor)
+	notBefore, notAfter := validityPeriod(config.validity, 0, issuer)
 
 	template := x509.Certificate{
 		SerialNumber:   serialNumber,
@@ -71,8 +125,7 @@ func GenerateCertificate(
 		NotBefore: notBefore,
 		NotAfter:  notAfter,
 
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
 
 		IsCA: config.isCA,
@@ -87,28 +140,72 @@ func GenerateCertificate(
 	}
 
 	if template.IsCA {
-		template.KeyUsage |= x509.KeyUsageCertSign
+		// no extended key usage, x509.Verify requires every usage of a leaf on
+		// each CA above it and profiles issue leaves for any usage
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		if config.maxPathLen >= 0 {
+			template.MaxPathLen = config.maxPathLen
+			template.MaxPathLenZero = config.maxPathLen == 0
+		}
+		config.constraints.applyTo(&template)
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
 	}
 
-	// Self-signed
 	if issuer == nil {
 		issuer = &template
-		pubKey = key.Public().(*rsa.PublicKey)
 	} else {
-		pubKey = issuer.PublicKey.(*rsa.PublicKey)
+		err := checkNameConstraints(template.DNSNames, template.EmailAddresses, template.IPAddresses, issuer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isRSA(pub) {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
 	}
 
 	log.Printf("Generating certificate - SN: %x", template.SerialNumber)
-	return x509.CreateCertificate(rand.Reader, &template, issuer, pubKey, key)
+	return x509.CreateCertificate(rand.Reader, &template, issuer, pub, issuerKey)
+}
+
+// CanIssueIntermediate reports whether cert is a CA whose path length constraint
+// permits signing another CA certificate.
+func CanIssueIntermediate(cert *x509.Certificate) bool {
+	return cert.IsCA && !(cert.MaxPathLen == 0 && cert.MaxPathLenZero)
+}
+
+// SignOptions adjusts the certificates produced by SignWithOptions
+type SignOptions struct {
+	CRLDistributionPoints []string // URLs where the issuer CRL is published
+	OCSPServer            []string // URLs of the OCSP responder, added as Authority Information Access
+
+	Validity time.Duration // certificate lifetime, DefaultValidity when zero
+	Backdate time.Duration // notBefore is set this far in the past to tolerate clock skew
+
+	Profile *Profile // key usages, lifetime and name restrictions; none are set when nil
 }
 
 // Sign issues and signs a certificate per the csr provided.
-func Sign(csr *x509.CertificateRequest, issuer *x509.Certificate, signingKey *rsa.PrivateKey) ([]byte, error) {
+// The issuer and CSR key types are independent, e.g. an ECDSA issuer can sign
+// an RSA CSR.
+func Sign(csr *x509.CertificateRequest, issuer *x509.Certificate, signingKey crypto.Signer) ([]byte, error) {
+	return SignWithOptions(csr, issuer, signingKey, SignOptions{})
+}
+
+// SignWithOptions is Sign with additional control over the issued certificate. The
+// certificate never outlives issuer.
+func SignWithOptions(csr *x509.CertificateRequest, issuer *x509.Certificate,
+	signingKey crypto.Signer, opts SignOptions) ([]byte, error) {
 	if err := csr.CheckSignature(); err != nil {
 		log.Printf("CSR signature is not valid: %v", err)
 		return nil, err
 	}
 
+	if err := CheckNameConstraints(csr, issuer); err != nil {
+		return nil, err
+	}
+
 	serialNumber, err := generateSerialNumber()
 
 	if err != nil {
@@ -116,8 +213,14 @@ func Sign(csr *x509.CertificateRequest, issuer *x509.Certificate, signingKey *rs
 		return nil, err
 	}
 
-	notBefore := time.Now()
-	notAfter := notBefore.Add(validFor)
+	validity := opts.Validity
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	if opts.Profile != nil && opts.Profile.MaxValidity > 0 && opts.Profile.MaxValidity < validity {
+		validity = opts.Profile.MaxValidity
+	}
+	notBefore, notAfter := validityPeriod(validity, opts.Backdate, issuer)
 
 	template := x509.Certificate{
 		SerialNumber:   serialNumber,
@@ -131,6 +234,15 @@ func Sign(csr *x509.CertificateRequest, issuer *x509.Certificate, signingKey *rs
 
 		IPAddresses: csr.IPAddresses,
 		DNSNames:    csr.DNSNames,
+
+		CRLDistributionPoints: opts.CRLDistributionPoints,
+		OCSPServer:            opts.OCSPServer,
+	}
+
+	if opts.Profile != nil {
+		if err := opts.Profile.apply(csr, &template, issuer); err != nil {
+			return nil, err
+		}
 	}
 
 	log.Printf("Generating certificate - SN: %x", template.SerialNumber)
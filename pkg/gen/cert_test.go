@@ -1,12 +1,16 @@
 package gen
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"testing"
+	"time"
 )
 
 func TestCertificateGeneration(t *testing.T) {
@@ -21,7 +25,6 @@ func TestCertificateGeneration(t *testing.T) {
 		[]string{"security@mesosphere.com"},
 		true)
 
-	fmt.Printf("%x", pKey)
 	rootCert, err := GenerateCertificate(config, nil, pKey)
 	if err != nil {
 		t.Fatalf("certificate generation failed: %v", err)
@@ -131,3 +134,262 @@ oWqnlJdCFaFu6n1nbtO9GKgKOe/qPCNn/rVqgzDn8KFj4tRUxUYdLyXRLkSx9Ck=
 		t.Fatalf("%s != %s", signedCert.Issuer.CommonName, caCert.Subject.CommonName)
 	}
 }
+
+func TestMixedKeyTypeSigning(t *testing.T) {
+	pairs := [][2]string{
+		{KeyTypeECDSAP256, KeyTypeRSA},
+		{KeyTypeRSA, KeyTypeECDSAP384},
+		{KeyTypeEd25519, KeyTypeECDSAP256},
+		{KeyTypeECDSAP384, KeyTypeEd25519},
+	}
+
+	for _, p := range pairs {
+		rootKey, err := GenerateKey(p[0], 2048)
+		if err != nil {
+			t.Fatalf("error generating %s root key: %v", p[0], err)
+		}
+		config := MakeCertificateConfig(
+			"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+			[]string{"localhost"}, []string{"security@mesosphere.com"}, true)
+		rootDer, err := GenerateCertificate(config, nil, rootKey)
+		if err != nil {
+			t.Fatalf("%s root certificate generation failed: %v", p[0], err)
+		}
+		rootCert, _ := x509.ParseCertificate(rootDer)
+
+		clientKey, err := GenerateKey(p[1], 2048)
+		if err != nil {
+			t.Fatalf("error generating %s client key: %v", p[1], err)
+		}
+		csrConfig := MakeCSRConfig(
+			"test", "US", "TX", "San Antonio", "Mesosphere Inc.",
+			[]string{"localhost"}, []string{"security@mesosphere.com"})
+		csrBytes, err := GenerateCSR(csrConfig, clientKey)
+		if err != nil {
+			t.Fatalf("error generating %s csr: %v", p[1], err)
+		}
+		csr, _ := x509.ParseCertificateRequest(csrBytes)
+
+		signed, err := Sign(csr, rootCert, rootKey)
+		if err != nil {
+			t.Fatalf("%s root could not sign %s csr: %v", p[0], p[1], err)
+		}
+		signedCert, _ := x509.ParseCertificate(signed)
+		if err := signedCert.CheckSignatureFrom(rootCert); err != nil {
+			t.Fatalf("%s signature over %s certificate is invalid: %v", p[0], p[1], err)
+		}
+	}
+}
+
+func TestGenerateKeyRejectsShortRSA(t *testing.T) {
+	if _, err := GenerateKey(KeyTypeRSA, 1024); err == nil {
+		t.Fatalf("1024 bit RSA key was accepted")
+	}
+	if _, err := GenerateKey("dsa", 0); err == nil {
+		t.Fatalf("unknown key type was accepted")
+	}
+}
+
+func TestGenerateKeyLike(t *testing.T) {
+	for _, keyType := range KeyTypes {
+		key, _ := GenerateKey(keyType, 3072)
+		rotated, err := GenerateKeyLike(key)
+		if err != nil {
+			t.Fatalf("error rotating %s key: %v", keyType, err)
+		}
+		if keyTypeOf(rotated) != keyTypeOf(key) {
+			t.Fatalf("%s key rotated to %s", keyTypeOf(key), keyTypeOf(rotated))
+		}
+	}
+}
+
+// keyTypeOf describes the type and size of key
+func keyTypeOf(key crypto.Signer) string {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa-%d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + pub.Curve.Params().Name
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+func TestIntermediateIssuance(t *testing.T) {
+	rootKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	rootConfig := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	rootDer, err := GenerateCertificate(rootConfig, nil, rootKey)
+	if err != nil {
+		t.Fatalf("root certificate generation failed: %v", err)
+	}
+	rootCert, _ := x509.ParseCertificate(rootDer)
+
+	intermediateKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	intermediateConfig := MakeCertificateConfig(
+		"INTERMEDIATE", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		[]string{"localhost"}, []string{"security@mesosphere.com"}, true)
+	intermediateConfig.SetMaxPathLen(0)
+	intermediateDer, err := IssueCertificate(
+		intermediateConfig, intermediateKey.Public(), rootCert, rootKey)
+	if err != nil {
+		t.Fatalf("intermediate certificate generation failed: %v", err)
+	}
+	intermediateCert, _ := x509.ParseCertificate(intermediateDer)
+
+	if !intermediateCert.MaxPathLenZero || CanIssueIntermediate(intermediateCert) {
+		t.Fatalf("intermediate path length constraint was not applied")
+	}
+	if intermediateCert.NotAfter.After(rootCert.NotAfter) {
+		t.Fatalf("intermediate outlives the root: %v > %v", intermediateCert.NotAfter, rootCert.NotAfter)
+	}
+
+	clientKey, _ := GenerateKey(KeyTypeRSA, 2048)
+	csrBytes, _ := GenerateCSR(MakeCSRConfig(
+		"test", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		[]string{"localhost"}, nil), clientKey)
+	csr, _ := x509.ParseCertificateRequest(csrBytes)
+	leafDer, err := Sign(csr, intermediateCert, intermediateKey)
+	if err != nil {
+		t.Fatalf("error signing with intermediate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDer)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediateCert)
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		t.Fatalf("leaf does not chain to the root: %v", err)
+	}
+
+	client, _ := DefaultProfiles().Get(ProfileClient)
+	clientDer, err := SignWithOptions(csr, intermediateCert, intermediateKey, SignOptions{Profile: client})
+	if err != nil {
+		t.Fatalf("error signing with the client profile: %v", err)
+	}
+	clientCert, _ := x509.ParseCertificate(clientDer)
+	_, err = clientCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("client certificate does not verify for client authentication: %v", err)
+	}
+}
+
+func TestCRLGeneration(t *testing.T) {
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	caDer, err := GenerateCertificate(config, nil, caKey)
+	if err != nil {
+		t.Fatalf("root certificate generation failed: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	revoked := []x509.RevocationListEntry{
+		{SerialNumber: big.NewInt(42), RevocationTime: time.Now(), ReasonCode: 1},
+	}
+	crlDer, err := GenerateCRL(revoked, caCert, caKey, time.Hour)
+	if err != nil {
+		t.Fatalf("CRL generation failed: %v", err)
+	}
+
+	crl, err := x509.ParseRevocationList(crlDer)
+	if err != nil {
+		t.Fatalf("CRL is invalid: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("CRL signature is invalid: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].ReasonCode != 1 {
+		t.Fatalf("unexpected CRL entries: %+v", crl.RevokedCertificateEntries)
+	}
+}
+
+func TestSignWithCRLDistributionPoint(t *testing.T) {
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	caDer, _ := GenerateCertificate(config, nil, caKey)
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	clientKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	csrBytes, _ := GenerateCSR(MakeCSRConfig(
+		"test", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil), clientKey)
+	csr, _ := x509.ParseCertificateRequest(csrBytes)
+
+	const crlURL = "https://bootstrap:8443/crl/v1/current"
+	signed, err := SignWithOptions(csr, caCert, caKey, SignOptions{CRLDistributionPoints: []string{crlURL}})
+	if err != nil {
+		t.Fatalf("error signing CSR: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(signed)
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != crlURL {
+		t.Fatalf("unexpected CRL distribution points: %v", cert.CRLDistributionPoints)
+	}
+}
+
+func TestSignValidity(t *testing.T) {
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	config.SetValidity(48 * time.Hour)
+	caDer, err := GenerateCertificate(config, nil, caKey)
+	if err != nil {
+		t.Fatalf("root certificate generation failed: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+	if d := caCert.NotAfter.Sub(caCert.NotBefore); d != 48*time.Hour {
+		t.Fatalf("root has incorrect lifetime: %s", d)
+	}
+
+	clientKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	csrBytes, _ := GenerateCSR(MakeCSRConfig(
+		"test", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil), clientKey)
+	csr, _ := x509.ParseCertificateRequest(csrBytes)
+
+	signed, err := SignWithOptions(csr, caCert, caKey, SignOptions{Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("error signing CSR: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(signed)
+	if d := cert.NotAfter.Sub(cert.NotBefore); d != time.Hour {
+		t.Fatalf("leaf has incorrect lifetime: %s", d)
+	}
+
+	signed, err = SignWithOptions(csr, caCert, caKey, SignOptions{Validity: 24 * 365 * time.Hour})
+	if err != nil {
+		t.Fatalf("error signing CSR: %v", err)
+	}
+	cert, _ = x509.ParseCertificate(signed)
+	if cert.NotAfter.After(caCert.NotAfter) {
+		t.Fatalf("leaf outlives the issuer: %v > %v", cert.NotAfter, caCert.NotAfter)
+	}
+
+	// notBefore is backdated but never earlier than the issuer's
+	signed, err = SignWithOptions(csr, caCert, caKey, SignOptions{Validity: time.Hour, Backdate: time.Hour})
+	if err != nil {
+		t.Fatalf("error signing CSR: %v", err)
+	}
+	cert, _ = x509.ParseCertificate(signed)
+	if !cert.NotBefore.Equal(caCert.NotBefore) {
+		t.Fatalf("leaf notBefore was not clamped to the issuer: %v != %v", cert.NotBefore, caCert.NotBefore)
+	}
+
+	config.SetValidity(0)
+	if _, err := GenerateCertificate(config, nil, caKey); err == nil {
+		t.Fatalf("certificate with zero validity was generated")
+	}
+}
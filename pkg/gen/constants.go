@@ -4,4 +4,17 @@ package gen
 const (
 	RootKeyFile = "root-key.pem"
 	RootCAFile  = "root-cert.pem"
+
+	IntermediateKeyFile   = "intermediate-key.pem"
+	IntermediateCAFile    = "intermediate-cert.pem"
+	IntermediateChainFile = "intermediate-chain.pem"
+
+	PendingCAKeyFile = "pending-ca-key.pem"
+	PendingCACSRFile = "pending-ca-csr.pem"
+
+	OCSPKeyFile  = "ocsp-key.pem"
+	OCSPCertFile = "ocsp-cert.pem"
+
+	LedgerFile = "issued.jsonl"
+	TokensFile = "tokens.json"
 )
@@ -0,0 +1,203 @@
+// X.509 name constraints for CA certificates
+
+package gen
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrNameConstraint is returned when a CSR requests names outside the issuer's name constraints
+var ErrNameConstraint = errors.New("name outside of issuer name constraints")
+
+// NameConstraints restrict the names a CA may issue certificates for. A DNS
+// domain matches itself and its subdomains, a domain with a leading dot only
+// matches subdomains. Email constraints are a full address, a host or a domain
+// with a leading dot.
+type NameConstraints struct {
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	PermittedEmailAddresses []string
+}
+
+// ParseNameConstraints builds NameConstraints from command line values. IP
+// ranges are given in CIDR notation.
+func ParseNameConstraints(permittedDNS, excludedDNS, permittedIP, permittedEmail []string) (NameConstraints, error) {
+	nc := NameConstraints{
+		PermittedDNSDomains:     permittedDNS,
+		ExcludedDNSDomains:      excludedDNS,
+		PermittedEmailAddresses: permittedEmail,
+	}
+	for _, cidr := range permittedIP {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nc, fmt.Errorf("invalid permitted IP range %q: %v", cidr, err)
+		}
+		nc.PermittedIPRanges = append(nc.PermittedIPRanges, ipNet)
+	}
+	return nc, nil
+}
+
+// IsEmpty reports whether no constraint is set
+func (nc NameConstraints) IsEmpty() bool {
+	return len(nc.PermittedDNSDomains) == 0 && len(nc.ExcludedDNSDomains) == 0 &&
+		len(nc.PermittedIPRanges) == 0 && len(nc.PermittedEmailAddresses) == 0
+}
+
+// applyTo adds the constraints to a CA certificate template, marked critical
+func (nc NameConstraints) applyTo(template *x509.Certificate) {
+	if nc.IsEmpty() {
+		return
+	}
+	template.PermittedDNSDomainsCritical = true
+	template.PermittedDNSDomains = nc.PermittedDNSDomains
+	template.ExcludedDNSDomains = nc.ExcludedDNSDomains
+	template.PermittedIPRanges = nc.PermittedIPRanges
+	template.PermittedEmailAddresses = nc.PermittedEmailAddresses
+}
+
+var oidNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+
+// generalSubtree is a GeneralSubtree of the name constraints extension,
+// RFC 5280 section 4.2.1.10, with the default minimum and no maximum
+type generalSubtree struct {
+	Base asn1.RawValue
+}
+
+type nameConstraintsExtension struct {
+	Permitted []generalSubtree `asn1:"optional,tag:0"`
+	Excluded  []generalSubtree `asn1:"optional,tag:1"`
+}
+
+// extension encodes the constraints as a critical name constraints extension,
+// which crypto/x509 only does for certificates and not for CSRs
+func (nc NameConstraints) extension() (pkix.Extension, error) {
+	subtree := func(tag int, name []byte) generalSubtree {
+		return generalSubtree{Base: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: name}}
+	}
+	// GeneralName tags of rfc822Name, dNSName and iPAddress
+	const emailTag, dnsTag, ipTag = 1, 2, 7
+
+	var ext nameConstraintsExtension
+	for _, d := range nc.PermittedDNSDomains {
+		ext.Permitted = append(ext.Permitted, subtree(dnsTag, []byte(d)))
+	}
+	for _, r := range nc.PermittedIPRanges {
+		ip := r.IP
+		if len(r.Mask) == net.IPv4len {
+			ip = ip.To4()
+		}
+		ext.Permitted = append(ext.Permitted, subtree(ipTag, append(append([]byte{}, ip...), r.Mask...)))
+	}
+	for _, e := range nc.PermittedEmailAddresses {
+		ext.Permitted = append(ext.Permitted, subtree(emailTag, []byte(e)))
+	}
+	for _, d := range nc.ExcludedDNSDomains {
+		ext.Excluded = append(ext.Excluded, subtree(dnsTag, []byte(d)))
+	}
+
+	value, err := asn1.Marshal(ext)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidNameConstraints, Critical: true, Value: value}, nil
+}
+
+func matchDNSConstraint(domain, constraint string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+func matchEmailConstraint(email, constraint string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	host := strings.ToLower(email[at+1:])
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+// checkNames verifies names against permitted and excluded constraints
+func checkNames(kind string, names, permitted, excluded []string, match func(string, string) bool) error {
+	for _, name := range names {
+		for _, c := range excluded {
+			if match(name, c) {
+				return fmt.Errorf("%w: %s %s is excluded by %s", ErrNameConstraint, kind, name, c)
+			}
+		}
+		if len(permitted) == 0 {
+			continue
+		}
+		ok := false
+		for _, c := range permitted {
+			if match(name, c) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s %s is not permitted", ErrNameConstraint, kind, name)
+		}
+	}
+	return nil
+}
+
+// CheckNameConstraints verifies that every subject alternative name requested
+// by csr is allowed by the name constraints of each CA. It returns an error
+// wrapping ErrNameConstraint for the first name that is not.
+func CheckNameConstraints(csr *x509.CertificateRequest, cas ...*x509.Certificate) error {
+	return checkNameConstraints(csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, cas...)
+}
+
+func checkNameConstraints(dnsNames, emails []string, ips []net.IP, cas ...*x509.Certificate) error {
+	for _, ca := range cas {
+		err := checkNames("DNS name", dnsNames,
+			ca.PermittedDNSDomains, ca.ExcludedDNSDomains, matchDNSConstraint)
+		if err != nil {
+			return err
+		}
+		err = checkNames("email address", emails,
+			ca.PermittedEmailAddresses, ca.ExcludedEmailAddresses, matchEmailConstraint)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			for _, c := range ca.ExcludedIPRanges {
+				if c.Contains(ip) {
+					return fmt.Errorf("%w: IP address %s is excluded by %s", ErrNameConstraint, ip, c)
+				}
+			}
+			if len(ca.PermittedIPRanges) == 0 {
+				continue
+			}
+			ok := false
+			for _, c := range ca.PermittedIPRanges {
+				if c.Contains(ip) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return fmt.Errorf("%w: IP address %s is not permitted", ErrNameConstraint, ip)
+			}
+		}
+	}
+	return nil
+}
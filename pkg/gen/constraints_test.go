@@ -0,0 +1,78 @@
+package gen
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestNameConstraints(t *testing.T) {
+	nc, err := ParseNameConstraints(
+		[]string{"mesos", ".example.com"}, []string{"secret.mesos"}, []string{"10.0.0.0/8"}, []string{"mesosphere.com"})
+	if err != nil {
+		t.Fatalf("error parsing name constraints: %v", err)
+	}
+	if _, err := ParseNameConstraints(nil, nil, []string{"10.0.0.1"}, nil); err == nil {
+		t.Fatalf("IP range without prefix length was accepted")
+	}
+
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	config.SetNameConstraints(nc)
+	caDer, err := GenerateCertificate(config, nil, caKey)
+	if err != nil {
+		t.Fatalf("root certificate generation failed: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+	if !caCert.PermittedDNSDomainsCritical {
+		t.Fatalf("name constraints are not critical")
+	}
+	if len(caCert.PermittedDNSDomains) != 2 || len(caCert.ExcludedDNSDomains) != 1 ||
+		len(caCert.PermittedIPRanges) != 1 || len(caCert.PermittedEmailAddresses) != 1 {
+		t.Fatalf("unexpected name constraints in CA certificate")
+	}
+
+	allowed := profileTestCSR(t, MakeCSRConfig(
+		"agent", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		[]string{"mesos", "agent.mesos", "www.example.com", "10.1.2.3"}, []string{"ops@mesosphere.com"}))
+	signed, err := Sign(allowed, caCert, caKey)
+	if err != nil {
+		t.Fatalf("error signing permitted names: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(signed)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Fatalf("certificate does not verify against constrained root: %v", err)
+	}
+
+	for _, hosts := range [][]string{
+		{"mesos.evil.com"},
+		{"secret.mesos"},
+		{"example.com"},
+		{"192.168.1.1"},
+	} {
+		denied := profileTestCSR(t, MakeCSRConfig(
+			"agent", "US", "TX", "San Antonio", "Mesosphere Inc.", hosts, nil))
+		if _, err := Sign(denied, caCert, caKey); !errors.Is(err, ErrNameConstraint) {
+			t.Fatalf("%v: expected a name constraint error, got %v", hosts, err)
+		}
+	}
+
+	denied := profileTestCSR(t, MakeCSRConfig(
+		"agent", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, []string{"ops@evil.com"}))
+	if _, err := Sign(denied, caCert, caKey); !errors.Is(err, ErrNameConstraint) {
+		t.Fatalf("expected a name constraint error for email, got %v", err)
+	}
+
+	intermediateKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	intermediate := MakeCertificateConfig(
+		"INTERMEDIATE", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		[]string{"evil.com"}, nil, true)
+	_, err = IssueCertificate(intermediate, intermediateKey.Public(), caCert, caKey)
+	if !errors.Is(err, ErrNameConstraint) {
+		t.Fatalf("expected a name constraint error for the intermediate, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+// Certificate revocation lists
+
+package gen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"time"
+)
+
+// GenerateCRL creates a DER encoded CRL listing revoked, signed by issuerKey. The
+// CRL number is derived from the current time so successive CRLs always increase.
+func GenerateCRL(revoked []x509.RevocationListEntry, issuer *x509.Certificate,
+	issuerKey crypto.Signer, nextUpdate time.Duration) ([]byte, error) {
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(nextUpdate),
+		RevokedCertificateEntries: revoked,
+	}
+	log.Printf("Generating CRL - %d revoked certificates", len(revoked))
+	return x509.CreateRevocationList(rand.Reader, template, issuer, issuerKey)
+}
+
+// EncodeCRLPEM returns a DER encoded CRL in PEM format
+func EncodeCRLPEM(crl []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+}
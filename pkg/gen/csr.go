@@ -1,41 +1,95 @@
 package gen
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"sort"
+	"strings"
 )
 
+// ErrIdentityMismatch is returned when a renewal CSR asks for a different subject or names
+var ErrIdentityMismatch = errors.New("CSR does not match the certificate being renewed")
+
 // CSRConfig DI for CSR generation
 type CSRConfig struct {
 	name           pkix.Name
 	emailAddresses []string
 	hosts          []string
+	isCA           bool // request CA=true through the basic constraints extension
+	maxPathLen     int
+	constraints    NameConstraints
 }
 
 // MakeCSRConfig helps to generate the pkix.Name structure needed for CSR generation
 func MakeCSRConfig(name, country, state, locality, organization string, hosts, emailAddresses []string) CSRConfig {
 	dn := pkix.Name{
 		CommonName:   name,
-		Country:      []string{country},
-		Province:     []string{state},
-		Locality:     []string{locality},
-		Organization: []string{organization},
+		Country:      nonEmpty(country),
+		Province:     nonEmpty(state),
+		Locality:     nonEmpty(locality),
+		Organization: nonEmpty(organization),
 	}
 	return CSRConfig{
 		name:           dn,
 		emailAddresses: emailAddresses,
 		hosts:          hosts,
+		maxPathLen:     -1,
 	}
 }
 
+// nonEmpty returns s as a name attribute, which is left out when empty
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// RequestCA asks the signer for a CA certificate with the given path length
+// constraint, negative when unconstrained. Signers only honour the request when
+// the certificate profile allows it.
+func (c *CSRConfig) RequestCA(maxPathLen int) {
+	c.isCA = true
+	c.maxPathLen = maxPathLen
+}
+
+// SetNameConstraints requests name constraints for a CA certificate, see RequestCA
+func (c *CSRConfig) SetNameConstraints(nc NameConstraints) {
+	c.constraints = nc
+}
+
+var oidKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 15}
+
+// keyUsageExtension encodes usage as a critical key usage extension, RFC 5280
+// section 4.2.1.3. Bit 0 of the BIT STRING is the most significant bit.
+func keyUsageExtension(usage x509.KeyUsage) (pkix.Extension, error) {
+	var bits asn1.BitString
+	for i := 0; usage>>i != 0; i++ {
+		if len(bits.Bytes) <= i/8 {
+			bits.Bytes = append(bits.Bytes, 0)
+		}
+		if usage&(1<<i) != 0 {
+			bits.Bytes[i/8] |= 0x80 >> (i % 8)
+			bits.BitLength = i + 1
+		}
+	}
+	value, err := asn1.Marshal(bits)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidKeyUsage, Critical: true, Value: value}, nil
+}
+
 // GenerateCSR simplifies CSR generation. CSRs are returned as byte slices
-func GenerateCSR(config CSRConfig, key *rsa.PrivateKey) ([]byte, error) {
+func GenerateCSR(config CSRConfig, key crypto.Signer) ([]byte, error) {
 	template := x509.CertificateRequest{
 		Subject:        config.name,
 		EmailAddresses: config.emailAddresses,
@@ -48,10 +102,80 @@ func GenerateCSR(config CSRConfig, key *rsa.PrivateKey) ([]byte, error) {
 			template.DNSNames = append(template.DNSNames, h)
 		}
 	}
+
+	if config.isCA {
+		bc := basicConstraints{IsCA: true, MaxPathLen: config.maxPathLen}
+		value, err := asn1.Marshal(bc)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions,
+			pkix.Extension{Id: oidBasicConstraints, Critical: true, Value: value})
+
+		ku, err := keyUsageExtension(x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ku)
+	}
+	if !config.constraints.IsEmpty() {
+		nc, err := config.constraints.extension()
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, nc)
+	}
 	log.Printf("Generating CSR - CN: %s", config.name.CommonName)
 	return x509.CreateCertificateRequest(rand.Reader, &template, key)
 }
 
+// GenerateRenewalCSR creates a CSR for the subject and subject alternative names of cert
+func GenerateRenewalCSR(cert *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	template := x509.CertificateRequest{
+		RawSubject:     cert.RawSubject,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	log.Printf("Generating renewal CSR - CN: %s", cert.Subject.CommonName)
+	return x509.CreateCertificateRequest(rand.Reader, &template, key)
+}
+
+func sortedNames(names []string) string {
+	sorted := make([]string, len(names))
+	for i, n := range names {
+		sorted[i] = strings.ToLower(n)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// CheckSameIdentity returns an error wrapping ErrIdentityMismatch unless csr asks
+// for exactly the subject and subject alternative names of cert
+func CheckSameIdentity(csr *x509.CertificateRequest, cert *x509.Certificate) error {
+	if csr.Subject.String() != cert.Subject.String() {
+		return fmt.Errorf("%w: subject %q differs from %q", ErrIdentityMismatch, csr.Subject, cert.Subject)
+	}
+	var csrIPs, certIPs []string
+	for _, ip := range csr.IPAddresses {
+		csrIPs = append(csrIPs, ip.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	for _, names := range [][2][]string{
+		{csr.DNSNames, cert.DNSNames},
+		{csrIPs, certIPs},
+		{csr.EmailAddresses, cert.EmailAddresses},
+	} {
+		if sortedNames(names[0]) != sortedNames(names[1]) {
+			return fmt.Errorf("%w: subject alternative names %v differ from %v",
+				ErrIdentityMismatch, names[0], names[1])
+		}
+	}
+	return nil
+}
+
 // DecodeAndParsePEM combines PEM decode and CSR parsing
 func DecodeAndParsePEM(data []byte) (*x509.CertificateRequest, error) {
 	block, _ := pem.Decode(data)
@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"errors"
+	"math/big"
 	"testing"
+	"time"
 )
 
 func TestCSRGeneration(t *testing.T) {
@@ -37,3 +40,79 @@ func TestCSRGeneration(t *testing.T) {
 		t.Errorf("Email address is incorrect: %v", csr.EmailAddresses)
 	}
 }
+
+func TestRenewalCSR(t *testing.T) {
+	caCert, caKey := profileTestCA(t)
+	key, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	csrBytes, _ := GenerateCSR(MakeCSRConfig(
+		"agent", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		[]string{"agent.mesos", "10.0.0.1"}, []string{"ops@mesosphere.com"}), key)
+	csr, _ := x509.ParseCertificateRequest(csrBytes)
+	signed, err := Sign(csr, caCert, caKey)
+	if err != nil {
+		t.Fatalf("error signing csr: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(signed)
+
+	renewalBytes, err := GenerateRenewalCSR(cert, key)
+	if err != nil {
+		t.Fatalf("error generating renewal csr: %v", err)
+	}
+	renewal, _ := x509.ParseCertificateRequest(renewalBytes)
+	if err := CheckSameIdentity(renewal, cert); err != nil {
+		t.Fatalf("renewal csr does not match certificate: %v", err)
+	}
+
+	for _, config := range []CSRConfig{
+		MakeCSRConfig("other", "US", "TX", "San Antonio", "Mesosphere Inc.",
+			[]string{"agent.mesos", "10.0.0.1"}, []string{"ops@mesosphere.com"}),
+		MakeCSRConfig("agent", "US", "TX", "San Antonio", "Mesosphere Inc.",
+			[]string{"agent.mesos", "master.mesos", "10.0.0.1"}, []string{"ops@mesosphere.com"}),
+		MakeCSRConfig("agent", "US", "TX", "San Antonio", "Mesosphere Inc.",
+			[]string{"agent.mesos", "10.0.0.2"}, []string{"ops@mesosphere.com"}),
+	} {
+		otherBytes, _ := GenerateCSR(config, key)
+		other, _ := x509.ParseCertificateRequest(otherBytes)
+		if err := CheckSameIdentity(other, cert); !errors.Is(err, ErrIdentityMismatch) {
+			t.Fatalf("expected an identity mismatch, got %v", err)
+		}
+	}
+}
+
+func TestCACSR(t *testing.T) {
+	caCert, caKey := profileTestCA(t)
+	config := MakeCSRConfig("INTERMEDIATE", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil)
+	config.RequestCA(0)
+	nc, _ := ParseNameConstraints([]string{"example.com"}, []string{"bad.example.com"},
+		[]string{"10.0.0.0/8"}, []string{".example.com"})
+	config.SetNameConstraints(nc)
+	csr := profileTestCSR(t, config)
+
+	// an external CA copying the requested extensions issues the CA asked for
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         csr.Subject,
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: csr.Extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error issuing CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	if !cert.IsCA || cert.MaxPathLen != 0 || !cert.MaxPathLenZero {
+		t.Fatalf("requested basic constraints not honoured: CA %t path length %d", cert.IsCA, cert.MaxPathLen)
+	}
+	if cert.KeyUsage&x509.KeyUsageCertSign == 0 || cert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		t.Fatalf("requested key usage not honoured: %v", cert.KeyUsage)
+	}
+	if !cert.PermittedDNSDomainsCritical ||
+		len(cert.PermittedDNSDomains) != 1 || cert.PermittedDNSDomains[0] != "example.com" ||
+		len(cert.ExcludedDNSDomains) != 1 || cert.ExcludedDNSDomains[0] != "bad.example.com" ||
+		len(cert.PermittedIPRanges) != 1 || cert.PermittedIPRanges[0].String() != "10.0.0.0/8" ||
+		len(cert.PermittedEmailAddresses) != 1 || cert.PermittedEmailAddresses[0] != ".example.com" {
+		t.Fatalf("requested name constraints not honoured")
+	}
+}
@@ -0,0 +1,33 @@
+// Certificate fingerprints used to pin the CA on first contact
+
+package gen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// fingerprintPrefix names the digest of fingerprints
+const fingerprintPrefix = "sha256:"
+
+// Fingerprint returns the SHA-256 digest of a DER encoded certificate as
+// sha256:<lowercase hex>
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return fingerprintPrefix + hex.EncodeToString(sum[:])
+}
+
+// ParseFingerprint normalizes a SHA-256 fingerprint to the format returned by
+// Fingerprint. The sha256: prefix is optional and colons between bytes, as
+// printed by openssl, are accepted.
+func ParseFingerprint(s string) (string, error) {
+	h := strings.ToLower(strings.TrimSpace(s))
+	h = strings.TrimPrefix(h, fingerprintPrefix)
+	h = strings.ReplaceAll(h, ":", "")
+	if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint %q", s)
+	}
+	return fingerprintPrefix + h, nil
+}
@@ -0,0 +1,31 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	caCert, _ := profileTestCA(t)
+	fingerprint := Fingerprint(caCert.Raw)
+	if !strings.HasPrefix(fingerprint, "sha256:") || len(fingerprint) != len("sha256:")+64 {
+		t.Fatalf("unexpected fingerprint format %s", fingerprint)
+	}
+
+	hex := strings.TrimPrefix(fingerprint, "sha256:")
+	var openssl []string
+	for i := 0; i < len(hex); i += 2 {
+		openssl = append(openssl, strings.ToUpper(hex[i:i+2]))
+	}
+	for _, s := range []string{fingerprint, hex, "SHA256:" + strings.Join(openssl, ":")} {
+		parsed, err := ParseFingerprint(s)
+		if err != nil || parsed != fingerprint {
+			t.Fatalf("%s parsed as %s, %v", s, parsed, err)
+		}
+	}
+	for _, s := range []string{"", "sha256:abcd", "sha1:" + hex, hex + "zz"} {
+		if _, err := ParseFingerprint(s); err == nil {
+			t.Fatalf("invalid fingerprint %q was accepted", s)
+		}
+	}
+}
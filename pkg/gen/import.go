@@ -0,0 +1,109 @@
+// Import of a CA issued by an external PKI
+
+package gen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// VerifyImportedCA checks that certDER is a CA certificate allowed to sign
+// certificates, that key is its private key and that it chains to a root of
+// chain, which holds the issuers of the CA in any order. The verified chain is
+// returned starting at the CA and ending at the root. A self-signed CA is its
+// own root and needs no chain.
+func VerifyImportedCA(certDER []byte, key crypto.Signer, chain [][]byte) ([][]byte, error) {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate : %v", err)
+	}
+	if !keyMatches(cert, key) {
+		return nil, errors.New("private key does not match the CA certificate")
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", cert.Subject)
+	}
+	if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s does not have the certificate signing key usage", cert.Subject)
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	if isSelfSigned(cert) {
+		roots.AddCert(cert)
+	}
+	for _, der := range chain {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing chain certificate : %v", err)
+		}
+		if isSelfSigned(c) {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying CA chain, it must include every issuer up to the root : %v", err)
+	}
+
+	verified := make([][]byte, 0, len(chains[0]))
+	for _, c := range chains[0] {
+		verified = append(verified, c.Raw)
+	}
+	return verified, nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// InstallCA writes a chain verified by VerifyImportedCA to the store, replacing
+// the CA in it. A root is installed as the root CA. Any other CA is installed as
+// the intermediate, with the issuers between it and the root in
+// IntermediateChainFile, and serve signs with it. The key is written unless it
+// is nil because a signer backend holds it. Key files of the replaced CA are
+// removed.
+func InstallCA(chain [][]byte, key crypto.Signer) error {
+	certFile, keyFile := IntermediateCAFile, IntermediateKeyFile
+	stale := []string{RootKeyFile, IntermediateChainFile}
+	if len(chain) == 1 {
+		certFile, keyFile = RootCAFile, RootKeyFile
+		stale = []string{IntermediateCAFile, IntermediateKeyFile, IntermediateChainFile}
+	}
+	if key == nil {
+		stale = append(stale, keyFile)
+	}
+	for _, f := range stale {
+		if err := AppFs.Remove(StorePath(f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if key != nil {
+		if err := WritePrivateKey(StorePath(keyFile), key); err != nil {
+			return err
+		}
+	}
+	if err := WriteCertificate(StorePath(certFile), chain[0]); err != nil {
+		return err
+	}
+	if len(chain) == 1 {
+		return nil
+	}
+	if len(chain) > 2 {
+		if err := WriteCertificateChain(StorePath(IntermediateChainFile), chain[1:len(chain)-1]); err != nil {
+			return err
+		}
+	}
+	return WriteCertificate(StorePath(RootCAFile), chain[len(chain)-1])
+}
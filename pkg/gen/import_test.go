@@ -0,0 +1,102 @@
+package gen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// importTestCA issues a CA named name with issuer, self-signed when issuer is nil
+func importTestCA(t *testing.T, name string, issuer *x509.Certificate, issuerKey crypto.Signer,
+	isCA bool) ([]byte, *x509.Certificate, crypto.Signer) {
+	key, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(name, "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil, isCA)
+	if isCA {
+		config.SetMaxPathLen(-1)
+	}
+	if issuer == nil {
+		issuerKey = key
+	}
+	der, err := IssueCertificate(config, key.Public(), issuer, issuerKey)
+	if err != nil {
+		t.Fatalf("error issuing %s: %v", name, err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return der, cert, key
+}
+
+func TestImportCA(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+
+	rootDer, root, rootKey := importTestCA(t, "CORPORATE ROOT", nil, nil, true)
+	issuingDer, issuing, issuingKey := importTestCA(t, "CORPORATE ISSUING", root, rootKey, true)
+	caDer, _, caKey := importTestCA(t, "DCOS", issuing, issuingKey, true)
+	leafDer, _, leafKey := importTestCA(t, "LEAF", issuing, issuingKey, false)
+
+	if _, err := VerifyImportedCA(caDer, caKey, [][]byte{issuingDer}); err == nil {
+		t.Fatalf("CA without its root verified")
+	}
+	if _, err := VerifyImportedCA(caDer, issuingKey, [][]byte{issuingDer, rootDer}); err == nil {
+		t.Fatalf("CA with a different key verified")
+	}
+	if _, err := VerifyImportedCA(leafDer, leafKey, [][]byte{issuingDer, rootDer}); err == nil {
+		t.Fatalf("certificate which is not a CA verified")
+	}
+
+	// issuers in any order
+	chain, err := VerifyImportedCA(caDer, caKey, [][]byte{rootDer, issuingDer})
+	if err != nil {
+		t.Fatalf("error verifying CA: %v", err)
+	}
+	expected := [][]byte{caDer, issuingDer, rootDer}
+	if len(chain) != len(expected) {
+		t.Fatalf("expected a chain of %d certificates, got %d", len(expected), len(chain))
+	}
+
+	// a root key of a previous init-ca is removed
+	_ = WritePrivateKey(StorePath(RootKeyFile), rootKey)
+	if err := InstallCA(chain, caKey); err != nil {
+		t.Fatalf("error installing CA: %v", err)
+	}
+	if exists, _ := afero.Exists(AppFs, StorePath(RootKeyFile)); exists {
+		t.Fatalf("root key of the replaced CA was kept")
+	}
+	ca, err := LoadSigningCA(nil)
+	if err != nil {
+		t.Fatalf("error loading imported CA: %v", err)
+	}
+	for i := range expected {
+		if !bytes.Equal(ca.Chain[i], expected[i]) {
+			t.Fatalf("certificate %d of the loaded chain differs", i)
+		}
+	}
+	if !bytes.Equal(ca.Root.Raw, rootDer) || !ca.IsIntermediate() {
+		t.Fatalf("imported CA was not installed as an intermediate of the corporate root")
+	}
+
+	// a self-signed CA replaces the root and the intermediate
+	chain, err = VerifyImportedCA(rootDer, rootKey, nil)
+	if err != nil || len(chain) != 1 {
+		t.Fatalf("error verifying root CA: %v", err)
+	}
+	if err := InstallCA(chain, rootKey); err != nil {
+		t.Fatalf("error installing root CA: %v", err)
+	}
+	for _, f := range []string{IntermediateCAFile, IntermediateKeyFile, IntermediateChainFile} {
+		if exists, _ := afero.Exists(AppFs, StorePath(f)); exists {
+			t.Fatalf("%s of the replaced CA was kept", f)
+		}
+	}
+	if ca, err = LoadSigningCA(nil); err != nil || ca.IsIntermediate() || !bytes.Equal(ca.Certificate.Raw, rootDer) {
+		t.Fatalf("root CA was not installed: %v", err)
+	}
+	// the key must belong to the signing certificate
+	_ = WritePrivateKey("/test/other-key.pem", caKey)
+	if _, err := LoadSigningCA(FileSigner{Path: "/test/other-key.pem"}); err == nil {
+		t.Fatalf("CA was loaded with the key of another certificate")
+	}
+}
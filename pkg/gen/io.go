@@ -3,6 +3,9 @@
 package gen
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -11,6 +14,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/spf13/afero"
 )
@@ -43,10 +47,84 @@ func writePem(filePath string, der []byte, blockType string, private bool) error
 	return pem.Encode(out, &pem.Block{Type: blockType, Bytes: der})
 }
 
-// WritePrivateKey output key to filePath in PEM format
-func WritePrivateKey(filePath string, key *rsa.PrivateKey) error {
-	return writePem(
-		filePath, x509.MarshalPKCS1PrivateKey(key), "RSA PRIVATE KEY", true)
+// Formats private keys are written in
+const (
+	// KeyFormatPKCS1 writes RSA keys as PKCS #1, ECDSA keys as SEC 1 and
+	// Ed25519 keys, which have no other format, as PKCS #8
+	KeyFormatPKCS1 = "pkcs1"
+	// KeyFormatPKCS8 writes every key as PKCS #8
+	KeyFormatPKCS8 = "pkcs8"
+)
+
+// KeyFormats lists every format understood by SetKeyFormat
+var KeyFormats = []string{KeyFormatPKCS1, KeyFormatPKCS8}
+
+// keyFormat is the format WritePrivateKey and EncodePrivateKeyPEM use
+var keyFormat = KeyFormatPKCS1
+
+// SetKeyFormat sets the format unencrypted private keys are written in, one of
+// KeyFormats. Encrypted keys are always PKCS #8.
+func SetKeyFormat(format string) error {
+	switch format {
+	case KeyFormatPKCS1, KeyFormatPKCS8:
+		keyFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unsupported key format %s, must be one of: %s", format, strings.Join(KeyFormats, ", "))
+	}
+}
+
+// marshalPrivateKey returns the DER encoding and PEM block type of key in the
+// format set with SetKeyFormat
+func marshalPrivateKey(key crypto.Signer) ([]byte, string, error) {
+	if keyFormat == KeyFormatPKCS8 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		return der, "PRIVATE KEY", err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(k), "RSA PRIVATE KEY", nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		return der, "EC PRIVATE KEY", err
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		return der, "PRIVATE KEY", err
+	default:
+		return nil, "", fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// WritePrivateKey output key to filePath in PEM format, see SetKeyFormat. When
+// a passphrase has been set with SetKeyPassphrase every key is written as
+// encrypted PKCS #8.
+func WritePrivateKey(filePath string, key crypto.Signer) error {
+	if len(keyPassphrase) > 0 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return err
+		}
+		if der, err = EncryptPKCS8(der, keyPassphrase); err != nil {
+			return err
+		}
+		return writePem(filePath, der, "ENCRYPTED PRIVATE KEY", true)
+	}
+
+	der, blockType, err := marshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePem(filePath, der, blockType, true)
+}
+
+// EncodePrivateKeyPEM returns key unencrypted in the format set with SetKeyFormat
+func EncodePrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	der, blockType, err := marshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
 }
 
 // WriteCertificate outputs a certificate to filePath in PEM format
@@ -54,49 +132,191 @@ func WriteCertificate(filePath string, certificate []byte) error {
 	return writePem(filePath, certificate, "CERTIFICATE", false)
 }
 
-func readPEM(filePath string) (*pem.Block, error) {
+// WriteCertificateRequest outputs a CSR to filePath in PEM format
+func WriteCertificateRequest(filePath string, csr []byte) error {
+	return writePem(filePath, csr, "CERTIFICATE REQUEST", false)
+}
+
+// EncodeCertificatesPEM concatenates DER encoded certificates into a PEM bundle
+func EncodeCertificatesPEM(certificates ...[]byte) []byte {
+	var b []byte
+	for _, c := range certificates {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	return b
+}
+
+// WriteCertificateChain outputs a PEM bundle to filePath, leaf certificate first
+func WriteCertificateChain(filePath string, chain [][]byte) error {
+	out, err := AppFs.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			log.Fatalf("error closing file %s: %v", filePath, err)
+		}
+	}()
+	_, err = out.Write(EncodeCertificatesPEM(chain...))
+	return err
+}
+
+// ReadCertificateChainPEM reads every certificate in a PEM bundle, in file order
+func ReadCertificateChainPEM(filePath string) ([][]byte, error) {
+	data, err := afero.ReadFile(AppFs, filePath)
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", filePath)
+	}
+	return chain, nil
+}
+
+// readPEM returns the first block of the PEM file at filePath accepted by
+// isType, skipping other blocks such as the EC PARAMETERS OpenSSL writes before
+// EC keys or the certificates of a bundle. contents describes the accepted
+// blocks in errors.
+func readPEM(filePath string, isType func(string) bool, contents string) (*pem.Block, error) {
 	data, err := afero.ReadFile(AppFs, filePath)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(data)
-	if block == nil {
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if isType(block.Type) {
+			return block, nil
+		}
+		found = true
+	}
+	if !found {
 		return nil, fmt.Errorf("file not in PEM format: %s", filePath)
 	}
-	return block, nil
+	return nil, fmt.Errorf("PEM file does not contain %s: %s", contents, filePath)
+}
+
+func isCertificateBlock(blockType string) bool {
+	return blockType == "CERTIFICATE"
 }
 
-// ReadCertificatePEM reads a PEM formatted file in
+// isPrivateKeyBlock accepts PKCS #1 (RSA PRIVATE KEY), SEC 1 (EC PRIVATE KEY)
+// and PKCS #8 (PRIVATE KEY, ENCRYPTED PRIVATE KEY) keys
+func isPrivateKeyBlock(blockType string) bool {
+	return strings.HasSuffix(blockType, "PRIVATE KEY")
+}
+
+// ReadCertificatePEM reads the first certificate of a PEM formatted file
 func ReadCertificatePEM(filePath string) ([]byte, error) {
-	block, err := readPEM(filePath)
+	block, err := readPEM(filePath, isCertificateBlock, "a certificate")
 	if err != nil {
 		return nil, err
 	}
-	if block.Type != "CERTIFICATE" {
-		return nil, errors.New("PEM file does not contain a certificate")
-	}
 	return block.Bytes, nil
 }
 
-// ReadPrivateKeyBytes parses an RSA private key in PEM format and returns the key as bytes
-func ReadPrivateKeyBytes(filePath string) ([]byte, error) {
-	block, err := readPEM(filePath)
+// readPrivateKeyPEM reads the first private key in the PEM file at filePath,
+// decrypting encrypted keys
+func readPrivateKeyPEM(filePath string) (*pem.Block, error) {
+	block, err := readPEM(filePath, isPrivateKeyBlock, "a private key")
 	if err != nil {
 		return nil, err
 	}
-	if block.Type != "RSA PRIVATE KEY" {
-		return nil, errors.New("PEM is not an RSA private key")
+	if _, ok := block.Headers["DEK-Info"]; ok {
+		return nil, fmt.Errorf("%s uses legacy PEM encryption, which is not supported. Convert it with "+
+			"openssl pkcs8 -topk8 -v2 aes-256-cbc", filePath)
+	}
+	if block.Type != "ENCRYPTED PRIVATE KEY" {
+		return block, nil
+	}
+
+	if len(keyPassphrase) == 0 {
+		return nil, fmt.Errorf("%s: %w", filePath, ErrKeyLocked)
+	}
+	der, err := DecryptPKCS8(block.Bytes, keyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s : %v", filePath, err)
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+// ReadPrivateKeyBytes parses a private key in PEM format and returns the key as bytes
+func ReadPrivateKeyBytes(filePath string) ([]byte, error) {
+	block, err := readPrivateKeyPEM(filePath)
+	if err != nil {
+		return nil, err
 	}
 	return block.Bytes, nil
 }
 
-// ReadPrivateKey parses an RSA private key in PEM format and returns the result as an rsa.PrivateKey.
-func ReadPrivateKey(filePath string) (*rsa.PrivateKey, error) {
-	b, err := ReadPrivateKeyBytes(filePath)
+// IsEncryptedPrivateKey reports whether the private key at filePath is encrypted
+func IsEncryptedPrivateKey(filePath string) (bool, error) {
+	block, err := readPEM(filePath, isPrivateKeyBlock, "a private key")
+	if err != nil {
+		return false, err
+	}
+	return block.Type == "ENCRYPTED PRIVATE KEY", nil
+}
+
+// ReadPrivateKey parses a private key in PEM format and returns the result as a crypto.Signer.
+func ReadPrivateKey(filePath string) (crypto.Signer, error) {
+	block, err := readPrivateKeyPEM(filePath)
 	if err != nil {
 		return nil, err
 	}
-	return x509.ParsePKCS1PrivateKey(b)
+	return ParsePrivateKey(block.Bytes)
+}
+
+// ParsePrivateKey parses an unencrypted PKCS #8, PKCS #1 or SEC 1 private key.
+// The encoding is detected from der, as not every tool labels PEM blocks
+// consistently.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("private key is not PKCS #8, PKCS #1 or SEC 1")
+}
+
+// ReadSecretFile reads a secret such as the PSK from filePath, trimming trailing
+// whitespace. Files readable by other users are refused.
+func ReadSecretFile(filePath string) (string, error) {
+	fi, err := AppFs.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode().Perm()&0004 != 0 {
+		return "", fmt.Errorf("%s is world-readable (mode %s), restrict it with chmod 600", filePath, fi.Mode().Perm())
+	}
+	data, err := afero.ReadFile(AppFs, filePath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n\t "), nil
 }
 
 // InitStorage creates the storage directory, if dirPath does not already exist. This
@@ -111,20 +331,23 @@ func StorePath(filePath string) string {
 	return path.Join(storagePath, filePath)
 }
 
-// GetCACertPool returns a x509.CertPool containing the RootCA generated by init-ca
+// GetCACertPool returns a x509.CertPool containing the RootCA generated by init-ca,
+// or every certificate of the PEM bundle at path
 func GetCACertPool(path string) (*x509.CertPool, error) {
 	if len(path) == 0 {
 		path = StorePath(RootCAFile)
 	}
 	certPool := x509.NewCertPool()
-	certBytes, err := ReadCertificatePEM(path)
+	chain, err := ReadCertificateChainPEM(path)
 	if err != nil {
 		return nil, err
 	}
-	cert, err := x509.ParseCertificate(certBytes)
-	if err != nil {
-		return nil, err
+	for _, certBytes := range chain {
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			return nil, err
+		}
+		certPool.AddCert(cert)
 	}
-	certPool.AddCert(cert)
 	return certPool, nil
 }
@@ -1,7 +1,11 @@
 package gen
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"github.com/spf13/afero"
 	"os"
@@ -78,12 +82,17 @@ fK2XHqIW9vWiBCBpTU96wTjellzmn3K+D46CBOwwoL+OBRC3I2ZC
 		t.Fatalf("private key file does not have the correct permissions: %s", mode.Perm())
 	}
 
-	resultKey, err := ReadPrivateKey(p)
+	result, err := ReadPrivateKey(p)
 
 	if err != nil {
 		t.Fatalf("error parsing private key")
 	}
 
+	resultKey, ok := result.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected an RSA private key, got %T", result)
+	}
+
 	pKeyBytes := pKey.D.Bytes()
 	resultKeyBytes := resultKey.D.Bytes()
 
@@ -140,3 +149,176 @@ TxYx+J5Z
 		t.Fatalf("certificates differ")
 	}
 }
+
+func TestWritePrivateKeyTypes(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+
+	for _, keyType := range []string{KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519} {
+		pKey, err := GenerateKey(keyType, 0)
+		if err != nil {
+			t.Fatalf("error generating %s key: %v", keyType, err)
+		}
+		p := StorePath(keyType + "-key.pem")
+		if err := WritePrivateKey(p, pKey); err != nil {
+			t.Fatalf("failed to write %s key: %v", keyType, err)
+		}
+
+		resultKey, err := ReadPrivateKey(p)
+		if err != nil {
+			t.Fatalf("error reading %s key: %v", keyType, err)
+		}
+
+		expected, _ := x509.MarshalPKIXPublicKey(pKey.Public())
+		actual, _ := x509.MarshalPKIXPublicKey(resultKey.Public())
+		if !bytes.Equal(expected, actual) {
+			t.Fatalf("%s keys differ", keyType)
+		}
+	}
+}
+
+func TestWriteCertificateChain(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+	p := StorePath("test-chain.pem")
+
+	chain := [][]byte{[]byte("leaf"), []byte("intermediate"), []byte("root")}
+	if err := WriteCertificateChain(p, chain); err != nil {
+		t.Fatalf("error writing chain: %v", err)
+	}
+
+	result, err := ReadCertificateChainPEM(p)
+	if err != nil {
+		t.Fatalf("error reading chain: %v", err)
+	}
+	if len(result) != len(chain) {
+		t.Fatalf("expected %d certificates, got %d", len(chain), len(result))
+	}
+	for i := range chain {
+		if !bytes.Equal(chain[i], result[i]) {
+			t.Fatalf("certificate %d differs", i)
+		}
+	}
+}
+
+func TestReadSecretFile(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+	p := StorePath("psk")
+
+	if err := afero.WriteFile(AppFs, p, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("error writing secret: %v", err)
+	}
+	secret, err := ReadSecretFile(p)
+	if err != nil {
+		t.Fatalf("error reading secret: %v", err)
+	}
+	if secret != "s3cret" {
+		t.Fatalf("unexpected secret %q", secret)
+	}
+
+	if err := AppFs.Chmod(p, 0644); err != nil {
+		t.Fatalf("error changing permissions: %v", err)
+	}
+	if _, err := ReadSecretFile(p); err == nil {
+		t.Fatalf("world-readable secret file was accepted")
+	}
+}
+
+func TestKeyFormat(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+	defer func() { _ = SetKeyFormat(KeyFormatPKCS1) }()
+
+	if err := SetKeyFormat("pkcs12"); err == nil {
+		t.Fatalf("unsupported key format accepted")
+	}
+	expected := map[string]map[string]string{
+		KeyFormatPKCS1: {KeyTypeRSA: "RSA PRIVATE KEY", KeyTypeECDSAP256: "EC PRIVATE KEY", KeyTypeEd25519: "PRIVATE KEY"},
+		KeyFormatPKCS8: {KeyTypeRSA: "PRIVATE KEY", KeyTypeECDSAP256: "PRIVATE KEY", KeyTypeEd25519: "PRIVATE KEY"},
+	}
+	for format, blockTypes := range expected {
+		if err := SetKeyFormat(format); err != nil {
+			t.Fatalf("error setting key format %s: %v", format, err)
+		}
+		for keyType, blockType := range blockTypes {
+			pKey, _ := GenerateKey(keyType, 2048)
+			p := StorePath(format + "-" + keyType + "-key.pem")
+			if err := WritePrivateKey(p, pKey); err != nil {
+				t.Fatalf("failed to write %s key as %s: %v", keyType, format, err)
+			}
+			data, _ := afero.ReadFile(AppFs, p)
+			if block, _ := pem.Decode(data); block == nil || block.Type != blockType {
+				t.Fatalf("%s key written as %s is not %s", keyType, format, blockType)
+			}
+			if _, err := ReadPrivateKey(p); err != nil {
+				t.Fatalf("error reading %s key written as %s: %v", keyType, format, err)
+			}
+		}
+	}
+}
+
+func TestReadPEMBundles(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	caCert, _ := GenerateCertificate(
+		MakeCertificateConfig("ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil, true), nil, caKey)
+	otherKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	otherCert, _ := GenerateCertificate(
+		MakeCertificateConfig("OTHER", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil, true), nil, otherKey)
+	sec1, _ := x509.MarshalECPrivateKey(caKey.(*ecdsa.PrivateKey))
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(caKey)
+	params, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+	pemBlock := func(blockType string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	}
+
+	files := map[string][]byte{
+		// openssl ecparam -genkey
+		"ecparam-key.pem": append(pemBlock("EC PARAMETERS", params), pemBlock("EC PRIVATE KEY", sec1)...),
+		// certificate and key in one file
+		"combined.pem": append(pemBlock("CERTIFICATE", caCert), pemBlock("PRIVATE KEY", pkcs8)...),
+		// PKCS #8 labelled as SEC 1
+		"mislabelled-key.pem": pemBlock("EC PRIVATE KEY", pkcs8),
+	}
+	for f, data := range files {
+		_ = afero.WriteFile(AppFs, StorePath(f), data, 0600)
+		key, err := ReadPrivateKey(StorePath(f))
+		if err != nil {
+			t.Fatalf("error reading key from %s: %v", f, err)
+		}
+		if !key.(*ecdsa.PrivateKey).Equal(caKey) {
+			t.Fatalf("key read from %s differs", f)
+		}
+	}
+
+	cert, err := ReadCertificatePEM(StorePath("combined.pem"))
+	if err != nil || !bytes.Equal(cert, caCert) {
+		t.Fatalf("error reading certificate from a combined file: %v", err)
+	}
+	if _, err := ReadCertificatePEM(StorePath("ecparam-key.pem")); err == nil {
+		t.Fatalf("certificate read from a key file")
+	}
+
+	legacy := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1,
+		Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00"}})
+	_ = afero.WriteFile(AppFs, StorePath("legacy-key.pem"), legacy, 0600)
+	if _, err := ReadPrivateKey(StorePath("legacy-key.pem")); err == nil {
+		t.Fatalf("legacy encrypted key was read")
+	}
+
+	bundle := append(pemBlock("CERTIFICATE", caCert), pemBlock("CERTIFICATE", otherCert)...)
+	_ = afero.WriteFile(AppFs, StorePath("bundle.pem"), bundle, 0644)
+	pool, err := GetCACertPool(StorePath("bundle.pem"))
+	if err != nil {
+		t.Fatalf("error reading CA bundle: %v", err)
+	}
+	for _, der := range [][]byte{caCert, otherCert} {
+		c, _ := x509.ParseCertificate(der)
+		if _, err := c.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+			t.Fatalf("%s is not in the CA pool: %v", c.Subject.CommonName, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Private key generation
+
+package gen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+)
+
+// Supported key types
+const (
+	KeyTypeRSA       = "rsa"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeEd25519   = "ed25519"
+)
+
+// MinRSAKeyBits is the smallest RSA modulus GenerateKey will produce
+const MinRSAKeyBits = 2048
+
+// KeyTypes lists every key type understood by GenerateKey
+var KeyTypes = []string{KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519}
+
+// GenerateKey creates a new private key of the given type. bits is only
+// used for RSA keys, the elliptic curve types have a fixed size.
+func GenerateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		if bits < MinRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits, got %d", MinRSAKeyBits, bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q, must be one of %v", keyType, KeyTypes)
+	}
+}
+
+// isRSA reports whether pub is an RSA public key. Key encipherment only makes
+// sense for RSA so the key usage is adjusted accordingly.
+func isRSA(pub crypto.PublicKey) bool {
+	_, ok := pub.(*rsa.PublicKey)
+	return ok
+}
+
+// GenerateKeyLike creates a new private key of the same type and size as key,
+// used to rotate keys on renewal
+func GenerateKeyLike(key crypto.Signer) (crypto.Signer, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return GenerateKey(KeyTypeRSA, pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return GenerateKey(KeyTypeECDSAP256, 0)
+		case elliptic.P384():
+			return GenerateKey(KeyTypeECDSAP384, 0)
+		}
+		return nil, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return GenerateKey(KeyTypeEd25519, 0)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package gen
+
+import (
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// LockFile does nothing where flock is not available, only the mutex of the
+// caller serializes access
+func LockFile(f afero.File, timeout time.Duration) error {
+	return nil
+}
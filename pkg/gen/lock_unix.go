@@ -0,0 +1,30 @@
+//go:build unix
+
+package gen
+
+import (
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// LockFile takes an exclusive flock on f, waiting up to timeout for another
+// process to release it. Closing f releases the lock. Files without a
+// descriptor, those of an in-memory file system, are not locked and callers
+// serialize access within the process with a mutex.
+func LockFile(f afero.File, timeout time.Duration) error {
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,49 @@
+// Delegated OCSP signing certificates
+
+package gen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"log"
+	"time"
+)
+
+// oidOCSPNoCheck marks a delegated responder certificate whose revocation status
+// should not be checked, RFC 6960 section 4.2.2.2.1
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// IssueOCSPSigner creates a certificate for pub that is only valid for signing
+// OCSP responses on behalf of issuer
+func IssueOCSPSigner(commonName string, pub crypto.PublicKey, issuer *x509.Certificate,
+	issuerKey crypto.Signer, validity time.Duration) ([]byte, error) {
+	serialNumber, err := generateSerialNumber()
+	if err != nil {
+		log.Printf("failed to generate serial number: %s", err)
+		return nil, err
+	}
+
+	notBefore, notAfter := validityPeriod(validity, 0, issuer)
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		BasicConstraintsValid: true,
+
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidOCSPNoCheck, Value: asn1.NullBytes},
+		},
+	}
+
+	log.Printf("Generating OCSP signing certificate - SN: %x", template.SerialNumber)
+	return x509.CreateCertificate(rand.Reader, &template, issuer, pub, issuerKey)
+}
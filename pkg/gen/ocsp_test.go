@@ -0,0 +1,42 @@
+package gen
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestIssueOCSPSigner(t *testing.T) {
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	caDer, _ := GenerateCertificate(config, nil, caKey)
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	signerKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	der, err := IssueOCSPSigner("OCSP", signerKey.Public(), caCert, caKey, time.Hour)
+	if err != nil {
+		t.Fatalf("error issuing OCSP signer: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("OCSP signer is invalid: %v", err)
+	}
+
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageOCSPSigning {
+		t.Fatalf("unexpected extended key usage: %v", cert.ExtKeyUsage)
+	}
+	if cert.IsCA {
+		t.Fatalf("OCSP signer must not be a CA")
+	}
+	noCheck := false
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oidOCSPNoCheck) {
+			noCheck = true
+		}
+	}
+	if !noCheck {
+		t.Fatalf("OCSP signer is missing the ocsp-nocheck extension")
+	}
+}
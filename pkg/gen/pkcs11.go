@@ -0,0 +1,353 @@
+//go:build cgo
+
+// PKCS#11 signer backend keeping the CA key inside a token
+
+package gen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+var (
+	oidCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+// pkcs1DigestInfoPrefixes are the DER encoded DigestInfo headers of PKCS#1 v1.5
+// signatures, which the CKM_RSA_PKCS mechanism expects in front of the digest
+var pkcs1DigestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pkcs11PSSHashes are the digest mechanism and MGF1 function of RSA-PSS
+// signatures with each hash, MGF1 uses the hash of the message like crypto/rsa
+var pkcs11PSSHashes = map[crypto.Hash]struct{ mechanism, mgf uint }{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// pkcs11Backend holds a key pair identified by its label in a PKCS#11 token.
+// Sessions are not safe for concurrent use, so every operation holds mu.
+type pkcs11Backend struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	label   string
+}
+
+// OpenPKCS11 loads the PKCS#11 module and logs in to the token in config.Slot
+func OpenPKCS11(config PKCS11Config) (SignerBackend, error) {
+	if config.Label == "" {
+		return nil, errors.New("a PKCS#11 key label is required")
+	}
+	ctx := pkcs11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("could not load PKCS#11 module %s", config.Module)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("error initializing PKCS#11 module : %v", err)
+	}
+	p := &pkcs11Backend{ctx: ctx, label: config.Label}
+
+	var err error
+	p.session, err = ctx.OpenSession(config.Slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		p.finalize()
+		return nil, fmt.Errorf("error opening PKCS#11 session on slot %d : %v", config.Slot, err)
+	}
+	err = ctx.Login(p.session, pkcs11.CKU_USER, config.PIN)
+	if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		_ = ctx.CloseSession(p.session)
+		p.finalize()
+		return nil, fmt.Errorf("error logging in to PKCS#11 token : %v", err)
+	}
+	return p, nil
+}
+
+func (p *pkcs11Backend) finalize() {
+	_ = p.ctx.Finalize()
+	p.ctx.Destroy()
+}
+
+// Close logs out of the token and unloads the module
+func (p *pkcs11Backend) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_ = p.ctx.Logout(p.session)
+	err := p.ctx.CloseSession(p.session)
+	p.finalize()
+	return err
+}
+
+// findObject returns the object of class with the label of the backend
+func (p *pkcs11Backend) findObject(class uint) (pkcs11.ObjectHandle, bool, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.label),
+	}
+	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return 0, false, err
+	}
+	objects, _, err := p.ctx.FindObjects(p.session, 2)
+	if finalErr := p.ctx.FindObjectsFinal(p.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	switch len(objects) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return objects[0], true, nil
+	default:
+		return 0, false, fmt.Errorf("more than one PKCS#11 object is labelled %s", p.label)
+	}
+}
+
+// GenerateKey creates a key pair labelled with the label of the backend in the
+// token. The private key can not be extracted. Ed25519 keys are not supported.
+func (p *pkcs11Backend) GenerateKey(keyType string, bits int) (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok, err := p.findObject(pkcs11.CKO_PRIVATE_KEY); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("the PKCS#11 token already holds a key labelled %s", p.label)
+	}
+
+	public := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.label),
+	}
+	private := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.label),
+	}
+
+	var mechanism uint
+	switch keyType {
+	case KeyTypeRSA:
+		if bits < MinRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits, got %d", MinRSAKeyBits, bits)
+		}
+		mechanism = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+	case KeyTypeECDSAP256, KeyTypeECDSAP384:
+		curve := oidCurveP256
+		if keyType == KeyTypeECDSAP384 {
+			curve = oidCurveP384
+		}
+		params, err := asn1.Marshal(curve)
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.CKM_EC_KEY_PAIR_GEN
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %q, must be one of %v",
+			keyType, []string{KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384})
+	}
+
+	pub, priv, err := p.ctx.GenerateKeyPair(p.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private)
+	if err != nil {
+		return nil, fmt.Errorf("error generating PKCS#11 key : %v", err)
+	}
+	return p.signer(pub, priv)
+}
+
+// LoadKey returns the key pair labelled with the label of the backend
+func (p *pkcs11Backend) LoadKey() (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	priv, ok, err := p.findObject(pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("the PKCS#11 token holds no private key labelled %s", p.label)
+	}
+	pub, ok, err := p.findObject(pkcs11.CKO_PUBLIC_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("the PKCS#11 token holds no public key labelled %s", p.label)
+	}
+	return p.signer(pub, priv)
+}
+
+// signer reads the public key of a key pair and returns the signer of its private key
+func (p *pkcs11Backend) signer(pub, priv pkcs11.ObjectHandle) (crypto.Signer, error) {
+	attrs, err := p.ctx.GetAttributeValue(p.session, pub, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyType := bytesToUint(attrs[0].Value)
+
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := p.ctx.GetAttributeValue(p.session, pub, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		e := new(big.Int).SetBytes(attrs[1].Value)
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("unsupported RSA public exponent")
+		}
+		return &pkcs11Key{backend: p, handle: priv,
+			public: &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(e.Int64())}}, nil
+	case pkcs11.CKK_EC:
+		attrs, err := p.ctx.GetAttributeValue(p.session, pub, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		var curveOID asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(attrs[0].Value, &curveOID); err != nil {
+			return nil, fmt.Errorf("invalid EC parameters : %v", err)
+		}
+		var curve elliptic.Curve
+		switch {
+		case curveOID.Equal(oidCurveP256):
+			curve = elliptic.P256()
+		case curveOID.Equal(oidCurveP384):
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", curveOID)
+		}
+		// CKA_EC_POINT is the DER encoding of the uncompressed point in an OCTET STRING
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil {
+			point = attrs[1].Value
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("invalid EC point")
+		}
+		return &pkcs11Key{backend: p, handle: priv, public: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %d", keyType)
+	}
+}
+
+// bytesToUint decodes a CK_ULONG attribute value, which is in host byte order
+func bytesToUint(b []byte) uint {
+	switch len(b) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	default:
+		return 0
+	}
+}
+
+// pkcs11Key signs with a private key that stays in the token
+type pkcs11Key struct {
+	backend *pkcs11Backend
+	handle  pkcs11.ObjectHandle
+	public  crypto.PublicKey
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign signs digest with PKCS#1 v1.5, or RSA-PSS when opts is *rsa.PSSOptions
+// as TLS 1.3 requires, for RSA keys and ECDSA for EC keys
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	data := digest
+	if pub, ok := k.public.(*rsa.PublicKey); ok {
+		var err error
+		if mechanism, data, err = rsaMechanism(pub, digest, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	k.backend.mu.Lock()
+	defer k.backend.mu.Unlock()
+	ctx, session := k.backend.ctx, k.backend.session
+	if err := ctx.SignInit(session, []*pkcs11.Mechanism{mechanism}, k.handle); err != nil {
+		return nil, fmt.Errorf("error signing with PKCS#11 key : %v", err)
+	}
+	signature, err := ctx.Sign(session, data)
+	if err != nil {
+		return nil, fmt.Errorf("error signing with PKCS#11 key : %v", err)
+	}
+
+	if _, ok := k.public.(*ecdsa.PublicKey); ok {
+		// PKCS#11 returns r and s concatenated, x509 expects an ASN.1 sequence
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(signature[:half]),
+			new(big.Int).SetBytes(signature[half:]),
+		})
+	}
+	return signature, nil
+}
+
+// rsaMechanism returns the mechanism signing digest with pub as requested by
+// opts and the data it signs
+func rsaMechanism(pub *rsa.PublicKey, digest []byte, opts crypto.SignerOpts) (*pkcs11.Mechanism, []byte, error) {
+	pss, ok := opts.(*rsa.PSSOptions)
+	if !ok {
+		prefix, ok := pkcs1DigestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+		}
+		return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), append(append([]byte{}, prefix...), digest...), nil
+	}
+
+	hash, ok := pkcs11PSSHashes[pss.Hash]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported hash function %v", pss.Hash)
+	}
+	saltLength := pss.SaltLength
+	switch saltLength {
+	case rsa.PSSSaltLengthEqualsHash:
+		saltLength = pss.Hash.Size()
+	case rsa.PSSSaltLengthAuto:
+		// the largest salt that fits, as crypto/rsa signs with
+		saltLength = (pub.N.BitLen()-1+7)/8 - 2 - pss.Hash.Size()
+	}
+	if saltLength < 0 {
+		return nil, nil, fmt.Errorf("invalid RSA-PSS salt length %d", pss.SaltLength)
+	}
+	params := pkcs11.NewPSSParams(hash.mechanism, hash.mgf, uint(saltLength))
+	return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params), digest, nil
+}
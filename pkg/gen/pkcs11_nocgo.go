@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package gen
+
+import "errors"
+
+// OpenPKCS11 is not available since PKCS#11 modules are loaded through cgo
+func OpenPKCS11(config PKCS11Config) (SignerBackend, error) {
+	return nil, errors.New("PKCS#11 support requires a build with cgo enabled")
+}
@@ -0,0 +1,141 @@
+//go:build cgo
+
+package gen
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// softHSMModules are the usual install locations of the SoftHSM module, which
+// SOFTHSM2_MODULE overrides
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// softHSMToken initializes a SoftHSM token in a temporary directory and returns
+// the module and the slot of the token. The test is skipped without SoftHSM.
+func softHSMToken(t *testing.T) (string, uint) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	for _, m := range softHSMModules {
+		if module != "" {
+			break
+		}
+		if _, err := os.Stat(m); err == nil {
+			module = m
+		}
+	}
+	if _, err := exec.LookPath("softhsm2-util"); module == "" || err != nil {
+		t.Skip("SoftHSM is not installed, set SOFTHSM2_MODULE to run PKCS#11 tests")
+	}
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+dir+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatalf("error writing SoftHSM config: %v", err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "ca-test",
+		"--pin", "1234", "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatalf("error initializing SoftHSM token: %v\n%s", err, out)
+	}
+
+	// SoftHSM assigns a new slot to the initialized token
+	ctx := pkcs11.New(module)
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("error initializing %s: %v", module, err)
+	}
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatalf("error listing slots: %v", err)
+	}
+	for _, slot := range slots {
+		if info, err := ctx.GetTokenInfo(slot); err == nil && strings.TrimSpace(info.Label) == "ca-test" {
+			return module, slot
+		}
+	}
+	t.Fatalf("initialized token not found")
+	return "", 0
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	module, slot := softHSMToken(t)
+
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeECDSAP256, KeyTypeECDSAP384} {
+		config := PKCS11Config{Module: module, Slot: slot, Label: "root-" + keyType, PIN: "1234"}
+		backend, err := OpenPKCS11(config)
+		if err != nil {
+			t.Fatalf("error opening token: %v", err)
+		}
+		key, err := backend.GenerateKey(keyType, 2048)
+		if err != nil {
+			t.Fatalf("error generating %s key: %v", keyType, err)
+		}
+		if _, err := backend.GenerateKey(keyType, 2048); err == nil {
+			t.Fatalf("second %s key was generated with the same label", keyType)
+		}
+		caDer, err := GenerateCertificate(MakeCertificateConfig(
+			"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil, true), nil, key)
+		if err != nil {
+			t.Fatalf("error creating %s root: %v", keyType, err)
+		}
+		caCert, _ := x509.ParseCertificate(caDer)
+		if err := caCert.CheckSignatureFrom(caCert); err != nil {
+			t.Fatalf("%s root signature is invalid: %v", keyType, err)
+		}
+		if err := backend.Close(); err != nil {
+			t.Fatalf("error closing token: %v", err)
+		}
+
+		// the key is found again by its label
+		backend, err = OpenPKCS11(config)
+		if err != nil {
+			t.Fatalf("error reopening token: %v", err)
+		}
+		key, err = backend.LoadKey()
+		if err != nil {
+			t.Fatalf("error loading %s key: %v", keyType, err)
+		}
+		csr := profileTestCSR(t, MakeCSRConfig("client", "", "", "", "", []string{"localhost"}, nil))
+		signed, err := Sign(csr, caCert, key)
+		if err != nil {
+			t.Fatalf("error signing with %s key: %v", keyType, err)
+		}
+		cert, _ := x509.ParseCertificate(signed)
+		if err := cert.CheckSignatureFrom(caCert); err != nil {
+			t.Fatalf("certificate signed by %s key is invalid: %v", keyType, err)
+		}
+
+		// TLS 1.3 handshakes with an RSA key are signed with RSA-PSS
+		if pub, ok := key.Public().(*rsa.PublicKey); ok {
+			digest := sha256.Sum256([]byte("handshake"))
+			for _, saltLength := range []int{rsa.PSSSaltLengthEqualsHash, rsa.PSSSaltLengthAuto} {
+				opts := &rsa.PSSOptions{SaltLength: saltLength, Hash: crypto.SHA256}
+				signature, err := key.Sign(rand.Reader, digest[:], opts)
+				if err != nil {
+					t.Fatalf("error signing with RSA-PSS: %v", err)
+				}
+				if err := rsa.VerifyPSS(pub, crypto.SHA256, digest[:], signature, opts); err != nil {
+					t.Fatalf("RSA-PSS signature with salt length %d is invalid: %v", saltLength, err)
+				}
+			}
+		}
+		_ = backend.Close()
+	}
+}
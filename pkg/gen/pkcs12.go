@@ -0,0 +1,356 @@
+// PKCS#12 key stores for keys generated on behalf of clients
+
+package gen
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PKCS12Iterations is the iteration count of the key derivations protecting
+// PKCS#12 key stores, PBKDF2 for the key and the RFC 7292 derivation of the
+// MAC key
+const PKCS12Iterations = 100000
+
+var (
+	oidSHA256             = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509CertificateBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+// pkcs12PFX is the outer structure of a key store, RFC 7292 section 4
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pkcs12MacData
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     // [0] EXPLICIT, see explicit
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// pkcs12Password returns password as a NUL terminated BMPString, RFC 7292 appendix B.1
+func pkcs12Password(password string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return append(b, 0, 0)
+}
+
+// pkcs12KDF derives size bytes for purpose id from password and salt with the
+// hash h, RFC 7292 appendix B.2
+func pkcs12KDF(h func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	u, v := h().Size(), h().BlockSize()
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	input := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		digest := h()
+		digest.Write(d)
+		digest.Write(input)
+		a := digest.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			digest.Reset()
+			digest.Write(a)
+			a = digest.Sum(nil)
+		}
+		out = append(out, a...)
+
+		// every v byte block of the input is incremented by 1 + a repeated to v bytes
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(a[k%u]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// explicit wraps der in a [0] EXPLICIT tag. The asn1 package ignores tags of
+// raw values.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	return salt, err
+}
+
+// pkcs12ShroudKey encrypts the PKCS#8 encoding of key with PBES2, deriving an
+// AES-256-CBC key from password with PBKDF2-HMAC-SHA256. The password is used
+// as UTF-8 like OpenSSL does for PBES2, RFC 9579 section 5.
+func pkcs12ShroudKey(key crypto.Signer, password string) ([]byte, error) {
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), salt, PKCS12Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	padding := block.BlockSize() - len(plain)%block.BlockSize()
+	for i := 0; i < padding; i++ {
+		plain = append(plain, byte(padding))
+	}
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	kdf, err := marshalAlgorithm(oidPBKDF2, pbkdf2Params{
+		Salt:           salt,
+		IterationCount: PKCS12Iterations,
+		KeyLength:      32,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := marshalAlgorithm(oidAES256CBC, iv)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := marshalAlgorithm(oidPBES2, pbes2Params{KeyDerivationFunc: kdf, EncryptionScheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: encrypted})
+}
+
+// pkcs12MAC returns the HMAC-SHA256 of authSafe keyed by password and salt
+func pkcs12MAC(authSafe, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, pkcs12KDF(sha256.New, 3, password, salt, iterations, sha256.Size))
+	mac.Write(authSafe)
+	return mac.Sum(nil)
+}
+
+// pkcs12Data wraps content in a PKCS#7 data content info
+func pkcs12Data(content []byte) (pkcs7ContentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return pkcs7ContentInfo{}, err
+	}
+	return pkcs7ContentInfo{
+		ContentType: oidPKCS7Data,
+		Content:     explicit(octets),
+	}, nil
+}
+
+// EncodePKCS12 returns a DER encoded PKCS#12 key store holding key and the DER
+// encoded certificates, leaf first. The key is encrypted and the store is
+// authenticated with password, the certificates are not encrypted. The store
+// uses the algorithms OpenSSL 3 writes by default: PBES2 with AES-256-CBC and
+// PBKDF2-HMAC-SHA256 for the key and an HMAC-SHA256 MAC.
+func EncodePKCS12(key crypto.Signer, certificates [][]byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("a PKCS#12 password is required")
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("a PKCS#12 key store requires a certificate")
+	}
+	pw := pkcs12Password(password)
+
+	// the local key ID ties the key to its certificate
+	keyID := sha1.Sum(certificates[0])
+	keyIDValue, err := asn1.Marshal(keyID[:])
+	if err != nil {
+		return nil, err
+	}
+	localKeyID := []pkcs12Attribute{{ID: oidLocalKeyID, Values: []asn1.RawValue{{FullBytes: keyIDValue}}}}
+
+	var certBags []pkcs12SafeBag
+	for i, c := range certificates {
+		certBag, err := asn1.Marshal(pkcs12CertBag{ID: oidX509CertificateBag, Data: c})
+		if err != nil {
+			return nil, err
+		}
+		bag := pkcs12SafeBag{ID: oidCertBag, Value: explicit(certBag)}
+		if i == 0 {
+			bag.Attributes = localKeyID
+		}
+		certBags = append(certBags, bag)
+	}
+	shrouded, err := pkcs12ShroudKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []pkcs12SafeBag{{ID: oidShroudedKeyBag, Value: explicit(shrouded),
+		Attributes: localKeyID}}
+
+	// certificates and key are kept in separate safes, as written by OpenSSL
+	var safes []pkcs7ContentInfo
+	for _, bags := range [][]pkcs12SafeBag{certBags, keyBags} {
+		contents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		safe, err := pkcs12Data(contents)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, safe)
+	}
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	content, err := pkcs12Data(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs12PFX{
+		Version:  3,
+		AuthSafe: content,
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+				Digest:    pkcs12MAC(authSafe, pw, salt, PKCS12Iterations),
+			},
+			MacSalt:    salt,
+			Iterations: PKCS12Iterations,
+		},
+	})
+}
+
+// DecodePKCS12 returns the key and the certificates of a key store written by
+// EncodePKCS12. Other PKCS#12 encryption and MAC algorithms are not supported.
+func DecodePKCS12(der []byte, password string) (crypto.Signer, [][]byte, error) {
+	var pfx pkcs12PFX
+	if _, err := asn1.Unmarshal(der, &pfx); err != nil {
+		return nil, nil, err
+	}
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA256) {
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 MAC algorithm %v", pfx.MacData.Mac.Algorithm.Algorithm)
+	}
+	authSafe, err := pkcs12Content(pfx.AuthSafe)
+	if err != nil {
+		return nil, nil, err
+	}
+	expected := pkcs12MAC(authSafe, pkcs12Password(password), pfx.MacData.MacSalt, pfx.MacData.Iterations)
+	if !hmac.Equal(expected, pfx.MacData.Mac.Digest) {
+		return nil, nil, errors.New("incorrect password or corrupted PKCS#12 key store")
+	}
+
+	var safes []pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &safes); err != nil {
+		return nil, nil, err
+	}
+	var key crypto.Signer
+	var certificates [][]byte
+	for _, safe := range safes {
+		contents, err := pkcs12Content(safe)
+		if err != nil {
+			return nil, nil, err
+		}
+		var bags []pkcs12SafeBag
+		if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+			return nil, nil, err
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var certBag pkcs12CertBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &certBag); err != nil {
+					return nil, nil, err
+				}
+				certificates = append(certificates, certBag.Data)
+			case bag.ID.Equal(oidShroudedKeyBag):
+				plain, err := DecryptPKCS8(bag.Value.Bytes, []byte(password))
+				if err != nil {
+					return nil, nil, err
+				}
+				if key, err = ParsePrivateKey(plain); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	if key == nil || len(certificates) == 0 {
+		return nil, nil, errors.New("PKCS#12 key store does not hold a key and a certificate")
+	}
+	return key, certificates, nil
+}
+
+// pkcs12Content returns the content of a PKCS#7 data content info
+func pkcs12Content(info pkcs7ContentInfo) ([]byte, error) {
+	if !info.ContentType.Equal(oidPKCS7Data) {
+		return nil, fmt.Errorf("unsupported PKCS#12 content type %v", info.ContentType)
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
@@ -0,0 +1,62 @@
+package gen
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestEncodePKCS12(t *testing.T) {
+	caCert, caKey := profileTestCA(t)
+	key, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	csrBytes, err := GenerateCSR(MakeCSRConfig("client", "", "", "", "", []string{"localhost"}, nil), key)
+	if err != nil {
+		t.Fatalf("error generating csr: %v", err)
+	}
+	csr, _ := x509.ParseCertificateRequest(csrBytes)
+	signed, err := Sign(csr, caCert, caKey)
+	if err != nil {
+		t.Fatalf("error signing csr: %v", err)
+	}
+
+	if _, err := EncodePKCS12(key, [][]byte{signed}, ""); err == nil {
+		t.Fatalf("PKCS#12 key store was encoded without a password")
+	}
+	der, err := EncodePKCS12(key, [][]byte{signed, caCert.Raw}, "päss")
+	if err != nil {
+		t.Fatalf("error encoding PKCS#12: %v", err)
+	}
+
+	if _, _, err := DecodePKCS12(der, "wrong"); err == nil {
+		t.Fatalf("PKCS#12 key store was decoded with the wrong password")
+	}
+	decodedKey, certificates, err := DecodePKCS12(der, "päss")
+	if err != nil {
+		t.Fatalf("error decoding PKCS#12: %v", err)
+	}
+	if len(certificates) != 2 || !bytes.Equal(certificates[0], signed) || !bytes.Equal(certificates[1], caCert.Raw) {
+		t.Fatalf("PKCS#12 key store does not hold the certificates")
+	}
+	if !key.(*ecdsa.PrivateKey).Equal(decodedKey) {
+		t.Fatalf("PKCS#12 key store does not hold the key")
+	}
+
+	// the key is protected with PBES2 and the store with an HMAC-SHA256 MAC
+	var pfx pkcs12PFX
+	_, _ = asn1.Unmarshal(der, &pfx)
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA256) || pfx.MacData.Iterations != PKCS12Iterations {
+		t.Fatalf("unexpected PKCS#12 MAC %v with %d iterations", pfx.MacData.Mac.Algorithm.Algorithm,
+			pfx.MacData.Iterations)
+	}
+	shrouded, _ := pkcs12ShroudKey(key, "päss")
+	var info encryptedPrivateKeyInfo
+	_, _ = asn1.Unmarshal(shrouded, &info)
+	var params pbes2Params
+	_ = unmarshalParams(info.Algorithm, &params)
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) || !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) ||
+		!params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		t.Fatalf("key is not encrypted with PBES2, PBKDF2 and AES-256-CBC")
+	}
+}
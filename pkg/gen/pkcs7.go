@@ -0,0 +1,85 @@
+// PKCS#7 certs-only messages as used by EST
+
+package gen
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// pkcs7SignedData is a degenerate SignedData without signers, RFC 2315 section 9.1
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      asn1.RawValue
+}
+
+func emptySet() asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+}
+
+// EncodePKCS7CertsOnly returns a DER encoded PKCS#7 certs-only message holding
+// the DER encoded certificates
+func EncodePKCS7CertsOnly(certificates ...[]byte) ([]byte, error) {
+	var certs []byte
+	for _, c := range certificates {
+		certs = append(certs, c...)
+	}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet(),
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+			Bytes: certs},
+		SignerInfos: emptySet(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// ParsePKCS7CertsOnly returns the DER encoded certificates of a PKCS#7 message
+func ParsePKCS7CertsOnly(der []byte) ([][]byte, error) {
+	var info pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after PKCS#7 message")
+	}
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, fmt.Errorf("PKCS#7 content type %v is not signed data", info.ContentType)
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+
+	var certificates [][]byte
+	rest := signedData.Certificates.Bytes
+	for len(rest) > 0 {
+		var cert asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &cert); err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, cert.FullBytes)
+	}
+	return certificates, nil
+}
@@ -0,0 +1,32 @@
+package gen
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPKCS7CertsOnly(t *testing.T) {
+	caCert, caKey := profileTestCA(t)
+	csr := profileTestCSR(t, MakeCSRConfig(
+		"client", "US", "TX", "San Antonio", "Mesosphere Inc.", []string{"localhost"}, nil))
+	signed, err := Sign(csr, caCert, caKey)
+	if err != nil {
+		t.Fatalf("error signing csr: %v", err)
+	}
+
+	der, err := EncodePKCS7CertsOnly(signed, caCert.Raw)
+	if err != nil {
+		t.Fatalf("error encoding PKCS#7: %v", err)
+	}
+	certificates, err := ParsePKCS7CertsOnly(der)
+	if err != nil {
+		t.Fatalf("error parsing PKCS#7: %v", err)
+	}
+	if len(certificates) != 2 || !bytes.Equal(certificates[0], signed) || !bytes.Equal(certificates[1], caCert.Raw) {
+		t.Fatalf("PKCS#7 message does not hold the encoded certificates")
+	}
+
+	if _, err := ParsePKCS7CertsOnly(signed); err == nil {
+		t.Fatalf("certificate was parsed as PKCS#7")
+	}
+}
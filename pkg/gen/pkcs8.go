@@ -0,0 +1,268 @@
+// Encrypted PKCS#8 private keys for keys at rest
+
+package gen
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrKeyLocked is returned when reading an encrypted private key without a
+// passphrase, see SetKeyPassphrase
+var ErrKeyLocked = errors.New("private key is encrypted and no passphrase was given")
+
+// Parameters of the scrypt key derivation protecting private keys, RFC 7914
+// section 2 recommends N = 2^15 for interactive use
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES128GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAES192GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	oidAES256GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+)
+
+// keyPassphrase encrypts the private keys written and decrypts those read
+var keyPassphrase []byte
+
+// SetKeyPassphrase sets the passphrase WritePrivateKey encrypts keys with and
+// ReadPrivateKey decrypts them with. Keys are written unencrypted when it is
+// empty.
+func SetKeyPassphrase(passphrase []byte) {
+	keyPassphrase = passphrase
+}
+
+// encryptedPrivateKeyInfo is an encrypted PKCS#8 key, RFC 5958 section 3
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the parameters of the PBES2 scheme, RFC 8018 appendix A.4
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of PBKDF2, RFC 8018 appendix A.2
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// scryptParams are the parameters of scrypt, RFC 7914 section 7
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// gcmParams are the parameters of AES-GCM, RFC 5084 section 3.2
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"default:12,optional"`
+}
+
+func marshalAlgorithm(oid asn1.ObjectIdentifier, params interface{}) (pkix.AlgorithmIdentifier, error) {
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: der}}, nil
+}
+
+func unmarshalParams(alg pkix.AlgorithmIdentifier, params interface{}) error {
+	rest, err := asn1.Unmarshal(alg.Parameters.FullBytes, params)
+	if err == nil && len(rest) > 0 {
+		err = errors.New("trailing data")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid parameters of %v : %v", alg.Algorithm, err)
+	}
+	return nil
+}
+
+// EncryptPKCS8 encrypts a DER encoded PKCS#8 private key with PBES2, deriving
+// an AES-256-GCM key from passphrase with scrypt
+func EncryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	kdf, err := marshalAlgorithm(oidScrypt, scryptParams{
+		Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: 32})
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := marshalAlgorithm(oidAES256GCM, gcmParams{Nonce: nonce, ICVLen: gcm.Overhead()})
+	if err != nil {
+		return nil, err
+	}
+	alg, err := marshalAlgorithm(oidPBES2, pbes2Params{KeyDerivationFunc: kdf, EncryptionScheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: gcm.Seal(nil, nonce, der, nil)})
+}
+
+// deriveKey derives a key of size bytes from passphrase with the PBES2 key
+// derivation function kdf
+func deriveKey(kdf pkix.AlgorithmIdentifier, passphrase []byte, size int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidScrypt):
+		var p scryptParams
+		if err := unmarshalParams(kdf, &p); err != nil {
+			return nil, err
+		}
+		return scrypt.Key(passphrase, p.Salt, p.CostParameter, p.BlockSize, p.ParallelizationParameter, size)
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var p pbkdf2Params
+		if err := unmarshalParams(kdf, &p); err != nil {
+			return nil, err
+		}
+		var h func() hash.Hash
+		switch {
+		case len(p.PRF.Algorithm) == 0 || p.PRF.Algorithm.Equal(oidHMACWithSHA1):
+			h = sha1.New
+		case p.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			h = sha256.New
+		default:
+			return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %v", p.PRF.Algorithm)
+		}
+		return pbkdf2.Key(passphrase, p.Salt, p.IterationCount, size, h), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %v", kdf.Algorithm)
+	}
+}
+
+// DecryptPKCS8 decrypts a DER encoded encrypted PKCS#8 private key protected
+// with PBES2. Keys derived with scrypt or PBKDF2 and encrypted with AES-GCM or
+// AES-CBC, as written by OpenSSL, are supported.
+func DecryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after encrypted private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %v", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if err := unmarshalParams(info.Algorithm, &params); err != nil {
+		return nil, err
+	}
+
+	scheme := params.EncryptionScheme
+	var keySize int
+	gcm := false
+	switch {
+	case scheme.Algorithm.Equal(oidAES128GCM):
+		keySize, gcm = 16, true
+	case scheme.Algorithm.Equal(oidAES192GCM):
+		keySize, gcm = 24, true
+	case scheme.Algorithm.Equal(oidAES256GCM):
+		keySize, gcm = 32, true
+	case scheme.Algorithm.Equal(oidAES128CBC):
+		keySize = 16
+	case scheme.Algorithm.Equal(oidAES192CBC):
+		keySize = 24
+	case scheme.Algorithm.Equal(oidAES256CBC):
+		keySize = 32
+	default:
+		return nil, fmt.Errorf("unsupported private key cipher %v", scheme.Algorithm)
+	}
+
+	key, err := deriveKey(params.KeyDerivationFunc, passphrase, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcm {
+		var p gcmParams
+		if err := unmarshalParams(scheme, &p); err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCMWithTagSize(block, p.ICVLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.Nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("invalid AES-GCM nonce size %d", len(p.Nonce))
+		}
+		plain, err := aead.Open(nil, p.Nonce, info.EncryptedData, nil)
+		if err != nil {
+			return nil, errors.New("incorrect passphrase or corrupted private key")
+		}
+		return plain, nil
+	}
+
+	var iv []byte
+	if err := unmarshalParams(scheme, &iv); err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid AES-CBC encrypted private key")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, errors.New("incorrect passphrase or corrupted private key")
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("incorrect passphrase or corrupted private key")
+		}
+	}
+	return plain[:len(plain)-padding], nil
+}
@@ -0,0 +1,304 @@
+// Certificate profiles controlling the extensions of signed certificates
+
+package gen
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in profile names
+const (
+	ProfileServer      = "server"
+	ProfileClient      = "client"
+	ProfilePeer        = "peer"
+	ProfileCodeSigning = "code-signing"
+)
+
+// ErrProfileViolation is returned when a CSR asks for something its profile does not allow
+var ErrProfileViolation = errors.New("request violates certificate profile")
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"cert_sign":          x509.KeyUsageCertSign,
+	"crl_sign":           x509.KeyUsageCRLSign,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+var oidBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+type basicConstraints struct {
+	IsCA       bool `asn1:"optional"`
+	MaxPathLen int  `asn1:"optional,default:-1"`
+}
+
+// Profile controls the key usages, lifetime and names of a signed certificate
+type Profile struct {
+	KeyUsage    []string      `yaml:"key_usage"`
+	ExtKeyUsage []string      `yaml:"ext_key_usage"`
+	MaxValidity time.Duration `yaml:"max_validity"` // zero leaves the lifetime to the signer
+	AllowedSANs []string      `yaml:"allowed_sans"` // globs for DNS names and emails, CIDRs for IPs; empty allows any
+	AllowCA     bool          `yaml:"allow_ca"`     // CSRs may request CA=true through basic constraints
+	MaxPathLen  int           `yaml:"max_path_len"` // path length imposed on CA certificates
+}
+
+// Profiles is a set of named profiles along with the one used when none is requested
+type Profiles struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// DefaultProfiles returns the built-in profiles. The default profile, peer, allows
+// both server and client authentication like certificates signed before profiles existed.
+// No built-in profile allows CA certificates, those must be defined in a profiles file.
+func DefaultProfiles() *Profiles {
+	return &Profiles{
+		Default: ProfilePeer,
+		Profiles: map[string]*Profile{
+			ProfileServer: {
+				KeyUsage:    []string{"digital_signature", "key_encipherment"},
+				ExtKeyUsage: []string{"server_auth"},
+			},
+			ProfileClient: {
+				KeyUsage:    []string{"digital_signature", "key_encipherment"},
+				ExtKeyUsage: []string{"client_auth"},
+			},
+			ProfilePeer: {
+				KeyUsage:    []string{"digital_signature", "key_encipherment"},
+				ExtKeyUsage: []string{"server_auth", "client_auth"},
+			},
+			ProfileCodeSigning: {
+				KeyUsage:    []string{"digital_signature"},
+				ExtKeyUsage: []string{"code_signing"},
+			},
+		},
+	}
+}
+
+// LoadProfiles reads profiles from a YAML or JSON file. Profiles in the file
+// replace built-in profiles of the same name, other built-in profiles are kept.
+func LoadProfiles(filePath string) (*Profiles, error) {
+	data, err := afero.ReadFile(AppFs, filePath)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &Profiles{}
+	// a misspelled field would silently drop the usages or lifetime of a profile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(loaded); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing %s : %v", filePath, err)
+	}
+
+	profiles := DefaultProfiles()
+	if loaded.Default != "" {
+		profiles.Default = loaded.Default
+	}
+	for name, p := range loaded.Profiles {
+		profiles.Profiles[name] = p
+	}
+	return profiles, profiles.Validate()
+}
+
+// Names returns the sorted profile names
+func (p *Profiles) Names() []string {
+	var names []string
+	for name := range p.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks every profile for unknown key usages and malformed patterns
+func (p *Profiles) Validate() error {
+	if _, ok := p.Profiles[p.Default]; !ok {
+		return fmt.Errorf("default profile %q is not defined", p.Default)
+	}
+	for name, profile := range p.Profiles {
+		if profile == nil {
+			return fmt.Errorf("profile %s is empty", name)
+		}
+		if _, _, err := profile.usages(); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+		for _, pattern := range profile.AllowedSANs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("profile %s: invalid SAN pattern %q", name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// CANames returns the sorted names of the profiles allowing CA certificates
+func (p *Profiles) CANames() []string {
+	var names []string
+	for _, name := range p.Names() {
+		if p.Profiles[name].AllowCA {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Get returns the named profile, or the default profile when name is empty
+func (p *Profiles) Get(name string) (*Profile, error) {
+	if name == "" {
+		name = p.Default
+	}
+	profile, ok := p.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, must be one of: %s", name, strings.Join(p.Names(), ", "))
+	}
+	return profile, nil
+}
+
+func (p *Profile) usages() (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, name := range p.KeyUsage {
+		u, ok := keyUsages[name]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown key usage %q", name)
+		}
+		ku |= u
+	}
+	var eku []x509.ExtKeyUsage
+	for _, name := range p.ExtKeyUsage {
+		u, ok := extKeyUsages[name]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown extended key usage %q", name)
+		}
+		eku = append(eku, u)
+	}
+	return ku, eku, nil
+}
+
+// sanAllowed reports whether name matches one of the allowed SAN patterns
+func (p *Profile) sanAllowed(name string) bool {
+	if len(p.AllowedSANs) == 0 {
+		return true
+	}
+	for _, pattern := range p.AllowedSANs {
+		if MatchSAN(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchSAN reports whether a subject alternative name matches pattern. Patterns
+// in CIDR notation match IP addresses, any other pattern is a case insensitive
+// glob matched against the name.
+func MatchSAN(pattern, name string) bool {
+	if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(name)
+		return ip != nil && cidr.Contains(ip)
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+// SANs returns every subject alternative name requested by csr
+func SANs(csr *x509.CertificateRequest) []string {
+	var names []string
+	names = append(names, csr.DNSNames...)
+	names = append(names, csr.EmailAddresses...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// requestedCA returns the basic constraints requested by the CSR, if any
+func requestedCA(csr *x509.CertificateRequest) (*basicConstraints, error) {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidBasicConstraints) {
+			continue
+		}
+		bc := &basicConstraints{MaxPathLen: -1}
+		if _, err := asn1.Unmarshal(ext.Value, bc); err != nil {
+			return nil, fmt.Errorf("invalid basic constraints in CSR: %v", err)
+		}
+		return bc, nil
+	}
+	return nil, nil
+}
+
+// Check returns an error wrapping ErrProfileViolation when the profile does not
+// allow issuer to sign csr, without signing it
+func (p *Profile) Check(csr *x509.CertificateRequest, issuer *x509.Certificate) error {
+	return p.apply(csr, &x509.Certificate{}, issuer)
+}
+
+// apply checks the CSR against the profile and sets the usages and constraints
+// of template. It returns an error wrapping ErrProfileViolation when the CSR
+// asks for names or capabilities the profile does not allow.
+func (p *Profile) apply(csr *x509.CertificateRequest, template, issuer *x509.Certificate) error {
+	for _, name := range SANs(csr) {
+		if !p.sanAllowed(name) {
+			return fmt.Errorf("%w: subject alternative name %s is not allowed", ErrProfileViolation, name)
+		}
+	}
+
+	ku, eku, err := p.usages()
+	if err != nil {
+		return err
+	}
+	if !isRSA(csr.PublicKey) {
+		ku &^= x509.KeyUsageKeyEncipherment
+	}
+	template.KeyUsage = ku
+	template.ExtKeyUsage = eku
+
+	bc, err := requestedCA(csr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProfileViolation, err)
+	}
+	if bc == nil || !bc.IsCA {
+		return nil
+	}
+	if !p.AllowCA {
+		return fmt.Errorf("%w: CA certificates may not be requested", ErrProfileViolation)
+	}
+	if !CanIssueIntermediate(issuer) {
+		// the issuer has a path length of zero, a CA signed by it would never validate
+		return fmt.Errorf("%w: issuer path length does not allow CA certificates", ErrProfileViolation)
+	}
+
+	template.IsCA = true
+	template.MaxPathLen = p.MaxPathLen
+	if bc.MaxPathLen >= 0 && bc.MaxPathLen < template.MaxPathLen {
+		template.MaxPathLen = bc.MaxPathLen
+	}
+	if issuer.MaxPathLen > 0 && template.MaxPathLen >= issuer.MaxPathLen {
+		template.MaxPathLen = issuer.MaxPathLen - 1
+	}
+	template.MaxPathLenZero = template.MaxPathLen == 0
+	return nil
+}
@@ -0,0 +1,192 @@
+package gen
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func profileTestCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	caKey, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	config := MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.",
+		nil, []string{"security@mesosphere.com"}, true)
+	caDer, err := GenerateCertificate(config, nil, caKey)
+	if err != nil {
+		t.Fatalf("root certificate generation failed: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+	return caCert, caKey
+}
+
+func profileTestCSR(t *testing.T, config CSRConfig) *x509.CertificateRequest {
+	key, _ := GenerateKey(KeyTypeECDSAP256, 0)
+	csrBytes, err := GenerateCSR(config, key)
+	if err != nil {
+		t.Fatalf("error generating csr: %v", err)
+	}
+	csr, _ := x509.ParseCertificateRequest(csrBytes)
+	return csr
+}
+
+func TestDefaultProfiles(t *testing.T) {
+	profiles := DefaultProfiles()
+	if err := profiles.Validate(); err != nil {
+		t.Fatalf("built-in profiles are invalid: %v", err)
+	}
+	caCert, caKey := profileTestCA(t)
+	csr := profileTestCSR(t, MakeCSRConfig(
+		"client", "US", "TX", "San Antonio", "Mesosphere Inc.", []string{"localhost"}, nil))
+
+	tests := map[string][]x509.ExtKeyUsage{
+		ProfileServer:      {x509.ExtKeyUsageServerAuth},
+		ProfileClient:      {x509.ExtKeyUsageClientAuth},
+		ProfilePeer:        {x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ProfileCodeSigning: {x509.ExtKeyUsageCodeSigning},
+	}
+	for name, expected := range tests {
+		profile, err := profiles.Get(name)
+		if err != nil {
+			t.Fatalf("missing profile %s: %v", name, err)
+		}
+		signed, err := SignWithOptions(csr, caCert, caKey, SignOptions{Profile: profile})
+		if err != nil {
+			t.Fatalf("error signing with profile %s: %v", name, err)
+		}
+		cert, _ := x509.ParseCertificate(signed)
+		if len(cert.ExtKeyUsage) != len(expected) {
+			t.Fatalf("profile %s: unexpected extended key usage %v", name, cert.ExtKeyUsage)
+		}
+		for i := range expected {
+			if cert.ExtKeyUsage[i] != expected[i] {
+				t.Fatalf("profile %s: unexpected extended key usage %v", name, cert.ExtKeyUsage)
+			}
+		}
+		if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+			t.Fatalf("profile %s: ECDSA certificate has key encipherment usage", name)
+		}
+		if cert.IsCA {
+			t.Fatalf("profile %s: certificate is a CA", name)
+		}
+
+		// the root carries no extended key usage that would exclude the leaf's
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: expected}); err != nil {
+			t.Fatalf("profile %s: certificate does not verify for %v: %v", name, expected, err)
+		}
+	}
+
+	if _, err := profiles.Get("unknown"); err == nil {
+		t.Fatalf("unknown profile was returned")
+	}
+}
+
+func TestProfileRestrictions(t *testing.T) {
+	caCert, caKey := profileTestCA(t)
+	profile := &Profile{
+		ExtKeyUsage: []string{"server_auth"},
+		MaxValidity: time.Hour,
+		AllowedSANs: []string{"*.mesos", "10.0.0.0/8"},
+	}
+
+	allowed := profileTestCSR(t, MakeCSRConfig(
+		"master", "US", "TX", "San Antonio", "Mesosphere Inc.", []string{"master.mesos", "10.0.0.1"}, nil))
+	signed, err := SignWithOptions(allowed, caCert, caKey, SignOptions{Profile: profile})
+	if err != nil {
+		t.Fatalf("error signing allowed names: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(signed)
+	if d := cert.NotAfter.Sub(cert.NotBefore); d != time.Hour {
+		t.Fatalf("profile maximum validity was not applied: %s", d)
+	}
+
+	for _, sans := range [][]string{{"example.com"}, {"192.168.0.1"}} {
+		denied := profileTestCSR(t, MakeCSRConfig(
+			"master", "US", "TX", "San Antonio", "Mesosphere Inc.", sans, nil))
+		_, err := SignWithOptions(denied, caCert, caKey, SignOptions{Profile: profile})
+		if !errors.Is(err, ErrProfileViolation) {
+			t.Fatalf("%v was not rejected: %v", sans, err)
+		}
+	}
+}
+
+func TestProfileCARequest(t *testing.T) {
+	caCert, caKey := profileTestCA(t)
+	profiles := DefaultProfiles()
+	if ca := profiles.CANames(); len(ca) != 0 {
+		t.Fatalf("built-in profiles %v allow CA certificates", ca)
+	}
+
+	config := MakeCSRConfig("sub-ca", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil)
+	config.RequestCA(-1)
+	csr := profileTestCSR(t, config)
+
+	for _, name := range profiles.Names() {
+		p, _ := profiles.Get(name)
+		if _, err := SignWithOptions(csr, caCert, caKey, SignOptions{Profile: p}); !errors.Is(err, ErrProfileViolation) {
+			t.Fatalf("%s profile issued a CA certificate: %v", name, err)
+		}
+	}
+
+	caProfile := &Profile{KeyUsage: []string{"digital_signature", "cert_sign", "crl_sign"}, AllowCA: true}
+	signed, err := SignWithOptions(csr, caCert, caKey, SignOptions{Profile: caProfile})
+	if err != nil {
+		t.Fatalf("error signing CA request: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(signed)
+	if !cert.IsCA || !cert.MaxPathLenZero || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Fatalf("CA profile produced an invalid CA: IsCA=%v MaxPathLen=%d KeyUsage=%v",
+			cert.IsCA, cert.MaxPathLen, cert.KeyUsage)
+	}
+
+	// the signed CA has a path length of zero, a CA below it would never validate
+	if _, err := SignWithOptions(csr, cert, caKey, SignOptions{Profile: caProfile}); !errors.Is(err, ErrProfileViolation) {
+		t.Fatalf("issuer with a path length of zero issued a CA certificate: %v", err)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	_ = InitStorage(testStorePath)
+	p := StorePath("profiles.yaml")
+	_ = afero.WriteFile(AppFs, p, []byte(`
+default: server
+profiles:
+  server:
+    key_usage: [digital_signature]
+    ext_key_usage: [server_auth]
+    max_validity: 720h
+    allowed_sans: ["*.mesos"]
+  zookeeper:
+    ext_key_usage: [server_auth, client_auth]
+`), 0644)
+
+	profiles, err := LoadProfiles(p)
+	if err != nil {
+		t.Fatalf("error loading profiles: %v", err)
+	}
+	server, err := profiles.Get("")
+	if err != nil || server.MaxValidity != 720*time.Hour || len(server.AllowedSANs) != 1 {
+		t.Fatalf("default profile was not loaded: %+v (%v)", server, err)
+	}
+	if _, err := profiles.Get("zookeeper"); err != nil {
+		t.Fatalf("custom profile was not loaded: %v", err)
+	}
+	if _, err := profiles.Get(ProfileClient); err != nil {
+		t.Fatalf("built-in profile was dropped: %v", err)
+	}
+
+	_ = afero.WriteFile(AppFs, p, []byte(`{"profiles": {"bad": {"key_usage": ["launch_missiles"]}}}`), 0644)
+	if _, err := LoadProfiles(p); err == nil {
+		t.Fatalf("profile with unknown key usage was accepted")
+	}
+	_ = afero.WriteFile(AppFs, p, []byte("profiles:\n  server:\n    ext_key_usages: [client_auth]\n"), 0644)
+	if _, err := LoadProfiles(p); err == nil {
+		t.Fatalf("profile with a misspelled field was accepted")
+	}
+}
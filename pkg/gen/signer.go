@@ -0,0 +1,56 @@
+// Backends holding the private key of a CA
+
+package gen
+
+import (
+	"crypto"
+)
+
+// SignerBackend creates and holds the private key of a CA. Keys may live
+// outside of the process, e.g. in a PKCS#11 token, in which case the returned
+// signer forwards signing operations to the backend.
+type SignerBackend interface {
+	// GenerateKey creates the key of the backend, see GenerateKey for the
+	// supported types
+	GenerateKey(keyType string, bits int) (crypto.Signer, error)
+	// LoadKey returns the existing key of the backend
+	LoadKey() (crypto.Signer, error)
+	// Close releases the backend. Signers returned by it can not be used afterwards.
+	Close() error
+}
+
+// FileSigner keeps the key in a PEM file of the store, see WritePrivateKey
+type FileSigner struct {
+	Path string
+}
+
+// GenerateKey creates a key and writes it to Path
+func (f FileSigner) GenerateKey(keyType string, bits int) (crypto.Signer, error) {
+	key, err := GenerateKey(keyType, bits)
+	if err != nil {
+		return nil, err
+	}
+	if err := WritePrivateKey(f.Path, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadKey reads the key at Path
+func (f FileSigner) LoadKey() (crypto.Signer, error) {
+	return ReadPrivateKey(f.Path)
+}
+
+// Close does nothing, the key is held in memory
+func (f FileSigner) Close() error {
+	return nil
+}
+
+// PKCS11Config selects the key pair labelled Label in the token in Slot of the
+// PKCS#11 Module, e.g. /usr/lib/softhsm/libsofthsm2.so, logging in with PIN
+type PKCS11Config struct {
+	Module string
+	Slot   uint
+	Label  string
+	PIN    string
+}
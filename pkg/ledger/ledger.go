@@ -0,0 +1,410 @@
+// Issuance ledger, an append-only record of every certificate the CA signed
+
+package ledger
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+// Entry types written to the ledger file
+const (
+	entryIssued  = "issued"
+	entryRevoked = "revoked"
+)
+
+// ReasonCodes maps the RFC 5280 CRL reason names to their codes
+var ReasonCodes = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// ErrNotFound is returned when a serial number is not in the ledger
+var ErrNotFound = errors.New("no certificate with serial number in ledger")
+
+// Record describes a single certificate issued by the CA
+type Record struct {
+	Serial         string    `json:"serial"`
+	Subject        string    `json:"subject"`
+	CommonName     string    `json:"common_name"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	Requester      string    `json:"requester"`
+	CSRFingerprint string    `json:"csr_fingerprint"`
+	IssuedAt       time.Time `json:"issued_at"`
+	Profile        string    `json:"profile,omitempty"` // certificate profile, empty in records from older versions
+
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason int        `json:"revocation_reason,omitempty"`
+}
+
+// Revoked reports whether the certificate has been revoked
+func (r Record) Revoked() bool {
+	return r.RevokedAt != nil
+}
+
+// SANs returns every subject alternative name in the record
+func (r Record) SANs() []string {
+	var sans []string
+	sans = append(sans, r.DNSNames...)
+	sans = append(sans, r.IPAddresses...)
+	return append(sans, r.EmailAddresses...)
+}
+
+// entry is a single line in the ledger file
+type entry struct {
+	Type string `json:"type"`
+	Record
+}
+
+// lockTimeout bounds how long a ledger operation waits for another process
+const lockTimeout = 5 * time.Second
+
+// Ledger persists issuance records as JSON lines in the store. Entries are only
+// ever appended, under a lock file shared by the CLI and a running server.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New returns a Ledger backed by filePath
+func New(filePath string) *Ledger {
+	return &Ledger{path: filePath}
+}
+
+// Open returns the Ledger kept in the store initialized by gen.InitStorage
+func Open() *Ledger {
+	return New(gen.StorePath(gen.LedgerFile))
+}
+
+// SerialString formats a certificate serial number the way the ledger stores it
+func SerialString(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", cert.SerialNumber)
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of der
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRecord describes cert, issued for csr on behalf of requester
+func NewRecord(cert *x509.Certificate, csr *x509.CertificateRequest, requester string) Record {
+	r := Record{
+		Serial:         SerialString(cert),
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotBefore:      cert.NotBefore.UTC(),
+		NotAfter:       cert.NotAfter.UTC(),
+		Requester:      requester,
+		IssuedAt:       time.Now().UTC(),
+	}
+	if host, _, err := net.SplitHostPort(requester); err == nil {
+		r.Requester = host
+	}
+	for _, ip := range cert.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	if csr != nil {
+		r.CSRFingerprint = Fingerprint(csr.Raw)
+	}
+	return r
+}
+
+// lock serializes access with other processes through a lock file next to the
+// ledger, see gen.LockFile. The returned function releases the lock.
+func (l *Ledger) lock() (func(), error) {
+	l.mu.Lock()
+	f, err := gen.AppFs.OpenFile(l.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err == nil {
+		if err = gen.LockFile(f, lockTimeout); err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("error locking %s : %v", l.path, err)
+	}
+	return func() {
+		// closing the file releases the lock
+		f.Close()
+		l.mu.Unlock()
+	}, nil
+}
+
+// append writes e to the ledger, the caller holds the lock
+func (l *Ledger) append(e interface{}) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := gen.AppFs.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Record appends an issuance record to the ledger
+func (l *Ledger) Record(r Record) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return l.append(entry{Type: entryIssued, Record: r})
+}
+
+// Records returns every issuance record, oldest first. A missing ledger file is
+// treated as an empty ledger.
+func (l *Ledger) Records() ([]Record, error) {
+	unlock, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return l.records()
+}
+
+// records reads the ledger, the caller holds the lock
+func (l *Ledger) records() ([]Record, error) {
+	f, err := gen.AppFs.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bySerial := map[string]*Record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		e := entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", l.path, line, err)
+		}
+		switch e.Type {
+		case entryIssued:
+			r := e.Record
+			bySerial[r.Serial] = &r
+		case entryRevoked:
+			r, ok := bySerial[e.Serial]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: revocation of unknown serial %s", l.path, line, e.Serial)
+			}
+			r.RevokedAt = e.RevokedAt
+			r.RevocationReason = e.RevocationReason
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown entry type %q", l.path, line, e.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(bySerial))
+	for _, r := range bySerial {
+		records = append(records, *r)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].IssuedAt.Before(records[j].IssuedAt)
+	})
+	return records, nil
+}
+
+// Find returns the record for serial, a hex encoded serial number
+func (l *Ledger) Find(serial string) (*Record, error) {
+	records, err := l.Records()
+	if err != nil {
+		return nil, err
+	}
+	return find(records, serial)
+}
+
+func find(records []Record, serial string) (*Record, error) {
+	serial = normalizeSerial(serial)
+	for i := range records {
+		if records[i].Serial == serial {
+			return &records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, serial)
+}
+
+// Revoke marks the certificate with the given serial as revoked for reason, one
+// of the values in ReasonCodes
+func (l *Ledger) Revoke(serial string, reason int) (*Record, error) {
+	// the check and the revocation happen under the lock so a certificate is
+	// revoked once when the CLI and the server revoke it at the same time
+	unlock, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	records, err := l.records()
+	if err != nil {
+		return nil, err
+	}
+	r, err := find(records, serial)
+	if err != nil {
+		return nil, err
+	}
+	if r.Revoked() {
+		return nil, fmt.Errorf("certificate %s was already revoked at %s", r.Serial, r.RevokedAt)
+	}
+
+	now := time.Now().UTC()
+	r.RevokedAt = &now
+	r.RevocationReason = reason
+	err = l.append(entry{
+		Type: entryRevoked,
+		Record: Record{
+			Serial:           r.Serial,
+			RevokedAt:        r.RevokedAt,
+			RevocationReason: reason,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Revoked returns every revoked record
+func (l *Ledger) Revoked() ([]Record, error) {
+	records, err := l.Records()
+	if err != nil {
+		return nil, err
+	}
+	var revoked []Record
+	for _, r := range records {
+		if r.Revoked() {
+			revoked = append(revoked, r)
+		}
+	}
+	return revoked, nil
+}
+
+// ModTime returns the last time the ledger was written, the zero time if the
+// ledger does not exist yet
+func (l *Ledger) ModTime() (time.Time, error) {
+	fi, err := gen.AppFs.Stat(l.path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// RevocationEntries converts revoked records into CRL entries
+func RevocationEntries(records []Record) ([]x509.RevocationListEntry, error) {
+	var entries []x509.RevocationListEntry
+	for _, r := range records {
+		if !r.Revoked() {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(r.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number in ledger: %s", r.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: *r.RevokedAt,
+			ReasonCode:     r.RevocationReason,
+		})
+	}
+	return entries, nil
+}
+
+func normalizeSerial(serial string) string {
+	serial = strings.ToLower(strings.Replace(serial, ":", "", -1))
+	serial = strings.TrimPrefix(serial, "0x")
+	if trimmed := strings.TrimLeft(serial, "0"); trimmed != "" {
+		return trimmed
+	}
+	return serial
+}
+
+// Filter selects records from the ledger. Empty fields match everything.
+type Filter struct {
+	CommonName    string        // glob matched against the common name
+	SAN           string        // glob matched against any subject alternative name
+	ExpiresWithin time.Duration // only records expiring within this window, including expired ones
+}
+
+// Match reports whether r satisfies the filter at time now
+func (f Filter) Match(r Record, now time.Time) bool {
+	if f.CommonName != "" && !glob(f.CommonName, r.CommonName) {
+		return false
+	}
+	if f.SAN != "" {
+		found := false
+		for _, san := range r.SANs() {
+			if glob(f.SAN, san) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.ExpiresWithin > 0 && r.NotAfter.After(now.Add(f.ExpiresWithin)) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the subset of records matching the filter
+func (f Filter) Apply(records []Record, now time.Time) []Record {
+	var matched []Record
+	for _, r := range records {
+		if f.Match(r, now) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+func glob(pattern, name string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return err == nil && ok
+}
@@ -0,0 +1,193 @@
+package ledger
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+)
+
+const testStorePath = "/test/.pki"
+
+func testLedger(t *testing.T) *Ledger {
+	gen.AppFs = afero.NewMemMapFs()
+	if err := gen.InitStorage(testStorePath); err != nil {
+		t.Fatalf("error creating storage directory: %v", err)
+	}
+	return Open()
+}
+
+func testCertificate(serial int64, cn string, notAfter time.Time, sans ...string) *x509.Certificate {
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     notAfter,
+	}
+	for _, s := range sans {
+		if ip := net.ParseIP(s); ip != nil {
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		} else {
+			cert.DNSNames = append(cert.DNSNames, s)
+		}
+	}
+	return cert
+}
+
+func TestRecordAndFind(t *testing.T) {
+	l := testLedger(t)
+
+	if records, err := l.Records(); err != nil || len(records) != 0 {
+		t.Fatalf("expected an empty ledger, got %v (%v)", records, err)
+	}
+
+	cert := testCertificate(0xabc, "master-1", time.Now().Add(time.Hour), "master-1.mesos", "10.0.0.1")
+	csr := &x509.CertificateRequest{Raw: []byte("csr")}
+	if err := l.Record(NewRecord(cert, csr, "10.0.0.1:51234")); err != nil {
+		t.Fatalf("error recording certificate: %v", err)
+	}
+
+	r, err := l.Find("0A:BC")
+	if err != nil {
+		t.Fatalf("error finding certificate: %v", err)
+	}
+	if r.CommonName != "master-1" || r.Requester != "10.0.0.1" {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+	if r.CSRFingerprint != Fingerprint([]byte("csr")) {
+		t.Fatalf("CSR fingerprint is incorrect: %s", r.CSRFingerprint)
+	}
+	if len(r.IPAddresses) != 1 || r.IPAddresses[0] != "10.0.0.1" {
+		t.Fatalf("IP addresses are incorrect: %v", r.IPAddresses)
+	}
+
+	fileInfo, err := gen.AppFs.Stat(gen.StorePath(gen.LedgerFile))
+	if err != nil {
+		t.Fatalf("could not stat ledger: %v", err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Fatalf("ledger has incorrect permissions: %s", fileInfo.Mode())
+	}
+}
+
+func TestFilter(t *testing.T) {
+	l := testLedger(t)
+	now := time.Now()
+
+	certs := []*x509.Certificate{
+		testCertificate(1, "master-1", now.Add(time.Hour), "master-1.mesos"),
+		testCertificate(2, "master-2", now.Add(48*time.Hour), "master-2.mesos"),
+		testCertificate(3, "agent-1", now.Add(time.Hour), "agent-1.mesos", "10.0.0.3"),
+	}
+	for _, c := range certs {
+		if err := l.Record(NewRecord(c, nil, "127.0.0.1")); err != nil {
+			t.Fatalf("error recording certificate: %v", err)
+		}
+	}
+	records, err := l.Records()
+	if err != nil {
+		t.Fatalf("error reading ledger: %v", err)
+	}
+
+	tests := []struct {
+		filter   Filter
+		expected int
+	}{
+		{Filter{}, 3},
+		{Filter{CommonName: "master-*"}, 2},
+		{Filter{SAN: "*.mesos"}, 3},
+		{Filter{SAN: "10.0.0.*"}, 1},
+		{Filter{ExpiresWithin: 2 * time.Hour}, 2},
+		{Filter{CommonName: "master-*", ExpiresWithin: 2 * time.Hour}, 1},
+	}
+	for _, test := range tests {
+		if n := len(test.filter.Apply(records, now)); n != test.expected {
+			t.Errorf("%+v matched %d records, expected %d", test.filter, n, test.expected)
+		}
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	l := testLedger(t)
+
+	cert := testCertificate(0x1f, "agent-1", time.Now().Add(time.Hour))
+	if err := l.Record(NewRecord(cert, nil, "127.0.0.1")); err != nil {
+		t.Fatalf("error recording certificate: %v", err)
+	}
+	if err := l.Record(NewRecord(testCertificate(0x20, "agent-2", time.Now()), nil, "127.0.0.1")); err != nil {
+		t.Fatalf("error recording certificate: %v", err)
+	}
+
+	if _, err := l.Revoke("ff", ReasonCodes["keyCompromise"]); err == nil {
+		t.Fatalf("revoked a certificate missing from the ledger")
+	}
+	if _, err := l.Revoke("1f", ReasonCodes["keyCompromise"]); err != nil {
+		t.Fatalf("error revoking certificate: %v", err)
+	}
+	if _, err := l.Revoke("1f", ReasonCodes["superseded"]); err == nil {
+		t.Fatalf("revoked a certificate twice")
+	}
+
+	revoked, err := l.Revoked()
+	if err != nil {
+		t.Fatalf("error reading revoked certificates: %v", err)
+	}
+	if len(revoked) != 1 || revoked[0].RevocationReason != 1 {
+		t.Fatalf("unexpected revoked certificates: %+v", revoked)
+	}
+
+	entries, err := RevocationEntries(revoked)
+	if err != nil {
+		t.Fatalf("error creating CRL entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("unexpected CRL entries: %+v", entries)
+	}
+}
+
+func TestConcurrentLedgers(t *testing.T) {
+	gen.AppFs = afero.NewOsFs()
+	defer func() { gen.AppFs = afero.NewMemMapFs() }()
+	_ = gen.InitStorage(t.TempDir())
+
+	// ledgers of the CLI and the server only share the lock file
+	ledgers := []*Ledger{Open(), Open()}
+	cert := testCertificate(0x1f, "agent-1", time.Now().Add(time.Hour))
+	if err := ledgers[0].Record(NewRecord(cert, nil, "127.0.0.1")); err != nil {
+		t.Fatalf("error recording certificate: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	revoked := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(l *Ledger, serial int64) {
+			defer wg.Done()
+			if err := l.Record(NewRecord(testCertificate(serial, "agent", time.Now()), nil, "127.0.0.1")); err != nil {
+				t.Errorf("error recording certificate: %v", err)
+			}
+		}(ledgers[i%2], int64(0x100+i))
+		go func(l *Ledger) {
+			defer wg.Done()
+			if _, err := l.Revoke("1f", ReasonCodes["keyCompromise"]); err == nil {
+				mu.Lock()
+				revoked++
+				mu.Unlock()
+			}
+		}(ledgers[i%2])
+	}
+	wg.Wait()
+	if revoked != 1 {
+		t.Fatalf("certificate was revoked %d times by two ledgers", revoked)
+	}
+	if records, err := ledgers[1].Records(); err != nil || len(records) != 21 {
+		t.Fatalf("expected 21 records, got %d: %v", len(records), err)
+	}
+}
@@ -58,9 +58,34 @@ func entityPaths(entity string) (string, string) {
 	return gen.StorePath(entity + "-key.pem"), gen.StorePath(entity + "-cert.pem")
 }
 
+// readEntityChain returns the entity certificate chain, leaf first. Entities signed
+// before chains were issued only have a certificate, which is returned on its own.
+func readEntityChain(entity string) ([][]byte, error) {
+	_, certPem := entityPaths(entity)
+	chainPem := gen.StorePath(entity + "-chain.pem")
+
+	exists, err := afero.Exists(gen.AppFs, chainPem)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		chain, err := gen.ReadCertificateChainPEM(chainPem)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s : %v", chainPem, err)
+		}
+		return chain, nil
+	}
+
+	cert, err := gen.ReadCertificatePEM(certPem)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s : %v", certPem, err)
+	}
+	return [][]byte{cert}, nil
+}
+
 func writeEntityStore(alias, entity, ksPath, password string) error {
 	ks := keystore.KeyStore{}
-	keyPem, certPem := entityPaths(entity)
+	keyPem, _ := entityPaths(entity)
 	pkcs1Key, err := gen.ReadPrivateKey(keyPem)
 	if err != nil {
 		return fmt.Errorf("error reading %s : %v", keyPem, err)
@@ -72,22 +97,25 @@ func writeEntityStore(alias, entity, ksPath, password string) error {
 		return fmt.Errorf("error marshelling PKCS private key : %v", err)
 	}
 
-	cert, err := gen.ReadCertificatePEM(certPem)
+	chain, err := readEntityChain(entity)
 	if err != nil {
-		return fmt.Errorf("error reading %s : %v", certPem, err)
+		return err
+	}
+
+	certChain := make([]keystore.Certificate, 0, len(chain))
+	for _, c := range chain {
+		certChain = append(certChain, keystore.Certificate{
+			Type:    "X509",
+			Content: c,
+		})
 	}
 
 	ks[alias] = &keystore.PrivateKeyEntry{
 		Entry: keystore.Entry{
 			CreationDate: time.Now(),
 		},
-		PrivKey: key,
-		CertChain: []keystore.Certificate{
-			{
-				Type:    "X509",
-				Content: cert,
-			},
-		},
+		PrivKey:   key,
+		CertChain: certChain,
 	}
 
 	return writeKeyStore(ks, ksPath, password)
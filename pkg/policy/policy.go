@@ -0,0 +1,229 @@
+// Signing policy restricting which CSRs the CA service will sign
+
+package policy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// Scope of the rules defined at the top level of a policy
+const GlobalScope = "global"
+
+// Credentials a request may authenticate with, which scope the rules of a policy
+const (
+	CredentialPSK         = "psk"
+	CredentialToken       = "token"
+	CredentialCertificate = "certificate"
+	CredentialACME        = "acme"
+)
+
+var credentials = map[string]bool{
+	CredentialPSK:         true,
+	CredentialToken:       true,
+	CredentialCertificate: true,
+	CredentialACME:        true,
+}
+
+// Subject fields that can be required by a policy
+var subjectFields = map[string]func(csr *x509.CertificateRequest) []string{
+	"common_name":         func(csr *x509.CertificateRequest) []string { return []string{csr.Subject.CommonName} },
+	"organization":        func(csr *x509.CertificateRequest) []string { return csr.Subject.Organization },
+	"organizational_unit": func(csr *x509.CertificateRequest) []string { return csr.Subject.OrganizationalUnit },
+	"country":             func(csr *x509.CertificateRequest) []string { return csr.Subject.Country },
+	"province":            func(csr *x509.CertificateRequest) []string { return csr.Subject.Province },
+	"locality":            func(csr *x509.CertificateRequest) []string { return csr.Subject.Locality },
+}
+
+var keyAlgorithms = map[string]x509.PublicKeyAlgorithm{
+	"rsa":     x509.RSA,
+	"ecdsa":   x509.ECDSA,
+	"ed25519": x509.Ed25519,
+}
+
+// Rules restrict the CSRs that may be signed. Empty fields impose no restriction.
+type Rules struct {
+	AllowCN         []string `yaml:"allow_cn"`         // globs, the common name must match one
+	DenyCN          []string `yaml:"deny_cn"`          // globs, the common name must match none
+	AllowSAN        []string `yaml:"allow_san"`        // globs or CIDRs, every SAN must match one
+	DenySAN         []string `yaml:"deny_san"`         // globs or CIDRs, no SAN may match
+	RequiredSubject []string `yaml:"required_subject"` // subject fields that must be present
+	KeyAlgorithms   []string `yaml:"key_algorithms"`   // rsa, ecdsa or ed25519
+	MinRSABits      int      `yaml:"min_rsa_bits"`
+	MinECDSABits    int      `yaml:"min_ecdsa_bits"`
+}
+
+// Policy holds global rules, applied to every request, and rules scoped to
+// the credential a request authenticated with: psk, token, certificate or acme
+type Policy struct {
+	Rules       `yaml:",inline"`
+	Credentials map[string]Rules `yaml:"credentials"`
+}
+
+// Violation describes the rule a CSR failed. It is returned to clients as JSON.
+type Violation struct {
+	Scope   string `json:"scope"`
+	Rule    string `json:"rule"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("policy violation: %s.%s: %s", v.Scope, v.Rule, v.Message)
+}
+
+// Load reads a YAML or JSON policy file
+func Load(filePath string) (*Policy, error) {
+	data, err := afero.ReadFile(gen.AppFs, filePath)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	// a misspelled rule would silently allow what it was meant to deny
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing %s : %v", filePath, err)
+	}
+	return p, p.Validate()
+}
+
+// Validate checks the policy for unknown credentials, subject fields and key
+// algorithms and for malformed patterns. Unknown fields are refused by Load.
+func (p *Policy) Validate() error {
+	if err := p.Rules.validate(); err != nil {
+		return fmt.Errorf("%s: %v", GlobalScope, err)
+	}
+	for name, rules := range p.Credentials {
+		if !credentials[name] {
+			return fmt.Errorf("credentials.%s: unknown credential, must be %s, %s, %s or %s", name,
+				CredentialPSK, CredentialToken, CredentialCertificate, CredentialACME)
+		}
+		if err := rules.validate(); err != nil {
+			return fmt.Errorf("credentials.%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (r Rules) validate() error {
+	for _, patterns := range [][]string{r.AllowCN, r.DenyCN, r.AllowSAN, r.DenySAN} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+	for _, field := range r.RequiredSubject {
+		if _, ok := subjectFields[field]; !ok {
+			return fmt.Errorf("unknown subject field %q", field)
+		}
+	}
+	for _, alg := range r.KeyAlgorithms {
+		if _, ok := keyAlgorithms[alg]; !ok {
+			return fmt.Errorf("unknown key algorithm %q", alg)
+		}
+	}
+	return nil
+}
+
+// Check evaluates csr, sent by a client authenticated as credential, against the
+// global rules and the rules scoped to credential. A nil policy allows everything.
+// The first failed rule is returned as a *Violation.
+func (p *Policy) Check(csr *x509.CertificateRequest, credential string) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.Rules.check(GlobalScope, csr); err != nil {
+		return err
+	}
+	if rules, ok := p.Credentials[credential]; ok {
+		return rules.check("credentials."+credential, csr)
+	}
+	return nil
+}
+
+// present reports whether a subject field has at least one non-empty value
+func present(values []string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if gen.MatchSAN(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rules) check(scope string, csr *x509.CertificateRequest) error {
+	violation := func(rule, value, format string, args ...interface{}) error {
+		return &Violation{Scope: scope, Rule: rule, Value: value, Message: fmt.Sprintf(format, args...)}
+	}
+
+	cn := csr.Subject.CommonName
+	if len(r.AllowCN) > 0 && !matchAny(r.AllowCN, cn) {
+		return violation("allow_cn", cn, "common name %q does not match any of %v", cn, r.AllowCN)
+	}
+	if matchAny(r.DenyCN, cn) {
+		return violation("deny_cn", cn, "common name %q is denied", cn)
+	}
+
+	for _, san := range gen.SANs(csr) {
+		if len(r.AllowSAN) > 0 && !matchAny(r.AllowSAN, san) {
+			return violation("allow_san", san, "subject alternative name %q does not match any of %v", san, r.AllowSAN)
+		}
+		if matchAny(r.DenySAN, san) {
+			return violation("deny_san", san, "subject alternative name %q is denied", san)
+		}
+	}
+
+	for _, field := range r.RequiredSubject {
+		if !present(subjectFields[field](csr)) {
+			return violation("required_subject", field, "subject field %s is required", field)
+		}
+	}
+
+	if len(r.KeyAlgorithms) > 0 {
+		allowed := false
+		for _, alg := range r.KeyAlgorithms {
+			if keyAlgorithms[alg] == csr.PublicKeyAlgorithm {
+				allowed = true
+			}
+		}
+		if !allowed {
+			alg := strings.ToLower(csr.PublicKeyAlgorithm.String())
+			return violation("key_algorithms", alg, "key algorithm %s is not one of %v", alg, r.KeyAlgorithms)
+		}
+	}
+
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < r.MinRSABits {
+			return violation("min_rsa_bits", fmt.Sprint(bits), "RSA key has %d bits, at least %d are required",
+				bits, r.MinRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < r.MinECDSABits {
+			return violation("min_ecdsa_bits", fmt.Sprint(bits), "ECDSA key has %d bits, at least %d are required",
+				bits, r.MinECDSABits)
+		}
+	}
+	return nil
+}
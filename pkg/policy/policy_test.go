@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+)
+
+const testStorePath = "/test/.pki"
+
+const testPolicy = `
+allow_cn: ["master-*", "agent-*"]
+deny_san: ["*.example.com", "192.168.0.0/16"]
+required_subject: [organization]
+key_algorithms: [rsa, ecdsa]
+min_rsa_bits: 3072
+credentials:
+  psk:
+    allow_san: ["*.mesos", "10.0.0.0/8"]
+`
+
+func testCSR(t *testing.T, keyType string, bits int, cn, organization string, sans ...string) *x509.CertificateRequest {
+	key, err := gen.GenerateKey(keyType, bits)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	der, err := gen.GenerateCSR(gen.MakeCSRConfig(cn, "US", "CA", "San Francisco", organization, sans, nil), key)
+	if err != nil {
+		t.Fatalf("error generating csr: %v", err)
+	}
+	csr, _ := x509.ParseCertificateRequest(der)
+	return csr
+}
+
+func loadTestPolicy(t *testing.T) *Policy {
+	gen.AppFs = afero.NewMemMapFs()
+	_ = gen.InitStorage(testStorePath)
+	p := gen.StorePath("policy.yaml")
+	_ = afero.WriteFile(gen.AppFs, p, []byte(testPolicy), 0644)
+	policy, err := Load(p)
+	if err != nil {
+		t.Fatalf("error loading policy: %v", err)
+	}
+	return policy
+}
+
+func TestCheck(t *testing.T) {
+	p := loadTestPolicy(t)
+
+	tests := []struct {
+		csr        *x509.CertificateRequest
+		credential string
+		rule       string
+	}{
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "master-1", "Mesosphere", "master-1.mesos"), "psk", ""},
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "master-1", "Mesosphere", "master-1.other"), "token", ""},
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "root", "Mesosphere"), "psk", "allow_cn"},
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "agent-1", "Mesosphere", "www.example.com"), "token", "deny_san"},
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "agent-1", "Mesosphere", "192.168.1.1"), "token", "deny_san"},
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "agent-1", "", "agent-1.mesos"), "psk", "required_subject"},
+		{testCSR(t, gen.KeyTypeEd25519, 0, "agent-1", "Mesosphere"), "psk", "key_algorithms"},
+		{testCSR(t, gen.KeyTypeRSA, 2048, "agent-1", "Mesosphere"), "psk", "min_rsa_bits"},
+		{testCSR(t, gen.KeyTypeECDSAP256, 0, "agent-1", "Mesosphere", "agent-1.other"), "psk", "allow_san"},
+	}
+
+	for i, test := range tests {
+		err := p.Check(test.csr, test.credential)
+		if test.rule == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected violation: %v", i, err)
+			}
+			continue
+		}
+		var v *Violation
+		if !errors.As(err, &v) || v.Rule != test.rule {
+			t.Errorf("%d: expected %s violation, got %v", i, test.rule, err)
+		}
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.Check(tests[2].csr, "psk"); err != nil {
+		t.Fatalf("nil policy denied a request: %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	gen.AppFs = afero.NewMemMapFs()
+	_ = gen.InitStorage(testStorePath)
+	p := gen.StorePath("policy.json")
+
+	for _, invalid := range []string{
+		`{"required_subject": ["shoe_size"]}`,
+		`{"key_algorithms": ["dsa"]}`,
+		`{"credentials": {"psk": {"allow_cn": ["[invalid"]}}}`,
+		`{"credentials": {"bearer": {"allow_cn": ["master-*"]}}}`,
+		"deny-cn: [\"*\"]",
+		"credentials:\n  psk:\n    allow_sans: [\"*.mesos\"]",
+	} {
+		_ = afero.WriteFile(gen.AppFs, p, []byte(invalid), 0644)
+		if _, err := Load(p); err == nil {
+			t.Errorf("invalid policy was accepted: %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,894 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/token"
+)
+
+// ACME object states, RFC 8555 section 7.1.6
+const (
+	acmeStatusPending    = "pending"
+	acmeStatusProcessing = "processing"
+	acmeStatusReady      = "ready"
+	acmeStatusValid      = "valid"
+	acmeStatusInvalid    = "invalid"
+)
+
+// ACME problem types, RFC 8555 section 6.7
+const (
+	acmeErrAccountDoesNotExist     = "urn:ietf:params:acme:error:accountDoesNotExist"
+	acmeErrBadCSR                  = "urn:ietf:params:acme:error:badCSR"
+	acmeErrBadNonce                = "urn:ietf:params:acme:error:badNonce"
+	acmeErrBadSignatureAlgorithm   = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	acmeErrExternalAccountRequired = "urn:ietf:params:acme:error:externalAccountRequired"
+	acmeErrIncorrectResponse       = "urn:ietf:params:acme:error:incorrectResponse"
+	acmeErrMalformed               = "urn:ietf:params:acme:error:malformed"
+	acmeErrOrderNotReady           = "urn:ietf:params:acme:error:orderNotReady"
+	acmeErrRateLimited             = "urn:ietf:params:acme:error:rateLimited"
+	acmeErrRejectedIdentifier      = "urn:ietf:params:acme:error:rejectedIdentifier"
+	acmeErrServerInternal          = "urn:ietf:params:acme:error:serverInternal"
+	acmeErrUnauthorized            = "urn:ietf:params:acme:error:unauthorized"
+	acmeErrUnsupportedIdentifier   = "urn:ietf:params:acme:error:unsupportedIdentifier"
+)
+
+const (
+	acmeChallengeHTTP01 = "http-01"
+	acmeHTTP01Path      = "/.well-known/acme-challenge/"
+	acmeHTTP01Timeout   = 10 * time.Second
+	acmeOrderLifetime   = 24 * time.Hour
+	acmeNonceLifetime   = time.Hour
+	acmeMaxNonces       = 10000
+	acmeMaxRequestBody  = 64 * 1024
+)
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeAccount struct {
+	id         string
+	key        crypto.PublicKey
+	thumbprint string
+	contact    []string
+	credential string   // EAB key ID the account was bound with, psk or a token ID
+	allowedCN  []string // globs identifiers must match, from the bootstrap token
+}
+
+type acmeChallenge struct {
+	id        string
+	authzID   string
+	token     string
+	status    string
+	validated *time.Time
+	err       *acmeProblem
+}
+
+type acmeAuthz struct {
+	id         string
+	accountID  string
+	identifier acmeIdentifier
+	status     string
+	expires    time.Time
+	challenges []*acmeChallenge
+}
+
+type acmeOrder struct {
+	id          string
+	accountID   string
+	status      string
+	expires     time.Time
+	identifiers []acmeIdentifier
+	authzIDs    []string
+	certificate []byte // PEM chain once issued
+	err         *acmeProblem
+}
+
+// acmeServer implements the subset of RFC 8555 needed to issue certificates:
+// accounts bound to the PSK or a bootstrap token through External Account
+// Binding, orders, authorizations, http-01 challenges and finalization.
+// Authorizations are granted by the account binding unless http-01 validation
+// is enabled. State is kept in memory and lost on restart.
+type acmeServer struct {
+	http01     bool
+	http01Port int
+	httpClient *http.Client
+
+	nonceMu sync.Mutex
+	nonces  map[string]time.Time
+
+	mu         sync.Mutex
+	accounts   map[string]*acmeAccount
+	byKey      map[string]*acmeAccount // accounts by JWK thumbprint
+	orders     map[string]*acmeOrder
+	authzs     map[string]*acmeAuthz
+	challenges map[string]*acmeChallenge
+}
+
+func newACMEServer(http01 bool, http01Port int) *acmeServer {
+	return &acmeServer{
+		http01:     http01,
+		http01Port: http01Port,
+		httpClient: &http.Client{Timeout: acmeHTTP01Timeout},
+		nonces:     map[string]time.Time{},
+		accounts:   map[string]*acmeAccount{},
+		byKey:      map[string]*acmeAccount{},
+		orders:     map[string]*acmeOrder{},
+		authzs:     map[string]*acmeAuthz{},
+		challenges: map[string]*acmeChallenge{},
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("error reading random bytes : %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// baseURL returns the URL of the ACME API as seen by the client
+func (a *acmeServer) baseURL(req *http.Request) string {
+	scheme := "https"
+	if req.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + req.Host + "/acme"
+}
+
+func (a *acmeServer) newNonce() string {
+	a.nonceMu.Lock()
+	defer a.nonceMu.Unlock()
+	now := time.Now()
+	for n, expires := range a.nonces {
+		if now.After(expires) || len(a.nonces) >= acmeMaxNonces {
+			delete(a.nonces, n)
+		}
+	}
+	nonce := randomID()
+	a.nonces[nonce] = now.Add(acmeNonceLifetime)
+	return nonce
+}
+
+// useNonce consumes nonce and reports whether it was issued and not yet used
+func (a *acmeServer) useNonce(nonce string) bool {
+	a.nonceMu.Lock()
+	defer a.nonceMu.Unlock()
+	expires, ok := a.nonces[nonce]
+	delete(a.nonces, nonce)
+	return ok && time.Now().Before(expires)
+}
+
+// respond writes v as JSON with a fresh nonce
+func (a *acmeServer) respond(w http.ResponseWriter, req *http.Request, status int, location string, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		a.problem(w, req, http.StatusInternalServerError, acmeErrServerInternal, err.Error())
+		return
+	}
+	w.Header().Set("Replay-Nonce", a.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.WriteHeader(status)
+	n, _ := w.Write(j)
+	logRequest(req, status, n)
+}
+
+// problem writes an RFC 7807 problem document
+func (a *acmeServer) problem(w http.ResponseWriter, req *http.Request, status int, problemType, detail string) {
+	log.Printf("[error] %s %s %s: %s", req.RemoteAddr, req.RequestURI, problemType, detail)
+	j, _ := json.Marshal(acmeProblem{Type: problemType, Detail: detail, Status: status})
+	w.Header().Set("Replay-Nonce", a.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	n, _ := w.Write(j)
+	logRequest(req, status, n)
+}
+
+// ServeHTTP routes requests below /acme/
+func (a *acmeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/acme/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "directory":
+		a.directory(w, req)
+	case len(parts) == 1 && parts[0] == "new-nonce":
+		a.nonce(w, req)
+	case len(parts) == 1 && parts[0] == "new-account":
+		a.newAccount(w, req)
+	case len(parts) == 1 && parts[0] == "new-order":
+		a.newOrder(w, req)
+	case len(parts) == 2 && parts[0] == "account":
+		a.account(w, req, parts[1])
+	case len(parts) == 2 && parts[0] == "order":
+		a.order(w, req, parts[1])
+	case len(parts) == 3 && parts[0] == "order" && parts[2] == "finalize":
+		a.finalize(w, req, parts[1])
+	case len(parts) == 2 && parts[0] == "authz":
+		a.authz(w, req, parts[1])
+	case len(parts) == 2 && parts[0] == "chall":
+		a.challenge(w, req, parts[1])
+	case len(parts) == 2 && parts[0] == "cert":
+		a.certificate(w, req, parts[1])
+	default:
+		a.problem(w, req, http.StatusNotFound, acmeErrMalformed, "unknown ACME resource")
+	}
+}
+
+func (a *acmeServer) directory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base := a.baseURL(req)
+	a.respond(w, req, http.StatusOK, "", map[string]interface{}{
+		"newNonce":   base + "/new-nonce",
+		"newAccount": base + "/new-account",
+		"newOrder":   base + "/new-order",
+		"meta": map[string]interface{}{
+			"externalAccountRequired": true,
+		},
+	})
+}
+
+func (a *acmeServer) nonce(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Replay-Nonce", a.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusNoContent
+	if req.Method == "HEAD" {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	logRequest(req, status, 0)
+}
+
+// acmeRequest is a verified JWS request
+type acmeRequest struct {
+	header  *jwsHeader
+	payload []byte
+	key     crypto.PublicKey
+	account *acmeAccount // nil for requests signed with a JWK
+}
+
+// verify authenticates an ACME POST request. Requests are signed with the key
+// of an existing account, identified by its URL, unless allowJWK is set.
+func (a *acmeServer) verify(w http.ResponseWriter, req *http.Request, allowJWK bool) (*acmeRequest, bool) {
+	if req.Method != "POST" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, acmeMaxRequestBody))
+	if err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, err.Error())
+		return nil, false
+	}
+	signed := &jws{}
+	if err := json.Unmarshal(body, signed); err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, "request is not a JWS: "+err.Error())
+		return nil, false
+	}
+	header, err := signed.header()
+	if err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, err.Error())
+		return nil, false
+	}
+	if !a.useNonce(header.Nonce) {
+		a.problem(w, req, http.StatusBadRequest, acmeErrBadNonce, "nonce is invalid or has been used")
+		return nil, false
+	}
+	if header.URL != a.baseURL(req)+strings.TrimPrefix(req.URL.Path, "/acme") {
+		a.problem(w, req, http.StatusUnauthorized, acmeErrUnauthorized, "JWS url does not match the request")
+		return nil, false
+	}
+
+	r := &acmeRequest{header: header}
+	switch {
+	case header.KID != "" && len(header.JWK) == 0:
+		id := strings.TrimPrefix(header.KID, a.baseURL(req)+"/account/")
+		a.mu.Lock()
+		r.account = a.accounts[id]
+		a.mu.Unlock()
+		if r.account == nil {
+			a.problem(w, req, http.StatusBadRequest, acmeErrAccountDoesNotExist, "unknown account "+header.KID)
+			return nil, false
+		}
+		r.key = r.account.key
+	case header.KID == "" && len(header.JWK) > 0 && allowJWK:
+		if r.key, err = parseJWK(header.JWK); err != nil {
+			a.problem(w, req, http.StatusBadRequest, acmeErrBadSignatureAlgorithm, err.Error())
+			return nil, false
+		}
+	default:
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, "JWS must be signed by an account key ID")
+		return nil, false
+	}
+
+	if err := signed.verify(header.Alg, r.key); err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, "JWS verification failed: "+err.Error())
+		return nil, false
+	}
+	if r.payload, err = signed.payload(); err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, "invalid payload encoding")
+		return nil, false
+	}
+	return r, true
+}
+
+func (a *acmeServer) accountJSON(acct *acmeAccount) interface{} {
+	return map[string]interface{}{
+		"status":  acmeStatusValid,
+		"contact": acct.contact,
+	}
+}
+
+// bindAccount verifies the external account binding of a new account with key
+// and returns the credential it was bound with and the names it may request
+func (a *acmeServer) bindAccount(req *http.Request, eab *jws, thumbprint string) (string, []string, error) {
+	header, err := eab.header()
+	if err != nil {
+		return "", nil, err
+	}
+	if header.Alg != "HS256" {
+		return "", nil, fmt.Errorf("unsupported external account binding algorithm %s", header.Alg)
+	}
+	if header.URL != a.baseURL(req)+"/new-account" {
+		return "", nil, errors.New("external account binding url does not match the request")
+	}
+	payload, err := eab.payload()
+	if err != nil {
+		return "", nil, err
+	}
+	boundKey, err := parseJWK(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	if bound, err := jwkThumbprint(boundKey); err != nil || bound != thumbprint {
+		return "", nil, errors.New("external account binding is for a different key")
+	}
+
+	if header.KID == credentialPSK {
+		psk := currentPSK()
+		if psk == "" {
+			if insecureNoAuth {
+				return credentialPSK, nil, nil
+			}
+			return "", nil, errors.New("PSK external account binding is not available")
+		}
+		return credentialPSK, nil, eab.verifyMAC([]byte(psk))
+	}
+
+	// each certificate of the account redeems a use of the token, see issueOrder
+	t, err := bootstrapTokens.CheckEAB(header.KID, eab.verifyMAC)
+	if err != nil {
+		return "", nil, err
+	}
+	return t.ID, t.AllowedCN, nil
+}
+
+func (a *acmeServer) newAccount(w http.ResponseWriter, req *http.Request) {
+	if wait := throttle.lockedOut(sourceIP(req), time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		a.problem(w, req, http.StatusTooManyRequests, acmeErrRateLimited, "too many failed authentications")
+		return
+	}
+	r, ok := a.verify(w, req, true)
+	if !ok {
+		return
+	}
+
+	payload := struct {
+		Contact                []string `json:"contact"`
+		OnlyReturnExisting     bool     `json:"onlyReturnExisting"`
+		ExternalAccountBinding *jws     `json:"externalAccountBinding"`
+	}{}
+	if err := json.Unmarshal(r.payload, &payload); err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, err.Error())
+		return
+	}
+
+	thumbprint, err := jwkThumbprint(r.key)
+	if err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrBadSignatureAlgorithm, err.Error())
+		return
+	}
+	a.mu.Lock()
+	existing := a.byKey[thumbprint]
+	a.mu.Unlock()
+	if existing != nil {
+		a.respond(w, req, http.StatusOK, a.baseURL(req)+"/account/"+existing.id, a.accountJSON(existing))
+		return
+	}
+	if payload.OnlyReturnExisting {
+		a.problem(w, req, http.StatusBadRequest, acmeErrAccountDoesNotExist, "no account for this key")
+		return
+	}
+	if payload.ExternalAccountBinding == nil {
+		a.problem(w, req, http.StatusUnauthorized, acmeErrExternalAccountRequired,
+			"accounts must be bound to the PSK or a bootstrap token")
+		return
+	}
+
+	credential, allowedCN, err := a.bindAccount(req, payload.ExternalAccountBinding, thumbprint)
+	if err != nil {
+		throttle.fail(sourceIP(req), time.Now())
+		a.problem(w, req, http.StatusUnauthorized, acmeErrUnauthorized, err.Error())
+		return
+	}
+	throttle.succeed(sourceIP(req))
+
+	acct := &acmeAccount{
+		id:         randomID(),
+		key:        r.key,
+		thumbprint: thumbprint,
+		contact:    payload.Contact,
+		credential: credential,
+		allowedCN:  allowedCN,
+	}
+	a.mu.Lock()
+	a.accounts[acct.id] = acct
+	a.byKey[thumbprint] = acct
+	a.mu.Unlock()
+
+	log.Printf("%s created ACME account %s bound to %s", req.RemoteAddr, acct.id, credential)
+	a.respond(w, req, http.StatusCreated, a.baseURL(req)+"/account/"+acct.id, a.accountJSON(acct))
+}
+
+func (a *acmeServer) account(w http.ResponseWriter, req *http.Request, id string) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+	if r.account.id != id {
+		a.problem(w, req, http.StatusUnauthorized, acmeErrUnauthorized, "account key does not match the account")
+		return
+	}
+	if len(r.payload) > 0 {
+		update := struct {
+			Contact []string `json:"contact"`
+		}{}
+		if err := json.Unmarshal(r.payload, &update); err != nil {
+			a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, err.Error())
+			return
+		}
+		if update.Contact != nil {
+			a.mu.Lock()
+			r.account.contact = update.Contact
+			a.mu.Unlock()
+		}
+	}
+	a.respond(w, req, http.StatusOK, a.baseURL(req)+"/account/"+id, a.accountJSON(r.account))
+}
+
+// orderJSON describes o, the caller holds a.mu
+func (a *acmeServer) orderJSON(req *http.Request, o *acmeOrder) interface{} {
+	base := a.baseURL(req)
+	var authzs []string
+	for _, id := range o.authzIDs {
+		authzs = append(authzs, base+"/authz/"+id)
+	}
+	v := map[string]interface{}{
+		"status":         o.status,
+		"expires":        o.expires.Format(time.RFC3339),
+		"identifiers":    o.identifiers,
+		"authorizations": authzs,
+		"finalize":       base + "/order/" + o.id + "/finalize",
+	}
+	if o.certificate != nil {
+		v["certificate"] = base + "/cert/" + o.id
+	}
+	if o.err != nil {
+		v["error"] = o.err
+	}
+	return v
+}
+
+// checkIdentifier returns a problem type and detail when id may not be ordered by acct
+func checkIdentifier(acct *acmeAccount, id acmeIdentifier) (string, string) {
+	switch id.Type {
+	case "dns":
+		if id.Value == "" || strings.Contains(id.Value, "*") {
+			return acmeErrRejectedIdentifier, "wildcard and empty DNS names are not supported"
+		}
+	case "ip":
+		if net.ParseIP(id.Value) == nil {
+			return acmeErrMalformed, "invalid IP address " + id.Value
+		}
+	default:
+		return acmeErrUnsupportedIdentifier, "unsupported identifier type " + id.Type
+	}
+	if !(token.Token{AllowedCN: acct.allowedCN}).Allows(id.Value) {
+		return acmeErrRejectedIdentifier, "the account binding does not allow " + id.Value
+	}
+	return "", ""
+}
+
+// pruneExpired drops expired orders and their authorizations, the caller holds a.mu
+func (a *acmeServer) pruneExpired(now time.Time) {
+	for id, o := range a.orders {
+		if now.Before(o.expires) {
+			continue
+		}
+		for _, authzID := range o.authzIDs {
+			if authz, ok := a.authzs[authzID]; ok {
+				for _, c := range authz.challenges {
+					delete(a.challenges, c.id)
+				}
+				delete(a.authzs, authzID)
+			}
+		}
+		delete(a.orders, id)
+	}
+}
+
+func (a *acmeServer) newOrder(w http.ResponseWriter, req *http.Request) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+	payload := struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}{}
+	if err := json.Unmarshal(r.payload, &payload); err != nil {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, err.Error())
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		a.problem(w, req, http.StatusBadRequest, acmeErrMalformed, "an order needs at least one identifier")
+		return
+	}
+	for i, id := range payload.Identifiers {
+		payload.Identifiers[i].Value = strings.ToLower(id.Value)
+		if problemType, detail := checkIdentifier(r.account, payload.Identifiers[i]); problemType != "" {
+			a.problem(w, req, http.StatusForbidden, problemType, detail)
+			return
+		}
+	}
+
+	now := time.Now()
+	o := &acmeOrder{
+		id:          randomID(),
+		accountID:   r.account.id,
+		status:      acmeStatusReady,
+		expires:     now.Add(acmeOrderLifetime),
+		identifiers: payload.Identifiers,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pruneExpired(now)
+	for _, id := range payload.Identifiers {
+		authz := &acmeAuthz{
+			id:         randomID(),
+			accountID:  r.account.id,
+			identifier: id,
+			status:     acmeStatusValid,
+			expires:    o.expires,
+		}
+		// without http-01 validation the account binding authorizes the identifiers
+		if a.http01 {
+			authz.status = acmeStatusPending
+			o.status = acmeStatusPending
+			c := &acmeChallenge{id: randomID(), authzID: authz.id, token: randomID(), status: acmeStatusPending}
+			authz.challenges = append(authz.challenges, c)
+			a.challenges[c.id] = c
+		}
+		a.authzs[authz.id] = authz
+		o.authzIDs = append(o.authzIDs, authz.id)
+	}
+	a.orders[o.id] = o
+	a.respond(w, req, http.StatusCreated, a.baseURL(req)+"/order/"+o.id, a.orderJSON(req, o))
+}
+
+// ownedOrder returns the order id of the account that signed r, the caller holds a.mu
+func (a *acmeServer) ownedOrder(r *acmeRequest, id string) *acmeOrder {
+	o, ok := a.orders[id]
+	if !ok || o.accountID != r.account.id {
+		return nil
+	}
+	return o
+}
+
+func (a *acmeServer) order(w http.ResponseWriter, req *http.Request, id string) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o := a.ownedOrder(r, id)
+	if o == nil {
+		a.problem(w, req, http.StatusNotFound, acmeErrMalformed, "unknown order")
+		return
+	}
+	a.respond(w, req, http.StatusOK, a.baseURL(req)+"/order/"+id, a.orderJSON(req, o))
+}
+
+// challengeJSON describes c, the caller holds a.mu
+func (a *acmeServer) challengeJSON(req *http.Request, c *acmeChallenge) interface{} {
+	v := map[string]interface{}{
+		"type":   acmeChallengeHTTP01,
+		"url":    a.baseURL(req) + "/chall/" + c.id,
+		"token":  c.token,
+		"status": c.status,
+	}
+	if c.validated != nil {
+		v["validated"] = c.validated.Format(time.RFC3339)
+	}
+	if c.err != nil {
+		v["error"] = c.err
+	}
+	return v
+}
+
+func (a *acmeServer) authz(w http.ResponseWriter, req *http.Request, id string) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	authz, ok := a.authzs[id]
+	if !ok || authz.accountID != r.account.id {
+		a.problem(w, req, http.StatusNotFound, acmeErrMalformed, "unknown authorization")
+		return
+	}
+	challenges := []interface{}{}
+	for _, c := range authz.challenges {
+		challenges = append(challenges, a.challengeJSON(req, c))
+	}
+	a.respond(w, req, http.StatusOK, "", map[string]interface{}{
+		"identifier": authz.identifier,
+		"status":     authz.status,
+		"expires":    authz.expires.Format(time.RFC3339),
+		"challenges": challenges,
+	})
+}
+
+func (a *acmeServer) challenge(w http.ResponseWriter, req *http.Request, id string) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.challenges[id]
+	if !ok || a.authzs[c.authzID] == nil || a.authzs[c.authzID].accountID != r.account.id {
+		a.problem(w, req, http.StatusNotFound, acmeErrMalformed, "unknown challenge")
+		return
+	}
+	// a POST with a payload asks the server to validate, POST-as-GET only fetches
+	if len(r.payload) > 0 && c.status == acmeStatusPending {
+		c.status = acmeStatusProcessing
+		go a.validateHTTP01(c, a.authzs[c.authzID].identifier, r.account.thumbprint)
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s/authz/%s>;rel=\"up\"", a.baseURL(req), c.authzID))
+	a.respond(w, req, http.StatusOK, "", a.challengeJSON(req, c))
+}
+
+// validateHTTP01 fetches the key authorization of c from the identifier and
+// updates the challenge, its authorization and the orders depending on it
+func (a *acmeServer) validateHTTP01(c *acmeChallenge, id acmeIdentifier, thumbprint string) {
+	expected := c.token + "." + thumbprint
+	url := "http://" + net.JoinHostPort(id.Value, strconv.Itoa(a.http01Port)) + acmeHTTP01Path + c.token
+
+	var problem *acmeProblem
+	resp, err := a.httpClient.Get(url)
+	if err == nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode != http.StatusOK:
+			problem = &acmeProblem{Type: acmeErrIncorrectResponse,
+				Detail: fmt.Sprintf("%s returned %s", url, resp.Status)}
+		case strings.TrimSpace(string(body)) != expected:
+			problem = &acmeProblem{Type: acmeErrIncorrectResponse,
+				Detail: url + " returned an incorrect key authorization"}
+		}
+	} else {
+		problem = &acmeProblem{Type: acmeErrIncorrectResponse, Detail: err.Error()}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	authz := a.authzs[c.authzID]
+	if authz == nil {
+		return
+	}
+	if problem != nil {
+		log.Printf("[error] http-01 validation of %s failed : %s", id.Value, problem.Detail)
+		problem.Status = http.StatusForbidden
+		c.status, c.err, authz.status = acmeStatusInvalid, problem, acmeStatusInvalid
+	} else {
+		log.Printf("http-01 validation of %s succeeded", id.Value)
+		now := time.Now()
+		c.status, c.validated, authz.status = acmeStatusValid, &now, acmeStatusValid
+	}
+
+	for _, o := range a.orders {
+		if o.status != acmeStatusPending {
+			continue
+		}
+		status := acmeStatusReady
+		for _, authzID := range o.authzIDs {
+			switch a.authzs[authzID].status {
+			case acmeStatusInvalid:
+				status = acmeStatusInvalid
+			case acmeStatusPending:
+				if status != acmeStatusInvalid {
+					status = acmeStatusPending
+				}
+			}
+		}
+		o.status = status
+	}
+}
+
+// csrMatchesOrder checks that csr asks for exactly the identifiers of o
+func csrMatchesOrder(csr *x509.CertificateRequest, o *acmeOrder) error {
+	ordered := map[string]bool{}
+	for _, id := range o.identifiers {
+		ordered[id.Type+":"+id.Value] = true
+	}
+	requested := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		requested["dns:"+strings.ToLower(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		requested["ip:"+ip.String()] = true
+	}
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" && !ordered["dns:"+cn] && !ordered["ip:"+cn] {
+		return fmt.Errorf("common name %s is not an identifier of the order", cn)
+	}
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return errors.New("only DNS and IP subject alternative names can be requested")
+	}
+	if len(requested) != len(ordered) {
+		return errors.New("CSR names do not match the order identifiers")
+	}
+	for name := range requested {
+		if !ordered[name] {
+			return fmt.Errorf("%s is not an identifier of the order", name)
+		}
+	}
+	return nil
+}
+
+func (a *acmeServer) finalize(w http.ResponseWriter, req *http.Request, id string) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	o := a.ownedOrder(r, id)
+	switch {
+	case o == nil:
+		a.mu.Unlock()
+		a.problem(w, req, http.StatusNotFound, acmeErrMalformed, "unknown order")
+		return
+	case o.status != acmeStatusReady:
+		a.mu.Unlock()
+		a.problem(w, req, http.StatusForbidden, acmeErrOrderNotReady, "order is "+o.status)
+		return
+	}
+	o.status = acmeStatusProcessing
+	a.mu.Unlock()
+
+	cert, signed, problemType, status, detail := a.issueOrder(req, r, o)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if problemType != "" {
+		// the client may finalize again with another CSR
+		o.status = acmeStatusReady
+		a.problem(w, req, status, problemType, detail)
+		return
+	}
+	o.certificate = gen.EncodeCertificatesPEM(append([][]byte{signed}, caChain...)...)
+	o.status = acmeStatusValid
+	log.Printf("%s finalized ACME order %s - SN: %x", req.RemoteAddr, o.id, cert.SerialNumber)
+	a.respond(w, req, http.StatusOK, a.baseURL(req)+"/order/"+o.id, a.orderJSON(req, o))
+}
+
+// issueOrder signs the CSR finalizing o. A problem type, status and detail are
+// returned when it is rejected.
+func (a *acmeServer) issueOrder(req *http.Request, r *acmeRequest, o *acmeOrder) (*x509.Certificate, []byte,
+	string, int, string) {
+	payload := struct {
+		CSR string `json:"csr"`
+	}{}
+	if err := json.Unmarshal(r.payload, &payload); err != nil {
+		return nil, nil, acmeErrMalformed, http.StatusBadRequest, err.Error()
+	}
+	der, err := b64Decode(payload.CSR)
+	if err != nil {
+		return nil, nil, acmeErrBadCSR, http.StatusBadRequest, "invalid CSR encoding"
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, acmeErrBadCSR, http.StatusBadRequest, err.Error()
+	}
+	if err := csrMatchesOrder(csr, o); err != nil {
+		return nil, nil, acmeErrBadCSR, http.StatusBadRequest, err.Error()
+	}
+
+	opts, err := requestedSignOptions(&api.SignRequest{})
+	if err != nil {
+		return nil, nil, acmeErrServerInternal, http.StatusInternalServerError, err.Error()
+	}
+	// accounts bound to a token use it up like any other token request and stop
+	// working once it expires or is deleted
+	var redeem redeemFunc
+	if credential := r.account.credential; credential != credentialPSK {
+		redeem = func(names []string) (*token.Token, error) {
+			return bootstrapTokens.RedeemID(credential, names)
+		}
+	}
+	cert, signed, issueErr := issue(req, csr, opts, credentialACME, redeem)
+	if issueErr != nil {
+		switch {
+		case issueErr.status == http.StatusInternalServerError:
+			return nil, nil, acmeErrServerInternal, issueErr.status, issueErr.msg
+		case issueErr.unauthorized:
+			return nil, nil, acmeErrUnauthorized, issueErr.status, issueErr.msg
+		}
+		return nil, nil, acmeErrBadCSR, issueErr.status, issueErr.msg
+	}
+	return cert, signed, "", 0, ""
+}
+
+func (a *acmeServer) certificate(w http.ResponseWriter, req *http.Request, id string) {
+	r, ok := a.verify(w, req, false)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	o := a.ownedOrder(r, id)
+	var chain []byte
+	if o != nil {
+		chain = o.certificate
+	}
+	a.mu.Unlock()
+	if chain == nil {
+		a.problem(w, req, http.StatusNotFound, acmeErrMalformed, "unknown certificate")
+		return
+	}
+
+	w.Header().Set("Replay-Nonce", a.newNonce())
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	n, err := w.Write(chain)
+	if err != nil {
+		log.Printf("error writing output stream : %s | %s | %v", req.RemoteAddr, req.RequestURI, err)
+	}
+	logRequest(req, http.StatusOK, n)
+}
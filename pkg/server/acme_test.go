@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/token"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/acme"
+)
+
+const testStorePath = "/test/.pki"
+
+const testPSK = "acme-test-psk"
+
+// testCA initializes a root CA in memory and loads it as the signing CA
+func testCA(t *testing.T) {
+	gen.AppFs = afero.NewMemMapFs()
+	if err := gen.InitStorage(testStorePath); err != nil {
+		t.Fatalf("error creating storage directory: %v", err)
+	}
+	caKey, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	caDer, err := gen.GenerateCertificate(gen.MakeCertificateConfig(
+		"ROOT", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil, true), nil, caKey)
+	if err != nil {
+		t.Fatalf("root certificate generation failed: %v", err)
+	}
+	if err := gen.WriteCertificate(gen.StorePath(gen.RootCAFile), caDer); err != nil {
+		t.Fatalf("error writing root certificate: %v", err)
+	}
+	if err := gen.WritePrivateKey(gen.StorePath(gen.RootKeyFile), caKey); err != nil {
+		t.Fatalf("error writing root key: %v", err)
+	}
+	if err := setSecrets(testPSK); err != nil {
+		t.Fatalf("error loading secrets: %v", err)
+	}
+	profiles = gen.DefaultProfiles()
+	signOptions = gen.SignOptions{Validity: gen.DefaultValidity}
+	throttle = newAuthThrottle(100, time.Second, time.Second)
+}
+
+// acmeTestServer serves the ACME API of a new root CA
+func acmeTestServer(t *testing.T, http01 bool, http01Port int) *httptest.Server {
+	testCA(t)
+	mux := http.NewServeMux()
+	mux.Handle("/acme/", newACMEServer(http01, http01Port))
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func acmeTestClient(t *testing.T, srv *httptest.Server) *acme.Client {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return &acme.Client{
+		Key:          key,
+		DirectoryURL: srv.URL + "/acme/directory",
+		HTTPClient:   srv.Client(),
+	}
+}
+
+// acmeTestCertificate orders and finalizes a certificate for ids
+func acmeTestCertificate(t *testing.T, client *acme.Client, order *acme.Order, ids []acme.AuthzID) *x509.Certificate {
+	ctx := context.Background()
+	order, err := client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		t.Fatalf("error waiting for order: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: ids[0].Value}}
+	for _, id := range ids {
+		if id.Type == "ip" {
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(id.Value))
+		} else {
+			template.DNSNames = append(template.DNSNames, id.Value)
+		}
+	}
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, template, key)
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		t.Fatalf("error finalizing order: %v", err)
+	}
+	if len(chain) != 2 {
+		t.Fatalf("expected the certificate and the root, got %d certificates", len(chain))
+	}
+	cert, _ := x509.ParseCertificate(chain[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCertificate)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Fatalf("issued certificate does not verify: %v", err)
+	}
+	return cert
+}
+
+func TestACMEPSKBinding(t *testing.T) {
+	srv := acmeTestServer(t, false, 0)
+	ctx := context.Background()
+
+	client := acmeTestClient(t, srv)
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err == nil {
+		t.Fatalf("account without external account binding was registered")
+	}
+	eab := &acme.ExternalAccountBinding{KID: credentialPSK, Key: []byte("wrong")}
+	if _, err := client.Register(ctx, &acme.Account{ExternalAccountBinding: eab}, acme.AcceptTOS); err == nil {
+		t.Fatalf("account with an invalid binding MAC was registered")
+	}
+
+	eab.Key = []byte(testPSK)
+	if _, err := client.Register(ctx, &acme.Account{ExternalAccountBinding: eab}, acme.AcceptTOS); err != nil {
+		t.Fatalf("error registering account: %v", err)
+	}
+	if _, err := client.GetReg(ctx, ""); err != nil {
+		t.Fatalf("error fetching account: %v", err)
+	}
+
+	ids := append(acme.DomainIDs("node-1.mesos"), acme.IPIDs("10.0.0.1")...)
+	order, err := client.AuthorizeOrder(ctx, ids)
+	if err != nil {
+		t.Fatalf("error creating order: %v", err)
+	}
+	if order.Status != acme.StatusReady {
+		t.Fatalf("order bound to the PSK is %s, expected ready", order.Status)
+	}
+	cert := acmeTestCertificate(t, client, order, ids)
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "node-1.mesos" || len(cert.IPAddresses) != 1 {
+		t.Fatalf("certificate has unexpected names %v %v", cert.DNSNames, cert.IPAddresses)
+	}
+	if _, err := issued.Find(cert.SerialNumber.Text(16)); err != nil {
+		t.Fatalf("certificate was not recorded: %v", err)
+	}
+
+	// a CSR must ask for exactly the ordered names
+	order, _ = client.AuthorizeOrder(ctx, acme.DomainIDs("node-2.mesos"))
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"node-2.mesos", "other.mesos"}}, key)
+	if _, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true); err == nil {
+		t.Fatalf("CSR with names outside the order was signed")
+	}
+}
+
+func TestACMETokenBinding(t *testing.T) {
+	srv := acmeTestServer(t, false, 0)
+	ctx := context.Background()
+
+	secret, tok, err := bootstrapTokens.Create(time.Hour, 1, []string{"node-*"})
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	client := acmeTestClient(t, srv)
+	eab := &acme.ExternalAccountBinding{KID: tok.ID, Key: token.EABKey(secret)}
+	if _, err := client.Register(ctx, &acme.Account{ExternalAccountBinding: eab}, acme.AcceptTOS); err != nil {
+		t.Fatalf("error registering account: %v", err)
+	}
+
+	if _, err := client.AuthorizeOrder(ctx, acme.DomainIDs("master-1.mesos")); err == nil {
+		t.Fatalf("order outside the token scope was accepted")
+	}
+	ids := acme.DomainIDs("node-1.mesos")
+	order, err := client.AuthorizeOrder(ctx, ids)
+	if err != nil {
+		t.Fatalf("error creating order: %v", err)
+	}
+	acmeTestCertificate(t, client, order, ids)
+
+	// every certificate uses the token, a single use token allows one
+	order, err = client.AuthorizeOrder(ctx, acme.DomainIDs("node-2.mesos"))
+	if err != nil {
+		t.Fatalf("error creating order: %v", err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "node-2.mesos"}, DNSNames: []string{"node-2.mesos"}}, key)
+	if _, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true); err == nil {
+		t.Fatalf("second order of a single use token was finalized")
+	}
+
+	other := acmeTestClient(t, srv)
+	if _, err := other.Register(ctx, &acme.Account{ExternalAccountBinding: eab}, acme.AcceptTOS); err == nil {
+		t.Fatalf("used up token bound a second account")
+	}
+
+	// deleting the token revokes the accounts bound to it
+	secret, tok, _ = bootstrapTokens.Create(time.Hour, 2, nil)
+	client = acmeTestClient(t, srv)
+	eab = &acme.ExternalAccountBinding{KID: tok.ID, Key: token.EABKey(secret)}
+	if _, err := client.Register(ctx, &acme.Account{ExternalAccountBinding: eab}, acme.AcceptTOS); err != nil {
+		t.Fatalf("error registering account: %v", err)
+	}
+	order, _ = client.AuthorizeOrder(ctx, acme.DomainIDs("node-2.mesos"))
+	if err := bootstrapTokens.Delete(tok.ID); err != nil {
+		t.Fatalf("error deleting token: %v", err)
+	}
+	if _, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true); err == nil {
+		t.Fatalf("order of an account bound to a deleted token was finalized")
+	}
+}
+
+func TestACMEHTTP01(t *testing.T) {
+	var client *acme.Client
+	challenges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		keyAuth, err := client.HTTP01ChallengeResponse(strings.TrimPrefix(req.URL.Path, acmeHTTP01Path))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(keyAuth))
+	}))
+	defer challenges.Close()
+	challengeURL, _ := url.Parse(challenges.URL)
+	port, _ := strconv.Atoi(challengeURL.Port())
+
+	srv := acmeTestServer(t, true, port)
+	ctx := context.Background()
+	client = acmeTestClient(t, srv)
+	eab := &acme.ExternalAccountBinding{KID: credentialPSK, Key: []byte(testPSK)}
+	if _, err := client.Register(ctx, &acme.Account{ExternalAccountBinding: eab}, acme.AcceptTOS); err != nil {
+		t.Fatalf("error registering account: %v", err)
+	}
+
+	ids := acme.IPIDs("127.0.0.1")
+	order, err := client.AuthorizeOrder(ctx, ids)
+	if err != nil {
+		t.Fatalf("error creating order: %v", err)
+	}
+	if order.Status != acme.StatusPending {
+		t.Fatalf("order is %s before validation, expected pending", order.Status)
+	}
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	if err != nil {
+		t.Fatalf("error fetching authorization: %v", err)
+	}
+	if len(authz.Challenges) != 1 || authz.Challenges[0].Type != acmeChallengeHTTP01 {
+		t.Fatalf("expected an http-01 challenge, got %v", authz.Challenges)
+	}
+	if _, err := client.Accept(ctx, authz.Challenges[0]); err != nil {
+		t.Fatalf("error accepting challenge: %v", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		t.Fatalf("authorization failed: %v", err)
+	}
+	acmeTestCertificate(t, client, order, ids)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+// Certificates is an HTTP handler which serves the issuer chain without
+// authentication, as a PEM bundle or as JSON when requested by the Accept
+// header or format=json
+func Certificates(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body []byte
+	if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		resp := api.CACertificatesResponse{}
+		for _, der := range caChain {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				logError(req, w, "Error parsing CA certificate : "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp.Certificates = append(resp.Certificates, api.CACertificate{
+				Subject:     cert.Subject.String(),
+				Issuer:      cert.Issuer.String(),
+				Fingerprint: gen.Fingerprint(der),
+				NotAfter:    cert.NotAfter,
+				Root:        bytes.Equal(der, rootCertificate.Raw),
+				Certificate: string(gen.EncodeCertificatesPEM(der)),
+			})
+		}
+		j, err := json.Marshal(resp)
+		if err != nil {
+			logError(req, w, "Error marshalling JSON : "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		body = j
+	} else {
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		body = gen.EncodeCertificatesPEM(caChain...)
+	}
+
+	n, err := w.Write(body)
+	if err != nil {
+		log.Printf("error writing output stream : %s | %s | %v", req.RemoteAddr, req.RequestURI, err)
+	}
+	logRequest(req, http.StatusOK, n)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+)
+
+// crlCache holds the most recently generated CRL. It is regenerated whenever
+// the ledger changes or half of its validity has elapsed.
+var crlCache struct {
+	sync.Mutex
+	der        []byte
+	ledgerTime time.Time
+	thisUpdate time.Time
+}
+
+// crlValidity is the time between a CRL's thisUpdate and nextUpdate
+var crlValidity time.Duration
+
+func currentCRL() ([]byte, error) {
+	crlCache.Lock()
+	defer crlCache.Unlock()
+
+	modTime, err := issued.ModTime()
+	if err != nil {
+		return nil, err
+	}
+	fresh := time.Since(crlCache.thisUpdate) < crlValidity/2
+	if crlCache.der != nil && fresh && modTime.Equal(crlCache.ledgerTime) {
+		return crlCache.der, nil
+	}
+
+	revoked, err := issued.Revoked()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ledger.RevocationEntries(revoked)
+	if err != nil {
+		return nil, err
+	}
+	der, err := gen.GenerateCRL(entries, signingCertificate, signingKey, crlValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	crlCache.der = der
+	crlCache.ledgerTime = modTime
+	crlCache.thisUpdate = time.Now()
+	return der, nil
+}
+
+// CRL is an HTTP handler which serves the current DER encoded CRL
+func CRL(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	der, err := currentCRL()
+	if err != nil {
+		logError(req, w, "Error generating CRL : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	n, err := w.Write(der)
+	if err != nil {
+		log.Printf("error writing output stream : %s | %s | %v", req.RemoteAddr, req.RequestURI, err)
+	}
+	logRequest(req, http.StatusOK, n)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+)
+
+// estPrefix is the path EST operations are served below, RFC 7030 section 3.2.2
+const estPrefix = "/.well-known/est/"
+
+// estMaxRequestBody bounds the size of a base64 encoded CSR
+const estMaxRequestBody = 64 * 1024
+
+// EST is an HTTP handler implementing the cacerts, simpleenroll and
+// simplereenroll operations of RFC 7030. An optional label before the
+// operation, /.well-known/est/<label>/simpleenroll, selects the certificate
+// profile.
+func EST(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, estPrefix), "/"), "/")
+	label, operation := "", parts[len(parts)-1]
+	switch len(parts) {
+	case 1:
+	case 2:
+		label = parts[0]
+	default:
+		logError(req, w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch operation {
+	case "cacerts":
+		estCACerts(w, req)
+	case "simpleenroll":
+		estEnroll(w, req, label, false)
+	case "simplereenroll":
+		estEnroll(w, req, label, true)
+	default:
+		logError(req, w, "Not found", http.StatusNotFound)
+	}
+}
+
+// writePKCS7 responds with a base64 encoded PKCS#7 certs-only message
+func writePKCS7(w http.ResponseWriter, req *http.Request, certificates ...[]byte) {
+	der, err := gen.EncodePKCS7CertsOnly(certificates...)
+	if err != nil {
+		logError(req, w, "Error encoding PKCS#7 : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(der)
+	var b strings.Builder
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n")
+
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	n, err := w.Write([]byte(b.String()))
+	if err != nil {
+		log.Printf("error writing output stream : %s | %s | %v", req.RemoteAddr, req.RequestURI, err)
+	}
+	logRequest(req, http.StatusOK, n)
+}
+
+// estCACerts returns the issuer chain, RFC 7030 section 4.1
+func estCACerts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writePKCS7(w, req, caChain...)
+}
+
+// estAuthenticate authenticates an enrollment with a client certificate or HTTP
+// basic authentication, the password being the PSK. The client certificate is
+// returned with its ledger record when one was presented.
+func estAuthenticate(w http.ResponseWriter, req *http.Request) (string, *x509.Certificate, *ledger.Record, bool) {
+	if rejectLockedOut(req, w) {
+		return "", nil, nil, false
+	}
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		cert, record, err := clientCertificate(req)
+		if err != nil {
+			authFailed(req, w, err.Error())
+			return "", nil, nil, false
+		}
+		throttle.succeed(sourceIP(req))
+		return credentialCertificate, cert, record, true
+	}
+
+	_, password, ok := req.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="estrealm"`)
+		logError(req, w, "Authentication required", http.StatusUnauthorized)
+		return "", nil, nil, false
+	}
+	if !validPSK(password) {
+		authFailed(req, w, "Key is invalid")
+		return "", nil, nil, false
+	}
+	throttle.succeed(sourceIP(req))
+	return credentialPSK, nil, nil, true
+}
+
+// estEnroll signs a base64 encoded PKCS#10 request, RFC 7030 section 4.2.
+// Clients authenticated with a certificate may only request its identity, which
+// is required for re-enrollment, and keep its profile, see renewalProfile.
+func estEnroll(w http.ResponseWriter, req *http.Request, profile string, reenroll bool) {
+	if req.Method != "POST" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	credential, cert, record, ok := estAuthenticate(w, req)
+	if !ok {
+		return
+	}
+	if reenroll && cert == nil {
+		logError(req, w, "Re-enrollment requires the client certificate being renewed", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, estMaxRequestBody))
+	if err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		logError(req, w, "CSR is not base64 encoded", http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		logError(req, w, "CSR is not valid", http.StatusBadRequest)
+		return
+	}
+	if cert != nil {
+		if err := gen.CheckSameIdentity(csr, cert); err != nil {
+			logError(req, w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if profile, err = renewalProfile(cert, record, profile); err != nil {
+			logError(req, w, err.Error(), http.StatusForbidden)
+			return
+		}
+		log.Printf("%s re-enrolling certificate %s for %s", req.RemoteAddr, ledger.SerialString(cert),
+			cert.Subject.CommonName)
+	}
+
+	opts, err := requestedSignOptions(&api.SignRequest{Profile: profile})
+	if err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, signed, issueErr := issue(req, csr, opts, credential, nil)
+	if issueErr != nil {
+		issueErr.respond(req, w)
+		return
+	}
+	writePKCS7(w, req, signed)
+}
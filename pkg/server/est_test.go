@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+func estTestServer(t *testing.T) *httptest.Server {
+	testCA(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc(estPrefix, EST)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// estPost sends a base64 encoded CSR and returns the certificates of the response
+func estPost(t *testing.T, client *http.Client, url string, csr []byte, password string) (int, [][]byte) {
+	req, _ := http.NewRequest("POST", url, strings.NewReader(base64.StdEncoding.EncodeToString(csr)))
+	req.Header.Set("Content-Type", "application/pkcs10")
+	if password != "" {
+		req.SetBasicAuth("est", password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("error posting to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, estDecode(t, resp)
+}
+
+func estDecode(t *testing.T, resp *http.Response) [][]byte {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/pkcs7-mime") {
+		t.Fatalf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		t.Fatalf("response is not base64 encoded: %v", err)
+	}
+	certificates, err := gen.ParsePKCS7CertsOnly(der)
+	if err != nil {
+		t.Fatalf("response is not a PKCS#7 message: %v", err)
+	}
+	return certificates
+}
+
+func TestEST(t *testing.T) {
+	srv := estTestServer(t)
+	client := srv.Client()
+
+	resp, err := client.Get(srv.URL + estPrefix + "cacerts")
+	if err != nil {
+		t.Fatalf("error fetching CA certificates: %v", err)
+	}
+	if chain := estDecode(t, resp); len(chain) != 1 || string(chain[0]) != string(rootCertificate.Raw) {
+		t.Fatalf("cacerts did not return the root certificate")
+	}
+	resp.Body.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "appliance"},
+		DNSNames: []string{"appliance.mesos"},
+	}, key)
+
+	if status, _ := estPost(t, client, srv.URL+estPrefix+"simpleenroll", csr, ""); status != http.StatusUnauthorized {
+		t.Fatalf("enrollment without credentials returned %d", status)
+	}
+	if status, _ := estPost(t, client, srv.URL+estPrefix+"simpleenroll", csr, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("enrollment with a wrong PSK returned %d", status)
+	}
+	if status, _ := estPost(t, client, srv.URL+estPrefix+"simplereenroll", csr, testPSK); status != http.StatusForbidden {
+		t.Fatalf("re-enrollment without a client certificate returned %d", status)
+	}
+
+	status, certificates := estPost(t, client, srv.URL+estPrefix+"server/simpleenroll", csr, testPSK)
+	if status != http.StatusOK || len(certificates) != 1 {
+		t.Fatalf("enrollment returned %d with %d certificates", status, len(certificates))
+	}
+	cert, _ := x509.ParseCertificate(certificates[0])
+	if cert.Subject.CommonName != "appliance" || len(cert.ExtKeyUsage) != 1 ||
+		cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Fatalf("certificate was not issued with the server profile")
+	}
+
+	// the issued certificate authenticates its own re-enrollment
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+	mtls := &http.Client{Transport: transport}
+	if status, _ := estPost(t, mtls, srv.URL+estPrefix+"simplereenroll", csr, ""); status != http.StatusOK {
+		t.Fatalf("re-enrollment returned %d", status)
+	}
+	other, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "other"},
+		DNSNames: []string{"other.mesos"},
+	}, key)
+	if status, _ := estPost(t, mtls, srv.URL+estPrefix+"simplereenroll", other, ""); status != http.StatusForbidden {
+		t.Fatalf("re-enrollment for another identity returned %d", status)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+)
+
+// nonceCache remembers the nonces of accepted requests until their timestamp
+// is too old to be accepted again. Expired nonces are pruned at most once per
+// api.MACMaxSkew so requests do not scan the whole cache.
+type nonceCache struct {
+	mu      sync.Mutex
+	nonces  map[string]time.Time
+	pruneAt time.Time
+}
+
+// nonces holds the nonces of HMAC signed requests seen recently
+var nonces = &nonceCache{nonces: map[string]time.Time{}}
+
+// add records nonce and reports whether it had not been seen before
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !now.Before(c.pruneAt) {
+		for n, expires := range c.nonces {
+			if now.After(expires) {
+				delete(c.nonces, n)
+			}
+		}
+		c.pruneAt = now.Add(api.MACMaxSkew)
+	}
+	if expires, ok := c.nonces[nonce]; ok && !now.After(expires) {
+		return false
+	}
+	c.nonces[nonce] = now.Add(2 * api.MACMaxSkew)
+	return true
+}
+
+// verifyMAC checks the MAC of an HMAC signed request against the runtime PSK,
+// rejecting stale timestamps and replayed nonces
+func verifyMAC(r api.MACRequest, now time.Time) error {
+	psk := currentPSK()
+	if psk == "" && insecureNoAuth {
+		return nil
+	}
+	if err := api.VerifyMAC(r, psk, now); err != nil {
+		return err
+	}
+	_, nonce, _ := r.MACFields()
+	// only remember nonces of authentic requests so they can not be used to fill the cache
+	if !nonces.add(nonce, now) {
+		return errors.New("request nonce has already been used")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+)
+
+// macTestRequest returns an api.SignRequest signed with psk at ts
+func macTestRequest(t *testing.T, psk string, ts time.Time) *api.SignRequest {
+	r := &api.SignRequest{Csr: "csr", Validity: "24h", Profile: "server"}
+	if err := r.SignWithPSK(psk); err != nil {
+		t.Fatalf("error signing request: %v", err)
+	}
+	r.Timestamp = ts.Unix()
+	r.MAC = api.ComputeMAC(r, psk)
+	return r
+}
+
+func TestVerifyMAC(t *testing.T) {
+	runtimePsk.Store(testPSK)
+	now := time.Now()
+	tests := []struct {
+		name   string
+		modify func(r *api.SignRequest)
+		err    string
+	}{
+		{name: "valid"},
+		{name: "clock behind", modify: func(r *api.SignRequest) { r.Timestamp = now.Add(-api.MACMaxSkew + time.Second).Unix() }},
+		{name: "clock ahead", modify: func(r *api.SignRequest) { r.Timestamp = now.Add(api.MACMaxSkew - time.Second).Unix() }},
+		{name: "too old", modify: func(r *api.SignRequest) { r.Timestamp = now.Add(-api.MACMaxSkew - time.Second).Unix() },
+			err: "outside the accepted window"},
+		{name: "too far ahead", modify: func(r *api.SignRequest) { r.Timestamp = now.Add(api.MACMaxSkew + time.Second).Unix() },
+			err: "outside the accepted window"},
+		{name: "missing nonce", modify: func(r *api.SignRequest) { r.Nonce = "" }, err: "nonce is missing"},
+		{name: "tampered CSR", modify: func(r *api.SignRequest) { r.Csr = "other" }, err: "MAC is invalid"},
+		{name: "tampered profile", modify: func(r *api.SignRequest) { r.Profile = "sub-ca" }, err: "MAC is invalid"},
+		{name: "tampered validity", modify: func(r *api.SignRequest) { r.Validity = "87600h" }, err: "MAC is invalid"},
+		{name: "wrong PSK", modify: func(r *api.SignRequest) { r.MAC = api.ComputeMAC(r, "wrong") }, err: "MAC is invalid"},
+		{name: "malformed MAC", modify: func(r *api.SignRequest) { r.MAC = "not hex" }, err: "MAC is invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonces = &nonceCache{nonces: map[string]time.Time{}}
+			r := macTestRequest(t, testPSK, now)
+			if tt.modify != nil {
+				tt.modify(r)
+				// fields changed by the test are signed again unless the MAC is under test
+				if !strings.Contains(tt.err, "MAC") {
+					r.MAC = api.ComputeMAC(r, testPSK)
+				}
+			}
+			err := verifyMAC(r, now)
+			if tt.err == "" && err != nil {
+				t.Fatalf("valid request was refused: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyMACReplay(t *testing.T) {
+	runtimePsk.Store(testPSK)
+	nonces = &nonceCache{nonces: map[string]time.Time{}}
+	now := time.Now()
+	r := macTestRequest(t, testPSK, now)
+	if err := verifyMAC(r, now); err != nil {
+		t.Fatalf("valid request was refused: %v", err)
+	}
+	if err := verifyMAC(r, now.Add(time.Second)); err == nil || !strings.Contains(err.Error(), "already been used") {
+		t.Fatalf("replayed request was accepted: %v", err)
+	}
+
+	// a forged request does not burn the nonce of a genuine one
+	genuine := macTestRequest(t, testPSK, now)
+	forged := *genuine
+	forged.MAC = api.ComputeMAC(&forged, "wrong")
+	if err := verifyMAC(&forged, now); err == nil {
+		t.Fatalf("forged request was accepted")
+	}
+	if err := verifyMAC(genuine, now); err != nil {
+		t.Fatalf("genuine request was refused after a forged one: %v", err)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := &nonceCache{nonces: map[string]time.Time{}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !c.add("a", now) {
+		t.Fatalf("new nonce was refused")
+	}
+	if c.add("a", now.Add(2*api.MACMaxSkew)) {
+		t.Fatalf("nonce was accepted again before it expired")
+	}
+
+	// requests within api.MACMaxSkew of the last pruning do not scan the cache
+	c.add("b", now.Add(api.MACMaxSkew/2))
+	if len(c.nonces) != 2 {
+		t.Fatalf("expected 2 cached nonces, got %d", len(c.nonces))
+	}
+
+	// once expired a nonce is accepted again, its timestamp is refused by verifyMAC anyway
+	later := now.Add(2*api.MACMaxSkew + time.Second)
+	if !c.add("a", later) {
+		t.Fatalf("expired nonce was refused")
+	}
+	if _, ok := c.nonces["b"]; !ok {
+		t.Fatalf("unexpired nonce was pruned")
+	}
+	c.add("c", later.Add(2*api.MACMaxSkew))
+	if _, ok := c.nonces["b"]; ok {
+		t.Fatalf("expired nonce was not pruned")
+	}
+}
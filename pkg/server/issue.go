@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+const (
+	// defaultIssueKeyType is generated when an issue request names no key type
+	defaultIssueKeyType = gen.KeyTypeECDSAP256
+	// maxIssueKeyBits bounds the RSA keys generated for a single request
+	maxIssueKeyBits = 4096
+)
+
+// checkIssueRequest validates r before any key is generated
+func checkIssueRequest(r *api.IssueRequest) error {
+	if r.CommonName == "" {
+		return fmt.Errorf("common_name is required")
+	}
+	switch r.Format {
+	case "", api.IssueFormatPEM:
+	case api.IssueFormatPKCS12:
+		if r.Password == "" {
+			return fmt.Errorf("a password is required for %s", api.IssueFormatPKCS12)
+		}
+	default:
+		return fmt.Errorf("unsupported format %q, must be %s or %s", r.Format, api.IssueFormatPEM, api.IssueFormatPKCS12)
+	}
+	if r.KeyBits > maxIssueKeyBits {
+		return fmt.Errorf("RSA keys may not exceed %d bits", maxIssueKeyBits)
+	}
+	return nil
+}
+
+// generateIssueKey creates the key and CSR requested by r
+func generateIssueKey(r *api.IssueRequest) (crypto.Signer, *x509.CertificateRequest, error) {
+	keyType, bits := r.KeyType, r.KeyBits
+	if keyType == "" {
+		keyType = defaultIssueKeyType
+	}
+	if bits == 0 {
+		bits = gen.MinRSAKeyBits
+	}
+	key, err := gen.GenerateKey(keyType, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := gen.MakeCSRConfig(r.CommonName, r.Country, r.State, r.Locality, r.Organization,
+		r.Sans, r.EmailAddresses)
+	der, err := gen.GenerateCSR(config, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, csr, nil
+}
+
+// Issue is an HTTP handler which generates a key for the client and returns it
+// with the signed certificate, for clients that can not generate keys. The key
+// only exists in memory on the server. Profiles allowing CA certificates are
+// refused.
+func Issue(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if rejectLockedOut(req, w) {
+		return
+	}
+
+	jsonReq := &api.IssueRequest{}
+	if err := json.NewDecoder(req.Body).Decode(jsonReq); err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case jsonReq.Token != "":
+		// checked once the request has been validated, redeemed by issue
+	case jsonReq.MAC != "":
+		if err := verifyMAC(jsonReq, time.Now()); err != nil {
+			authFailed(req, w, err.Error())
+			return
+		}
+	case !validPSK(jsonReq.Psk):
+		authFailed(req, w, "Key is invalid\n")
+		return
+	}
+	if err := checkIssueRequest(jsonReq); err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := requestedSignOptions(&api.SignRequest{Validity: jsonReq.Validity, Profile: jsonReq.Profile})
+	if err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Profile.AllowCA {
+		// keys generated by the server are for leaf certificates only
+		logError(req, w, "profile allows CA certificates, it can not be used to issue keys", http.StatusForbidden)
+		return
+	}
+
+	credential := credentialPSK
+	if jsonReq.Token != "" {
+		// the names csrNames returns for the CSR generated below
+		names := append([]string{jsonReq.CommonName}, jsonReq.Sans...)
+		if !checkToken(req, w, jsonReq.Token, names) {
+			return
+		}
+		credential = credentialToken
+	}
+	throttle.succeed(sourceIP(req))
+
+	key, csr, err := generateIssueKey(jsonReq)
+	if err != nil {
+		logError(req, w, "Error generating key : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cert, _, issueErr := issue(req, csr, opts, credential, redeemSecret(jsonReq.Token))
+	if issueErr != nil {
+		issueErr.respond(req, w)
+		return
+	}
+
+	var body []byte
+	if jsonReq.Format == api.IssueFormatPKCS12 {
+		body, err = gen.EncodePKCS12(key, append([][]byte{cert.Raw}, caChain...), jsonReq.Password)
+		if err != nil {
+			logError(req, w, "Error encoding PKCS#12 : "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pkcs12")
+	} else {
+		keyPEM, err := gen.EncodePrivateKeyPEM(key)
+		if err != nil {
+			logError(req, w, "Error encoding key : "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, err = json.Marshal(api.IssueResponse{SignResponse: newSignResponse(cert), PrivateKey: string(keyPEM)})
+		if err != nil {
+			logError(req, w, "Error marshalling JSON : "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	// the response holds a private key
+	w.Header().Set("Cache-Control", "no-store")
+	n, err := w.Write(body)
+	if err != nil {
+		log.Printf("error writing output stream : %s | %s | %v", req.RemoteAddr, req.RequestURI, err)
+	}
+	logRequest(req, http.StatusOK, n)
+}
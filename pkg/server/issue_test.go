@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+)
+
+func issuePost(t *testing.T, srv *httptest.Server, r *api.IssueRequest) *http.Response {
+	body, _ := json.Marshal(r)
+	resp, err := srv.Client().Post(srv.URL+"/csr/v1/issue", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error posting issue request: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestIssue(t *testing.T) {
+	testCA(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csr/v1/issue", Issue)
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	if resp := issuePost(t, srv, &api.IssueRequest{Psk: "wrong", CommonName: "node"}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request with a wrong PSK returned %d", resp.StatusCode)
+	}
+	if resp := issuePost(t, srv, &api.IssueRequest{Psk: testPSK, CommonName: "node", Format: api.IssueFormatPKCS12}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("PKCS#12 request without a password returned %d", resp.StatusCode)
+	}
+	profiles.Profiles["sub-ca"] = &gen.Profile{AllowCA: true}
+	if resp := issuePost(t, srv, &api.IssueRequest{Psk: testPSK, CommonName: "node", Profile: "sub-ca"}); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("request with a CA profile returned %d", resp.StatusCode)
+	}
+
+	r := &api.IssueRequest{CommonName: "node", Sans: []string{"node.example.com"}, KeyType: gen.KeyTypeEd25519}
+	if err := r.SignWithPSK(testPSK); err != nil {
+		t.Fatalf("error signing request: %v", err)
+	}
+	resp := issuePost(t, srv, r)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("issue request returned %d", resp.StatusCode)
+	}
+	var issued api.IssueResponse
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if cert.Subject.CommonName != "node" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "node.example.com" {
+		t.Fatalf("unexpected certificate subject %s and names %v", cert.Subject, cert.DNSNames)
+	}
+	block, _ = pem.Decode([]byte(issued.PrivateKey))
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing private key: %v", err)
+	}
+	if !cert.PublicKey.(interface{ Equal(x crypto.PublicKey) bool }).Equal(key.(crypto.Signer).Public()) {
+		t.Fatalf("private key does not match the certificate")
+	}
+
+	// the generated key is not kept by the CA
+	_ = afero.Walk(gen.AppFs, "/", func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, _ := afero.ReadFile(gen.AppFs, filePath)
+		if bytes.Contains(b, block.Bytes) || bytes.Contains(b, []byte(issued.PrivateKey)) {
+			t.Fatalf("generated key was written to %s", filePath)
+		}
+		return nil
+	})
+
+	resp = issuePost(t, srv, &api.IssueRequest{Psk: testPSK, CommonName: "node", Format: api.IssueFormatPKCS12, Password: "secret"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-pkcs12" {
+		t.Fatalf("PKCS#12 request returned %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	der, _ := io.ReadAll(resp.Body)
+	if _, certificates, err := gen.DecodePKCS12(der, "secret"); err != nil || len(certificates) != 2 {
+		t.Fatalf("expected a key, certificate and root in the key store: %v", err)
+	}
+}
+
+func TestIssueTokenRedeemedLast(t *testing.T) {
+	testCA(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csr/v1/sign", Sign)
+	mux.HandleFunc("/csr/v1/issue", Issue)
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	secret, tok, err := bootstrapTokens.Create(time.Hour, 1, []string{"node-*"})
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	remaining := func() int {
+		tokens, _ := bootstrapTokens.List()
+		for _, listed := range tokens {
+			if listed.ID == tok.ID {
+				return listed.Remaining()
+			}
+		}
+		return 0
+	}
+
+	// subject alternative names are in the scope of the token
+	resp := issuePost(t, srv, &api.IssueRequest{Token: secret, CommonName: "node-1", Sans: []string{"evil.example.com"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("request for a name outside the token scope returned %d", resp.StatusCode)
+	}
+
+	// a CSR the profile rejects does not use up the token
+	key, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	bc, _ := asn1.Marshal(struct{ IsCA bool }{true})
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "node-1"},
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: bc}},
+	}, key)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	body, _ := json.Marshal(&api.SignRequest{Token: secret, Csr: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))})
+	signResp, err := srv.Client().Post(srv.URL+"/csr/v1/sign", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error posting sign request: %v", err)
+	}
+	signResp.Body.Close()
+	if signResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("CA request returned %d", signResp.StatusCode)
+	}
+	if remaining() != 1 {
+		t.Fatalf("rejected requests used up the token")
+	}
+
+	if resp := issuePost(t, srv, &api.IssueRequest{Token: secret, CommonName: "node-1"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("issue request with a token returned %d", resp.StatusCode)
+	}
+	if resp := issuePost(t, srv, &api.IssueRequest{Token: secret, CommonName: "node-1"}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request with a used up token returned %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jws is a JSON Web Signature in flattened JSON serialization as used by ACME
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader is the protected header of an ACME request
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	JWK   json.RawMessage `json:"jwk"`
+	KID   string          `json:"kid"`
+}
+
+// jwk holds the members of an EC or RSA JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func b64Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// header decodes the protected header
+func (j *jws) header() (*jwsHeader, error) {
+	b, err := b64Decode(j.Protected)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protected header encoding: %v", err)
+	}
+	h := &jwsHeader{}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, fmt.Errorf("invalid protected header: %v", err)
+	}
+	return h, nil
+}
+
+// payload decodes the payload, which is empty for POST-as-GET requests
+func (j *jws) payload() ([]byte, error) {
+	return b64Decode(j.Payload)
+}
+
+func (j *jws) signingInput() []byte {
+	return []byte(j.Protected + "." + j.Payload)
+}
+
+// verify checks the signature of j with pub for the algorithm alg
+func (j *jws) verify(alg string, pub crypto.PublicKey) error {
+	sig, err := b64Decode(j.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "ES384":
+		hash = crypto.SHA384
+	case "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", alg)
+	}
+	h := hash.New()
+	h.Write(j.signingInput())
+	digest := h.Sum(nil)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg != ecdsaAlgorithm(pub) || len(sig) != 2*size {
+			return fmt.Errorf("algorithm %s does not match the EC key", alg)
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("signature is invalid")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// verifyMAC checks an HS256 signature, used for external account bindings
+func (j *jws) verifyMAC(key []byte) error {
+	sig, err := b64Decode(j.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(j.signingInput())
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return errors.New("external account binding MAC is invalid")
+	}
+	return nil
+}
+
+func ecdsaAlgorithm(pub *ecdsa.PublicKey) string {
+	switch pub.Curve {
+	case elliptic.P256():
+		return "ES256"
+	case elliptic.P384():
+		return "ES384"
+	case elliptic.P521():
+		return "ES512"
+	}
+	return ""
+}
+
+// parseJWK returns the public key of an EC or RSA JSON Web Key
+func parseJWK(raw []byte) (crypto.PublicKey, error) {
+	k := jwk{}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %v", err)
+	}
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64Decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64Decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return pub, nil
+	case "RSA":
+		n, err := b64Decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64Decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key has %d bits, at least 2048 are required", pub.N.BitLen())
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of pub, base64url encoded
+func jwkThumbprint(pub crypto.PublicKey) (string, error) {
+	var canonical string
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			base64.RawURLEncoding.EncodeToString(pub.N.Bytes()))
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			pub.Curve.Params().Name,
+			base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))))
+	default:
+		return "", fmt.Errorf("unsupported key type %T", pub)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
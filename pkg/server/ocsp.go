@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+	"golang.org/x/crypto/ocsp"
+)
+
+const maxOCSPRequestSize = 10 * 1024
+
+// ocspResponder signs OCSP responses, either with the CA key or with a delegated
+// OCSP signing certificate issued by the CA
+var ocspResponder struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+	validity    time.Duration
+}
+
+type cachedResponse struct {
+	der        []byte
+	thisUpdate time.Time
+}
+
+// ocspCache holds pre-signed responses keyed by serial and hash algorithm. The
+// cache is dropped whenever the ledger changes.
+var ocspCache struct {
+	sync.Mutex
+	ledgerTime time.Time
+	responses  map[string]cachedResponse
+}
+
+// setOCSPResponder configures the key used to sign OCSP responses. When
+// delegated is set the OCSP signing certificate in the store is used.
+func setOCSPResponder(delegated bool, validity time.Duration) error {
+	ocspResponder.certificate = signingCertificate
+	ocspResponder.key = signingKey
+	ocspResponder.validity = validity
+
+	if !delegated {
+		return nil
+	}
+
+	certBytes, err := gen.ReadCertificatePEM(gen.StorePath(gen.OCSPCertFile))
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignatureFrom(signingCertificate); err != nil {
+		return fmt.Errorf("OCSP signing certificate was not issued by the signing CA : %v", err)
+	}
+	key, err := gen.ReadPrivateKey(gen.StorePath(gen.OCSPKeyFile))
+	if err != nil {
+		return err
+	}
+
+	ocspResponder.certificate = cert
+	ocspResponder.key = key
+	log.Printf("Signing OCSP responses with delegated certificate %s", cert.Subject.CommonName)
+	return nil
+}
+
+// ocspResponse returns a signed response for the request, from the cache when possible
+func ocspResponse(ocspReq *ocsp.Request) ([]byte, error) {
+	ocspCache.Lock()
+	defer ocspCache.Unlock()
+
+	modTime, err := issued.ModTime()
+	if err != nil {
+		return nil, err
+	}
+	if ocspCache.responses == nil || !modTime.Equal(ocspCache.ledgerTime) {
+		ocspCache.responses = map[string]cachedResponse{}
+		ocspCache.ledgerTime = modTime
+	}
+
+	key := fmt.Sprintf("%x/%d", ocspReq.SerialNumber, ocspReq.HashAlgorithm)
+	if c, ok := ocspCache.responses[key]; ok && time.Since(c.thisUpdate) < ocspResponder.validity/2 {
+		return c.der, nil
+	}
+
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ocspResponder.validity),
+		IssuerHash:   ocspReq.HashAlgorithm,
+	}
+	if ocspResponder.certificate != signingCertificate {
+		template.Certificate = ocspResponder.certificate
+	}
+
+	r, err := issued.Find(fmt.Sprintf("%x", ocspReq.SerialNumber))
+	switch {
+	case errors.Is(err, ledger.ErrNotFound):
+	case err != nil:
+		return nil, err
+	case r.Revoked():
+		template.Status = ocsp.Revoked
+		template.RevokedAt = *r.RevokedAt
+		template.RevocationReason = r.RevocationReason
+	default:
+		template.Status = ocsp.Good
+	}
+
+	der, err := ocsp.CreateResponse(
+		signingCertificate, ocspResponder.certificate, template, ocspResponder.key)
+	if err != nil {
+		return nil, err
+	}
+	ocspCache.responses[key] = cachedResponse{der: der, thisUpdate: now}
+	return der, nil
+}
+
+// issuedBySigningCA reports whether the request refers to the signing CA
+func issuedBySigningCA(ocspReq *ocsp.Request) bool {
+	if !ocspReq.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(signingCertificate.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+
+	h := ocspReq.HashAlgorithm.New()
+	h.Write(signingCertificate.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	return bytes.Equal(nameHash, ocspReq.IssuerNameHash) && bytes.Equal(keyHash, ocspReq.IssuerKeyHash)
+}
+
+func writeOCSP(req *http.Request, w http.ResponseWriter, der []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	n, err := w.Write(der)
+	if err != nil {
+		log.Printf("error writing output stream : %s | %s | %v", req.RemoteAddr, req.RequestURI, err)
+	}
+	logRequest(req, http.StatusOK, n)
+}
+
+// withOCSP passes requests below /ocsp/ to OCSP before h sees them. ServeMux
+// redirects paths containing "//", which base64 encoded GET requests may hold.
+func withOCSP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/ocsp/") {
+			OCSP(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// OCSP is an HTTP handler which implements an RFC 6960 OCSP responder. Requests
+// are accepted as POST bodies or base64 encoded in the path of a GET, see
+// withOCSP.
+func OCSP(w http.ResponseWriter, req *http.Request) {
+	var raw []byte
+	var err error
+
+	switch req.Method {
+	case "POST":
+		raw, err = io.ReadAll(io.LimitReader(req.Body, maxOCSPRequestSize))
+	case "GET":
+		var encoded string
+		encoded, err = url.PathUnescape(strings.TrimPrefix(req.URL.EscapedPath(), "/ocsp/"))
+		if err == nil {
+			raw, err = base64.StdEncoding.DecodeString(encoded)
+		}
+	default:
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] %s %s %v", req.RemoteAddr, req.RequestURI, err)
+		writeOCSP(req, w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	ocspReq, err := ocsp.ParseRequest(raw)
+	if err != nil {
+		log.Printf("[error] %s %s %v", req.RemoteAddr, req.RequestURI, err)
+		writeOCSP(req, w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	if !issuedBySigningCA(ocspReq) {
+		writeOCSP(req, w, ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	der, err := ocspResponse(ocspReq)
+	if err != nil {
+		log.Printf("[error] %s %s %v", req.RemoteAddr, req.RequestURI, err)
+		writeOCSP(req, w, ocsp.InternalErrorErrorResponse)
+		return
+	}
+	writeOCSP(req, w, der)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPGet(t *testing.T) {
+	testCA(t)
+	if err := setOCSPResponder(false, time.Hour); err != nil {
+		t.Fatalf("error configuring OCSP responder: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ocsp", OCSP)
+	srv := httptest.NewServer(withOCSP(mux))
+	t.Cleanup(srv.Close)
+
+	// a request whose base64 encoding contains "//", which ServeMux would redirect
+	var encoded string
+	for serial := int64(1); !strings.Contains(encoded, "//"); serial++ {
+		der, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(serial)}, signingCertificate, nil)
+		if err != nil {
+			t.Fatalf("error creating OCSP request: %v", err)
+		}
+		encoded = base64.StdEncoding.EncodeToString(der)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, path := range []string{encoded, strings.ReplaceAll(encoded, "/", "%2F")} {
+		resp, err := client.Get(srv.URL + "/ocsp/" + path)
+		if err != nil {
+			t.Fatalf("error getting OCSP response: %v", err)
+		}
+		der, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("OCSP GET of %s returned %d", path, resp.StatusCode)
+		}
+		if _, err := ocsp.ParseResponse(der, signingCertificate); err != nil {
+			t.Fatalf("OCSP GET of %s returned an invalid response: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+// [Spectre vulnerability] Assume no local compromises
+// runtimePsk holds the PSK string clients authenticate with. It is replaced on
+// SIGHUP when the PSK was read from a file.
+var runtimePsk atomic.Value
+
+// insecureNoAuth accepts every request while the PSK is empty
+var insecureNoAuth bool
+
+func currentPSK() string {
+	psk, _ := runtimePsk.Load().(string)
+	return psk
+}
+
+// validPSK compares psk with the runtime PSK in constant time. Both are hashed
+// first so the comparison does not leak the PSK length either.
+func validPSK(psk string) bool {
+	expected := currentPSK()
+	if expected == "" {
+		return insecureNoAuth
+	}
+	a := sha256.Sum256([]byte(psk))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// reloadPSKOnHangup rereads filePath whenever the process receives SIGHUP. The
+// current PSK stays in use when the file can not be read.
+func reloadPSKOnHangup(filePath string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			psk, err := gen.ReadSecretFile(filePath)
+			if err != nil {
+				log.Printf("[error] reloading PSK, keeping the current one : %v", err)
+				continue
+			}
+			if psk == "" && !insecureNoAuth {
+				log.Printf("[error] %s is empty, keeping the current PSK", filePath)
+				continue
+			}
+			runtimePsk.Store(psk)
+			log.Printf("Reloaded PSK from %s", filePath)
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package server
+
+import "testing"
+
+func TestValidPSK(t *testing.T) {
+	defer func() { insecureNoAuth = false }()
+	tests := []struct {
+		expected string
+		noAuth   bool
+		psk      string
+		valid    bool
+	}{
+		{expected: "secret", psk: "secret", valid: true},
+		{expected: "secret", psk: "wrong!", valid: false},
+		{expected: "secret", psk: "secre", valid: false},
+		{expected: "secret", psk: "secret2", valid: false},
+		{expected: "secret", psk: "", valid: false},
+		{expected: "", psk: "", valid: false},
+		{expected: "", psk: "anything", valid: false},
+		{expected: "", noAuth: true, psk: "anything", valid: true},
+	}
+	for _, tt := range tests {
+		runtimePsk.Store(tt.expected)
+		insecureNoAuth = tt.noAuth
+		if valid := validPSK(tt.psk); valid != tt.valid {
+			t.Fatalf("validPSK(%q) with PSK %q and insecure %v returned %v", tt.psk, tt.expected, tt.noAuth, valid)
+		}
+	}
+}
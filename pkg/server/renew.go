@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+)
+
+// clientCertificate verifies the TLS client certificate of req against the CA
+// chain of the server and returns it with its ledger record, nil when the
+// certificate was issued before the ledger existed. Only the CA chain of the
+// server is used to build the path, intermediates sent by the client are
+// ignored. Certificates revoked in the ledger are refused.
+func clientCertificate(req *http.Request) (*x509.Certificate, *ledger.Record, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, nil, errors.New("a client certificate is required")
+	}
+	cert := req.TLS.PeerCertificates[0]
+
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCertificate)
+	intermediates := x509.NewCertPool()
+	for _, c := range caCertificates {
+		intermediates.AddCert(c)
+	}
+
+	// the certificate proves possession of its key, it may have been issued with any profile
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("client certificate is not valid : %v", err)
+	}
+
+	r, err := issued.Find(ledger.SerialString(cert))
+	if err != nil && !errors.Is(err, ledger.ErrNotFound) {
+		return nil, nil, err
+	}
+	if r != nil && r.Revoked() {
+		return nil, nil, fmt.Errorf("client certificate %s has been revoked", r.Serial)
+	}
+	return cert, r, nil
+}
+
+// renewalProfile returns the profile a renewal of cert is issued with: the one
+// cert was issued with when the ledger recorded it, requested otherwise.
+// Renewals never issue CA certificates.
+func renewalProfile(cert *x509.Certificate, record *ledger.Record, requested string) (string, error) {
+	if cert.IsCA {
+		return "", errors.New("CA certificates can not be renewed")
+	}
+	name := requested
+	if record != nil && record.Profile != "" {
+		if requested != "" && requested != record.Profile {
+			return "", fmt.Errorf("certificate was issued with profile %s, renewals keep it", record.Profile)
+		}
+		name = record.Profile
+	}
+	profile, err := profiles.Get(name)
+	if err != nil {
+		return "", err
+	}
+	if profile.AllowCA {
+		return "", fmt.Errorf("profile %s allows CA certificates, it can not be used for renewals", name)
+	}
+	return name, nil
+}
+
+// Renew is an HTTP handler which signs a CSR for a client authenticated with a
+// certificate issued by this CA. The body is an api.SignRequest whose credentials are
+// ignored. Only the subject and subject alternative names of the client
+// certificate may be requested, and the renewal keeps its profile.
+func Renew(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if rejectLockedOut(req, w) {
+		return
+	}
+
+	cert, record, err := clientCertificate(req)
+	if err != nil {
+		authFailed(req, w, err.Error())
+		return
+	}
+	throttle.succeed(sourceIP(req))
+
+	jsonReq := &api.SignRequest{}
+	if err := json.NewDecoder(req.Body).Decode(jsonReq); err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	csr, err := gen.DecodeAndParsePEM([]byte(jsonReq.Csr))
+	if err != nil {
+		logError(req, w, "CSR is not valid", http.StatusBadRequest)
+		return
+	}
+	if err := gen.CheckSameIdentity(csr, cert); err != nil {
+		logError(req, w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if jsonReq.Profile, err = renewalProfile(cert, record, jsonReq.Profile); err != nil {
+		logError(req, w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	log.Printf("%s renewing certificate %s for %s", req.RemoteAddr, ledger.SerialString(cert), cert.Subject.CommonName)
+	signCSR(w, req, csr, jsonReq, credentialCertificate)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+)
+
+// renewTestCertificate creates a certificate for CN node signed by issuer
+func renewTestCertificate(t *testing.T, issuer *x509.Certificate, issuerKey crypto.Signer,
+	isCA bool) (*x509.Certificate, crypto.Signer) {
+	key, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "node"},
+		DNSNames:              []string{"node.example.com"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// renewPost posts a CSR for subject to /csr/v1/renew, authenticated with chain
+func renewPost(t *testing.T, srv *httptest.Server, key crypto.Signer, chain []*x509.Certificate,
+	subject string, profile string) (int, *x509.Certificate) {
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	if len(chain) > 0 {
+		tlsCert := tls.Certificate{PrivateKey: key}
+		for _, c := range chain {
+			tlsCert.Certificate = append(tlsCert.Certificate, c.Raw)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+
+	csrKey, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: subject},
+		DNSNames: []string{subject + ".example.com"},
+	}, csrKey)
+	body, _ := json.Marshal(&api.SignRequest{
+		Csr:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		Profile: profile,
+	})
+	resp, err := (&http.Client{Transport: transport}).Post(srv.URL+"/csr/v1/renew", "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error posting renew request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	var signed api.SignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	block, _ := pem.Decode([]byte(signed.Certificate))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return resp.StatusCode, cert
+}
+
+func TestRenew(t *testing.T) {
+	testCA(t)
+	profiles.Profiles["sub-ca"] = &gen.Profile{AllowCA: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csr/v1/renew", Renew)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// a client certificate issued with the client profile
+	key, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	csrDer, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "node"},
+		DNSNames: []string{"node.example.com"},
+	}, key)
+	csr, _ := x509.ParseCertificateRequest(csrDer)
+	opts, _ := requestedSignOptions(&api.SignRequest{Profile: gen.ProfileClient})
+	cert, _, issueErr := issue(httptest.NewRequest("POST", "/csr/v1/sign", nil), csr, opts, credentialPSK, nil)
+	if issueErr != nil {
+		t.Fatalf("error issuing client certificate: %s", issueErr.msg)
+	}
+
+	if status, _ := renewPost(t, srv, nil, nil, "node", ""); status != http.StatusUnauthorized {
+		t.Fatalf("renewal without a client certificate returned %d", status)
+	}
+	if status, _ := renewPost(t, srv, key, []*x509.Certificate{cert}, "other", ""); status != http.StatusForbidden {
+		t.Fatalf("renewal for another identity returned %d", status)
+	}
+	if status, _ := renewPost(t, srv, key, []*x509.Certificate{cert}, "node", gen.ProfileServer); status != http.StatusForbidden {
+		t.Fatalf("renewal with another profile returned %d", status)
+	}
+	status, renewed := renewPost(t, srv, key, []*x509.Certificate{cert}, "node", "")
+	if status != http.StatusOK {
+		t.Fatalf("renewal returned %d", status)
+	}
+	if len(renewed.ExtKeyUsage) != 1 || renewed.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatalf("renewal did not keep the client profile: %v", renewed.ExtKeyUsage)
+	}
+
+	if _, err := issued.Revoke(ledger.SerialString(cert), 0); err != nil {
+		t.Fatalf("error revoking certificate: %v", err)
+	}
+	if status, _ := renewPost(t, srv, key, []*x509.Certificate{cert}, "node", ""); status != http.StatusUnauthorized {
+		t.Fatalf("renewal with a revoked certificate returned %d", status)
+	}
+
+	// a certificate the ledger does not know may not request a CA profile
+	unrecorded, unrecordedKey := renewTestCertificate(t, rootCertificate, signingKey, false)
+	if status, _ := renewPost(t, srv, unrecordedKey, []*x509.Certificate{unrecorded}, "node", "sub-ca"); status != http.StatusForbidden {
+		t.Fatalf("renewal with a CA profile returned %d", status)
+	}
+
+	// intermediates sent by the client are not trusted, even when signed by the root
+	intermediate, intermediateKey := renewTestCertificate(t, rootCertificate, signingKey, true)
+	leaf, leafKey := renewTestCertificate(t, intermediate, intermediateKey, false)
+	if status, _ := renewPost(t, srv, leafKey, []*x509.Certificate{leaf, intermediate}, "node", ""); status != http.StatusUnauthorized {
+		t.Fatalf("renewal through a client supplied intermediate returned %d", status)
+	}
+	if status, _ := renewPost(t, srv, intermediateKey, []*x509.Certificate{intermediate}, "node", ""); status != http.StatusForbidden {
+		t.Fatalf("renewal of a CA certificate returned %d", status)
+	}
+
+	// certificates of another CA are refused
+	otherKey, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	otherDer, _ := gen.GenerateCertificate(gen.MakeCertificateConfig(
+		"OTHER", "US", "TX", "San Antonio", "Mesosphere Inc.", nil, nil, true), nil, otherKey)
+	otherCA, _ := x509.ParseCertificate(otherDer)
+	foreign, foreignKey := renewTestCertificate(t, otherCA, otherKey, false)
+	if status, _ := renewPost(t, srv, foreignKey, []*x509.Certificate{foreign}, "node", ""); status != http.StatusUnauthorized {
+		t.Fatalf("renewal with a certificate of another CA returned %d", status)
+	}
+}
@@ -1,28 +1,147 @@
 package server
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"fmt"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
 	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/ledger"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/policy"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/token"
 	"log"
 	"net/http"
 	"time"
 )
 
-// [Spectre vulnerability] Assume no local compromises
-var runtimePsk string
-var rootKey *rsa.PrivateKey
 var rootCertificate *x509.Certificate
 
+// signingKey and signingCertificate belong to the intermediate CA when one has
+// been initialized, otherwise to the root CA. caChain holds the issuer chain
+// starting at signingCertificate and ending at rootCertificate, caCertificates
+// the parsed chain.
+var signingKey crypto.Signer
+var signingCertificate *x509.Certificate
+var caChain [][]byte
+var caCertificates []*x509.Certificate
+
+// issued records every certificate signed by the server
+var issued *ledger.Ledger
+
+// bootstrapTokens holds the one-time tokens accepted instead of the PSK
+var bootstrapTokens *token.Store
+
+// Config holds the settings the CA web service is started with
+type Config struct {
+	Address     string
+	Psk         string
+	CRLURL      string        // embedded as the CRL distribution point of issued certificates
+	CRLValidity time.Duration // time until the next CRL update
+
+	PskFile        string // file Psk was read from, reloaded on SIGHUP
+	InsecureNoAuth bool   // allows an empty Psk, every request is then accepted
+
+	OCSPURL       string        // enables the OCSP responder, embedded as AIA in issued certificates
+	OCSPDelegated bool          // sign OCSP responses with the delegated certificate in the store
+	OCSPValidity  time.Duration // time until the next OCSP response update
+
+	MaxValidity time.Duration // longest lifetime a client may request, gen.DefaultValidity when zero
+	Backdate    time.Duration // notBefore offset tolerating clock skew between masters
+
+	AuthFailures   int           // failed authentications per source IP before it is locked out
+	AuthBackoff    time.Duration // first lockout, doubled for every further failure. Zero disables lockouts
+	AuthMaxBackoff time.Duration // longest lockout
+
+	ProfilesFile string // YAML or JSON certificate profiles, the built-in profiles are used when empty
+	PolicyFile   string // YAML or JSON signing policy, every CSR is signed when empty
+
+	ACME           bool // serves an ACME directory at /acme/directory
+	ACMEHTTP01     bool // requires http-01 validation of ACME identifiers instead of trusting the account binding
+	ACMEHTTP01Port int  // port http-01 challenges are fetched from
+
+	Signer gen.SignerBackend // holds the signing key, which is read from the store when nil
+}
+
+// signerBackend holds the signing key, nil when it is read from the store
+var signerBackend gen.SignerBackend
+
+// signOptions is applied to every certificate signed by the server
+var signOptions gen.SignOptions
+
+// profiles are the certificate profiles clients can select in an api.SignRequest
+var profiles *gen.Profiles
+
+// signingPolicy restricts the CSRs the server signs, nil allows every CSR
+var signingPolicy *policy.Policy
+
+// Credentials identifying how a request authenticated in the signing policy
+const (
+	credentialPSK         = policy.CredentialPSK
+	credentialToken       = policy.CredentialToken
+	credentialCertificate = policy.CredentialCertificate
+	credentialACME        = policy.CredentialACME
+)
+
 // RunServer configures and launches the CA web service
-func RunServer(address, psk string) {
-	if err := setSecrets(psk); err != nil {
+func RunServer(config Config) {
+	if config.Psk == "" {
+		if !config.InsecureNoAuth {
+			log.Fatalf("refusing to start with an empty PSK, pass --insecure-no-auth to accept unauthenticated requests")
+		}
+		log.Printf("[warn] PSK is empty, accepting unauthenticated signing requests")
+	}
+	insecureNoAuth = config.InsecureNoAuth
+
+	throttle = newAuthThrottle(config.AuthFailures, config.AuthBackoff, config.AuthMaxBackoff)
+
+	signerBackend = config.Signer
+	if err := setSecrets(config.Psk); err != nil {
 		log.Fatalf("error storing secrets, have you run init-ca? : %v", err)
 	}
+	if config.PskFile != "" {
+		reloadPSKOnHangup(config.PskFile)
+	}
+
+	if config.CRLURL != "" {
+		signOptions.CRLDistributionPoints = []string{config.CRLURL}
+	}
+	crlValidity = config.CRLValidity
+
+	signOptions.Validity = config.MaxValidity
+	if signOptions.Validity <= 0 {
+		signOptions.Validity = gen.DefaultValidity
+	}
+	signOptions.Backdate = config.Backdate
+
+	profiles = gen.DefaultProfiles()
+	if config.ProfilesFile != "" {
+		var err error
+		if profiles, err = gen.LoadProfiles(config.ProfilesFile); err != nil {
+			log.Fatalf("error loading certificate profiles : %v", err)
+		}
+	}
+	log.Printf("Certificate profiles: %v (default %s)", profiles.Names(), profiles.Default)
+	if ca := profiles.CANames(); len(ca) > 0 {
+		log.Printf("Profiles issuing CA certificates: %v", ca)
+	}
+
+	if config.PolicyFile != "" {
+		var err error
+		if signingPolicy, err = policy.Load(config.PolicyFile); err != nil {
+			log.Fatalf("error loading signing policy : %v", err)
+		}
+		log.Printf("Loaded signing policy %s", config.PolicyFile)
+	}
+
+	if config.OCSPURL != "" {
+		signOptions.OCSPServer = []string{config.OCSPURL}
+		if err := setOCSPResponder(config.OCSPDelegated, config.OCSPValidity); err != nil {
+			log.Fatalf("error loading OCSP responder : %v", err)
+		}
+	}
 
 	// tls
 	tlsConfig := &tls.Config{
@@ -32,6 +151,8 @@ func RunServer(address, psk string) {
 			tls.X25519,
 		},
 		MinVersion: tls.VersionTLS12,
+		// client certificates authenticate renewals and are verified by the handler
+		ClientAuth: tls.RequestClientCert,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
 			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
@@ -40,25 +161,45 @@ func RunServer(address, psk string) {
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 		},
+		Certificates: []tls.Certificate{
+			{
+				Certificate: caChain,
+				PrivateKey:  signingKey,
+				Leaf:        signingCertificate,
+			},
+		},
 	}
 
 	// handlers
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", index)
 	mux.HandleFunc("/csr/v1/sign", Sign)
+	mux.HandleFunc("/csr/v1/renew", Renew)
+	mux.HandleFunc("/csr/v1/issue", Issue)
+	mux.HandleFunc("/crl/v1/current", CRL)
+	mux.HandleFunc("/ca/v1/certificates", Certificates)
+	mux.HandleFunc(estPrefix, EST)
+	handler := http.Handler(mux)
+	if config.OCSPURL != "" {
+		mux.HandleFunc("/ocsp", OCSP)
+		handler = withOCSP(mux)
+	}
+	if config.ACME {
+		mux.Handle("/acme/", newACMEServer(config.ACMEHTTP01, config.ACMEHTTP01Port))
+		log.Printf("Serving ACME directory at /acme/directory (http-01 validation: %t)", config.ACMEHTTP01)
+	}
 
 	srv := &http.Server{
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 		IdleTimeout:  20 * time.Second,
-		Addr:         address,
+		Addr:         config.Address,
 		TLSConfig:    tlsConfig,
-		Handler:      mux,
+		Handler:      handler,
 	}
 
-	log.Printf("Serving on %s", address)
-	log.Fatal(srv.ListenAndServeTLS(
-		gen.StorePath(gen.RootCAFile), gen.StorePath(gen.RootKeyFile)))
+	log.Printf("Serving on %s", config.Address)
+	log.Fatal(srv.ListenAndServeTLS("", ""))
 }
 
 // setSecrets loads the PSK into memory along with the root key and certificate
@@ -66,21 +207,36 @@ func RunServer(address, psk string) {
 // but, significantly speeds up signing operations. On kernels vulnerable
 // to meltdown, attackers would be able to extract this information from
 // the LVS, even if this program read the secrets for every request.
+//
+// When an intermediate CA has been initialized it is used for signing and the
+// root key is never read, so it can be kept offline. A PKCS#11 signer backend
+// keeps the signing key out of process memory altogether.
 func setSecrets(psk string) error {
-	runtimePsk = psk
-	certBytes, err := gen.ReadCertificatePEM(gen.StorePath(gen.RootCAFile))
+	runtimePsk.Store(psk)
+	issued = ledger.Open()
+	bootstrapTokens = token.Open()
+
+	ca, err := gen.LoadSigningCA(signerBackend)
 	if err != nil {
 		return err
 	}
-	rootCertificate, err = x509.ParseCertificate(certBytes)
-	if err != nil {
-		return err
+	rootCertificate = ca.Root
+	signingCertificate = ca.Certificate
+	signingKey = ca.Key
+	caChain = ca.Chain
+	caCertificates = nil
+	for _, der := range caChain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		caCertificates = append(caCertificates, cert)
 	}
 
-	rootKey, err = gen.ReadPrivateKey(gen.StorePath(gen.RootKeyFile))
-	if err != nil {
-		return err
+	if ca.IsIntermediate() {
+		log.Printf("Signing with intermediate CA %s", signingCertificate.Subject.CommonName)
 	}
+	log.Printf("Root CA %s - fingerprint: %s", rootCertificate.Subject.CommonName, gen.Fingerprint(rootCertificate.Raw))
 	return nil
 }
 
@@ -97,6 +253,20 @@ func logError(req *http.Request, w http.ResponseWriter, msg string, code int) {
 	logRequest(req, code, n)
 }
 
+// logViolation responds with the policy rule a request failed as JSON
+func logViolation(req *http.Request, w http.ResponseWriter, v *policy.Violation) {
+	log.Printf("[error] %s %s %v", req.RemoteAddr, req.RequestURI, v)
+	j, err := json.Marshal(v)
+	if err != nil {
+		logError(req, w, v.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	n, _ := w.Write(j)
+	logRequest(req, http.StatusForbidden, n)
+}
+
 func index(w http.ResponseWriter, req *http.Request) {
 	if req.Method != "GET" {
 		logError(req, w, "method not allowed", http.StatusMethodNotAllowed)
@@ -110,15 +280,41 @@ func index(w http.ResponseWriter, req *http.Request) {
 	logRequest(req, http.StatusOK, n)
 }
 
-// SignRequest represents the JSON payload for the /csr/v1/sign endpoint
-type SignRequest struct {
-	Psk string `json:"psk"`
-	Csr string `json:"csr"`
+// requestedSignOptions applies the profile and lifetime requested by the client.
+// The lifetime may not exceed the server maximum.
+func requestedSignOptions(jsonReq *api.SignRequest) (gen.SignOptions, error) {
+	opts := signOptions
+	profile, err := profiles.Get(jsonReq.Profile)
+	if err != nil {
+		return opts, err
+	}
+	opts.Profile = profile
+
+	if jsonReq.Validity == "" {
+		return opts, nil
+	}
+	requested, err := time.ParseDuration(jsonReq.Validity)
+	if err != nil {
+		return opts, err
+	}
+	if requested <= 0 {
+		return opts, fmt.Errorf("validity must be positive: %s", jsonReq.Validity)
+	}
+	if requested < opts.Validity {
+		opts.Validity = requested
+	}
+	return opts, nil
 }
 
-//SignResponse represents the JSON response for the /csr/v1/sign endpoint
-type SignResponse struct {
-	Certificate string `json:"certificate"`
+// profileName returns the name profile is configured under, empty when it is not
+// one of the configured profiles
+func profileName(profile *gen.Profile) string {
+	for name, p := range profiles.Profiles {
+		if p == profile {
+			return name
+		}
+	}
+	return ""
 }
 
 // Sign is an HTTP handler which implements CSR signing
@@ -127,9 +323,12 @@ func Sign(w http.ResponseWriter, req *http.Request) {
 		logError(req, w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if rejectLockedOut(req, w) {
+		return
+	}
 
 	decoder := json.NewDecoder(req.Body)
-	jsonReq := &SignRequest{}
+	jsonReq := &api.SignRequest{}
 	err := decoder.Decode(jsonReq)
 
 	if err != nil {
@@ -137,8 +336,16 @@ func Sign(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if len(runtimePsk) >= 0 && jsonReq.Psk != runtimePsk {
-		logError(req, w, "Key is invalid\n", http.StatusUnauthorized)
+	switch {
+	case jsonReq.Token != "":
+		// checked once the CSR has been parsed, redeemed by issue
+	case jsonReq.MAC != "":
+		if err := verifyMAC(jsonReq, time.Now()); err != nil {
+			authFailed(req, w, err.Error())
+			return
+		}
+	case !validPSK(jsonReq.Psk):
+		authFailed(req, w, "Key is invalid\n")
 		return
 	}
 	csr, err := gen.DecodeAndParsePEM([]byte(jsonReq.Csr))
@@ -147,15 +354,179 @@ func Sign(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	signed, err := gen.Sign(csr, rootCertificate, rootKey)
+	credential := credentialPSK
+	if jsonReq.Token != "" {
+		if !checkToken(req, w, jsonReq.Token, csrNames(csr)) {
+			return
+		}
+		credential = credentialToken
+	}
+	throttle.succeed(sourceIP(req))
+
+	signCSR(w, req, csr, jsonReq, credential)
+}
+
+// csrNames returns the names of csr in the scope of a bootstrap token: the
+// common name, DNS names and IP addresses. Email addresses are left to the
+// signing policy and profiles, they name administrators rather than hosts.
+func csrNames(csr *x509.CertificateRequest) []string {
+	names := append([]string{csr.Subject.CommonName}, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// tokenError describes why a bootstrap token was refused, nil when err is nil
+func tokenError(err error) *issueError {
+	switch {
+	case errors.Is(err, token.ErrInvalid):
+		return &issueError{status: http.StatusUnauthorized, msg: err.Error(), unauthorized: true}
+	case errors.Is(err, token.ErrScope):
+		return &issueError{status: http.StatusForbidden, msg: err.Error()}
+	case err != nil:
+		return &issueError{status: http.StatusInternalServerError, msg: "Error checking token : " + err.Error()}
+	}
+	return nil
+}
+
+// checkToken authenticates a request with a bootstrap token allowing names and
+// writes the error response when it does not. No use of the token is consumed,
+// issue redeems it once the request has passed every other check.
+func checkToken(req *http.Request, w http.ResponseWriter, secret string, names []string) bool {
+	_, err := bootstrapTokens.Check(secret, names)
+	if e := tokenError(err); e != nil {
+		e.respond(req, w)
+		return false
+	}
+	return true
+}
+
+// issueError describes why issue did not sign a CSR
+type issueError struct {
+	status       int
+	msg          string
+	violation    *policy.Violation // set when the signing policy rejected the CSR
+	unauthorized bool              // counts as a failed authentication
+}
+
+func (e *issueError) Error() string {
+	return e.msg
+}
+
+// respond writes e as the response to req
+func (e *issueError) respond(req *http.Request, w http.ResponseWriter) {
+	if e.violation != nil {
+		logViolation(req, w, e.violation)
+		return
+	}
+	if e.unauthorized {
+		authFailed(req, w, e.msg)
+		return
+	}
+	logError(req, w, e.msg, e.status)
+}
+
+// redeemFunc consumes one use of the bootstrap token a request authenticated
+// with if it allows every one of names
+type redeemFunc func(names []string) (*token.Token, error)
+
+// redeemSecret redeems the bootstrap token secret, nil when it is empty
+func redeemSecret(secret string) redeemFunc {
+	if secret == "" {
+		return nil
+	}
+	return func(names []string) (*token.Token, error) {
+		return bootstrapTokens.Redeem(secret, names)
+	}
+}
+
+// issue checks csr from an authenticated request against the signing policy,
+// the name constraints of the CA chain and the profile, signs it and records the
+// certificate. The bootstrap token the request authenticated with, if any, is
+// redeemed with redeem right before signing, so rejected requests do not use it up.
+func issue(req *http.Request, csr *x509.CertificateRequest, opts gen.SignOptions,
+	credential string, redeem redeemFunc) (*x509.Certificate, []byte, *issueError) {
+	if err := signingPolicy.Check(csr, credential); err != nil {
+		var v *policy.Violation
+		if errors.As(err, &v) {
+			return nil, nil, &issueError{status: http.StatusForbidden, msg: v.Error(), violation: v}
+		}
+		return nil, nil, &issueError{status: http.StatusForbidden, msg: err.Error()}
+	}
+
+	if err := gen.CheckNameConstraints(csr, caCertificates...); err != nil {
+		return nil, nil, &issueError{status: http.StatusForbidden, msg: err.Error()}
+	}
+	if opts.Profile != nil {
+		if err := opts.Profile.Check(csr, signingCertificate); err != nil {
+			return nil, nil, &issueError{status: http.StatusForbidden, msg: err.Error()}
+		}
+	}
+
+	if redeem != nil {
+		t, err := redeem(csrNames(csr))
+		if e := tokenError(err); e != nil {
+			return nil, nil, e
+		}
+		log.Printf("%s redeemed token %s for %s, %d uses left",
+			req.RemoteAddr, t.ID, csr.Subject.CommonName, t.Remaining())
+	}
+
+	signed, err := gen.SignWithOptions(csr, signingCertificate, signingKey, opts)
+	if errors.Is(err, gen.ErrProfileViolation) || errors.Is(err, gen.ErrNameConstraint) {
+		return nil, nil, &issueError{status: http.StatusForbidden, msg: err.Error()}
+	}
 	if err != nil {
-		logError(req, w, "Error signing certificate : "+err.Error(), http.StatusInternalServerError)
+		return nil, nil, &issueError{status: http.StatusInternalServerError,
+			msg: "Error signing certificate : " + err.Error()}
+	}
+
+	cert, err := x509.ParseCertificate(signed)
+	if err != nil {
+		return nil, nil, &issueError{status: http.StatusInternalServerError,
+			msg: "Error parsing signed certificate : " + err.Error()}
+	}
+	record := ledger.NewRecord(cert, csr, req.RemoteAddr)
+	record.Profile = profileName(opts.Profile)
+	if err := issued.Record(record); err != nil {
+		return nil, nil, &issueError{status: http.StatusInternalServerError,
+			msg: "Error recording certificate : " + err.Error()}
+	}
+	return cert, signed, nil
+}
+
+// newSignResponse describes the newly issued cert
+func newSignResponse(cert *x509.Certificate) api.SignResponse {
+	return api.SignResponse{
+		Certificate: string(gen.EncodeCertificatesPEM(cert.Raw)),
+		Chain:       string(gen.EncodeCertificatesPEM(append([][]byte{cert.Raw}, caChain...)...)),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Validity:    cert.NotAfter.Sub(cert.NotBefore).String(),
+	}
+}
+
+// signCSR signs csr from an authenticated request and writes the api.SignResponse
+func signCSR(w http.ResponseWriter, req *http.Request, csr *x509.CertificateRequest,
+	jsonReq *api.SignRequest, credential string) {
+	opts, err := requestedSignOptions(jsonReq)
+	if err != nil {
+		logError(req, w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signed})
+	bootstrapToken := ""
+	if credential == credentialToken {
+		bootstrapToken = jsonReq.Token
+	}
+	cert, _, issueErr := issue(req, csr, opts, credential, redeemSecret(bootstrapToken))
+	if issueErr != nil {
+		issueErr.respond(req, w)
+		return
+	}
 
-	j, err := json.Marshal(SignResponse{Certificate: string(b)})
+	j, err := json.Marshal(newSignResponse(cert))
 	if err != nil {
 		logError(req, w, "Error marshalling JSON : "+err.Error(), http.StatusInternalServerError)
 		return
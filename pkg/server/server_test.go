@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/client"
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+)
+
+// TestSignWithToken follows token create --allowed-cn 'master-*' with csr --token
+// --common-name master-1, which requests the default administrative email address
+func TestSignWithToken(t *testing.T) {
+	testCA(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csr/v1/sign", Sign)
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	secret, _, err := bootstrapTokens.Create(time.Hour, 1, []string{"master-*"})
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	c, err := client.New(srv.URL, roots)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	c.Token = secret
+
+	key, _ := gen.GenerateKey(gen.KeyTypeECDSAP256, 0)
+	csr := func(cn string, sans []string) []byte {
+		der, err := gen.GenerateCSR(gen.MakeCSRConfig(cn, "US", "CA", "San Francisco", "Mesosphere Inc.",
+			sans, []string{"security@mesosphere.com"}), key)
+		if err != nil {
+			t.Fatalf("error generating CSR: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+
+	if _, err := c.Sign(context.Background(), csr("master-1", []string{"agent-1"}), client.Options{}); err == nil {
+		t.Fatalf("DNS name outside the token scope was signed")
+	}
+	if _, err := c.Sign(context.Background(), csr("master-1", nil), client.Options{}); err != nil {
+		t.Fatalf("error signing with a token: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// authThrottle counts failed authentications per source IP. Once an address
+// exceeds its free failures every further failure locks it out for twice as
+// long as the previous one, up to maxBackoff. A successful authentication
+// resets the counter.
+type authThrottle struct {
+	freeFailures int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+
+	mu      sync.Mutex
+	sources map[string]*authFailures
+}
+
+type authFailures struct {
+	count       int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// throttle is applied to every authenticated endpoint
+var throttle *authThrottle
+
+func newAuthThrottle(freeFailures int, backoff, maxBackoff time.Duration) *authThrottle {
+	return &authThrottle{
+		freeFailures: freeFailures,
+		backoff:      backoff,
+		maxBackoff:   maxBackoff,
+		sources:      map[string]*authFailures{},
+	}
+}
+
+func sourceIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// lockedOut returns the time left until ip may authenticate again, zero when
+// it is not locked out
+func (t *authThrottle) lockedOut(ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.sources[ip]
+	if !ok || !now.Before(f.lockedUntil) {
+		return 0
+	}
+	return f.lockedUntil.Sub(now)
+}
+
+// fail records a failed authentication from ip and returns the lockout it caused
+func (t *authThrottle) fail(ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// forget addresses that have been quiet for longer than the longest lockout
+	for addr, f := range t.sources {
+		if now.Sub(f.lastFailure) > 2*t.maxBackoff {
+			delete(t.sources, addr)
+		}
+	}
+
+	f, ok := t.sources[ip]
+	if !ok {
+		f = &authFailures{}
+		t.sources[ip] = f
+	}
+	f.count++
+	f.lastFailure = now
+	if f.count <= t.freeFailures || t.backoff <= 0 {
+		return 0
+	}
+
+	lockout := t.backoff
+	for i := t.freeFailures + 1; i < f.count && lockout < t.maxBackoff; i++ {
+		lockout *= 2
+	}
+	if lockout > t.maxBackoff {
+		lockout = t.maxBackoff
+	}
+	f.lockedUntil = now.Add(lockout)
+	log.Printf("[warn] %s locked out for %s after %d failed authentications", ip, lockout, f.count)
+	return lockout
+}
+
+// succeed resets the failure counter of ip
+func (t *authThrottle) succeed(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sources, ip)
+}
+
+// rejectLockedOut responds with 429 when the source of req is locked out and
+// reports whether it did
+func rejectLockedOut(req *http.Request, w http.ResponseWriter) bool {
+	wait := throttle.lockedOut(sourceIP(req), time.Now())
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	logError(req, w, "Too many failed authentications, retry in "+wait.Round(time.Second).String(),
+		http.StatusTooManyRequests)
+	return true
+}
+
+// authFailed records a failed authentication and responds with 401
+func authFailed(req *http.Request, w http.ResponseWriter, msg string) {
+	throttle.fail(sourceIP(req), time.Now())
+	logError(req, w, msg, http.StatusUnauthorized)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/api"
+)
+
+func TestAuthThrottle(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int           // failures one second apart, starting at start
+		succeed  bool          // authenticate successfully after the failures
+		at       time.Duration // time after the last failure lockedOut is checked
+		lockout  time.Duration // lockout caused by the last failure
+		wait     time.Duration // remaining lockout at
+	}{
+		{name: "free failures", failures: 3, lockout: 0, wait: 0},
+		{name: "first lockout", failures: 4, lockout: time.Minute, wait: time.Minute},
+		{name: "lockout doubles", failures: 5, lockout: 2 * time.Minute, wait: 2 * time.Minute},
+		{name: "lockout doubles again", failures: 6, lockout: 4 * time.Minute, wait: 4 * time.Minute},
+		{name: "capped at max backoff", failures: 20, lockout: 10 * time.Minute, wait: 10 * time.Minute},
+		{name: "lockout elapses", failures: 5, at: 90 * time.Second, lockout: 2 * time.Minute, wait: 30 * time.Second},
+		{name: "lockout over", failures: 5, at: 2 * time.Minute, lockout: 2 * time.Minute, wait: 0},
+		{name: "success resets", failures: 6, succeed: true, lockout: 4 * time.Minute, wait: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newAuthThrottle(3, time.Minute, 10*time.Minute)
+			now := start
+			var lockout time.Duration
+			for i := 0; i < tt.failures; i++ {
+				now = start.Add(time.Duration(i) * time.Second)
+				lockout = th.fail("10.0.0.1", now)
+			}
+			if lockout != tt.lockout {
+				t.Fatalf("expected a lockout of %s after %d failures, got %s", tt.lockout, tt.failures, lockout)
+			}
+			if tt.succeed {
+				th.succeed("10.0.0.1")
+			}
+			if wait := th.lockedOut("10.0.0.1", now.Add(tt.at)); wait != tt.wait {
+				t.Fatalf("expected to wait %s, got %s", tt.wait, wait)
+			}
+			if wait := th.lockedOut("10.0.0.2", now.Add(tt.at)); wait != 0 {
+				t.Fatalf("other address is locked out for %s", wait)
+			}
+		})
+	}
+}
+
+func TestAuthThrottleSuccessResetsCount(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := newAuthThrottle(2, time.Minute, 10*time.Minute)
+	th.fail("10.0.0.1", now)
+	th.fail("10.0.0.1", now)
+	th.succeed("10.0.0.1")
+	if lockout := th.fail("10.0.0.1", now); lockout != 0 {
+		t.Fatalf("failure after a success was locked out for %s", lockout)
+	}
+}
+
+func TestAuthThrottleEviction(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := newAuthThrottle(1, time.Minute, 10*time.Minute)
+	th.fail("10.0.0.1", now)
+	th.fail("10.0.0.2", now.Add(15*time.Minute))
+
+	// 10.0.0.1 has been quiet for longer than twice the longest lockout
+	th.fail("10.0.0.3", now.Add(21*time.Minute))
+	if _, ok := th.sources["10.0.0.1"]; ok {
+		t.Fatalf("quiet address was not evicted")
+	}
+	if _, ok := th.sources["10.0.0.2"]; !ok {
+		t.Fatalf("recent address was evicted")
+	}
+	// its count starts over
+	if lockout := th.fail("10.0.0.1", now.Add(21*time.Minute)); lockout != 0 {
+		t.Fatalf("evicted address was locked out for %s", lockout)
+	}
+}
+
+func TestSignLockout(t *testing.T) {
+	testCA(t)
+	throttle = newAuthThrottle(3, time.Minute, 10*time.Minute)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csr/v1/sign", Sign)
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	post := func(psk string) *http.Response {
+		body, _ := json.Marshal(&api.SignRequest{Psk: psk})
+		resp, err := srv.Client().Post(srv.URL+"/csr/v1/sign", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("error posting sign request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	for i := 1; i <= 4; i++ {
+		if resp := post("wrong"); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failure %d returned %d", i, resp.StatusCode)
+		}
+	}
+	resp := post("wrong")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request after the lockout returned %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") != "60" {
+		t.Fatalf("unexpected Retry-After %q", resp.Header.Get("Retry-After"))
+	}
+	// the right PSK is refused as well while locked out
+	if resp := post(testPSK); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("valid request while locked out returned %d", resp.StatusCode)
+	}
+}
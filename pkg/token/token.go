@@ -0,0 +1,464 @@
+// One-time bootstrap tokens authenticating requests to the CA service
+
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+)
+
+// Errors returned by Redeem
+var (
+	ErrInvalid = errors.New("token is invalid, expired or used up")
+	ErrScope   = errors.New("token does not allow the requested name")
+)
+
+// idLength is the number of hex digits of the hash used as token ID
+const idLength = 12
+
+// lockTimeout bounds how long a store operation waits for another process
+const lockTimeout = 5 * time.Second
+
+// Token is a bootstrap token as kept in the store. Only the SHA-256 hash of the
+// secret handed to clients is stored, along with its external account binding
+// key encrypted with the key of the store.
+type Token struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	EAB       string    `json:"eab,omitempty"` // sealed EABKey, see Store.seal
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Uses      int       `json:"uses"`       // number of certificates the token may request
+	Used      int       `json:"used"`       // number of certificates requested so far
+	AllowedCN []string  `json:"allowed_cn"` // globs, the CSR common name and SANs must match one; empty allows any
+}
+
+// Expired reports whether the token has expired at time now
+func (t Token) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// Remaining returns the number of uses left
+func (t Token) Remaining() int {
+	return t.Uses - t.Used
+}
+
+// Allows reports whether the token may request a certificate naming cn, a
+// common name, DNS name or IP address
+func (t Token) Allows(cn string) bool {
+	if len(t.AllowedCN) == 0 {
+		return true
+	}
+	for _, pattern := range t.AllowedCN {
+		if ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(cn)); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists tokens as a JSON file. Every operation reads and rewrites the
+// whole file under a lock file so the CLI and a running server can share it. The
+// key sealing external account binding keys is kept in a ".key" file next to it.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New returns a Store backed by filePath
+func New(filePath string) *Store {
+	return &Store{path: filePath}
+}
+
+// Open returns the Store kept in the store initialized by gen.InitStorage
+func Open() *Store {
+	return New(gen.StorePath(gen.TokensFile))
+}
+
+// Hash returns the hex encoded SHA-256 digest of a token secret
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// EABKey returns the ACME external account binding MAC key of a token, derived
+// from its secret independently of Hash so the stored hash and the token ID do
+// not reveal it. The token ID is the binding key ID.
+func EABKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("acme-eab"))
+	return mac.Sum(nil)
+}
+
+// storeKey returns the key sealing the EAB keys of the store, kept in a file
+// next to it and generated the first time it is needed. It must be called with
+// the store locked.
+func (s *Store) storeKey() ([]byte, error) {
+	keyPath := s.path + ".key"
+	key, err := afero.ReadFile(gen.AppFs, keyPath)
+	if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, afero.WriteFile(gen.AppFs, keyPath, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s is not a 256-bit key", keyPath)
+	}
+	return key, nil
+}
+
+func (s *Store) aead() (cipher.AEAD, error) {
+	key, err := s.storeKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the EAB key of the token with ID id with AES-256-GCM
+func (s *Store) seal(id string, eabKey []byte) (string, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, eabKey, []byte(id))), nil
+}
+
+// open decrypts the EAB key of t sealed by seal
+func (s *Store) open(t *Token) ([]byte, error) {
+	if t.EAB == "" {
+		return nil, fmt.Errorf("%w: token %s has no external account binding key, create a new one", ErrInvalid, t.ID)
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(t.EAB)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("token %s has a malformed external account binding key", t.ID)
+	}
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(t.ID))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting external account binding key of token %s : %v", t.ID, err)
+	}
+	return key, nil
+}
+
+// lock serializes access with other processes through a lock file next to the
+// store, see gen.LockFile. The kernel releases the lock when the process holding it
+// exits, so a crashed process does not leave the store locked. The returned
+// function releases the lock.
+func (s *Store) lock() (func(), error) {
+	s.mu.Lock()
+	f, err := gen.AppFs.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err == nil {
+		if err = gen.LockFile(f, lockTimeout); err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("error locking %s : %v", s.path, err)
+	}
+	return func() {
+		// closing the file releases the lock
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *Store) load() ([]Token, error) {
+	data, err := afero.ReadFile(gen.AppFs, s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("error parsing %s : %v", s.path, err)
+	}
+	return tokens, nil
+}
+
+// save replaces the store file. Tokens that are expired or used up are dropped.
+func (s *Store) save(tokens []Token, now time.Time) error {
+	kept := []Token{}
+	for _, t := range tokens {
+		if !t.Expired(now) && t.Remaining() > 0 {
+			kept = append(kept, t)
+		}
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := afero.WriteFile(gen.AppFs, tmp, data, 0600); err != nil {
+		return err
+	}
+	return gen.AppFs.Rename(tmp, s.path)
+}
+
+// Create generates a token valid for ttl that may be used uses times to request
+// certificates whose common name, DNS names and IP addresses each match one of
+// allowedCN. The secret is returned to be handed to a client, it cannot be
+// recovered later.
+func (s *Store) Create(ttl time.Duration, uses int, allowedCN []string) (string, *Token, error) {
+	if ttl <= 0 {
+		return "", nil, fmt.Errorf("token ttl must be positive, got %s", ttl)
+	}
+	if uses < 1 {
+		return "", nil, fmt.Errorf("token uses must be at least 1, got %d", uses)
+	}
+	for _, pattern := range allowedCN {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid common name pattern %q", pattern)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now().UTC()
+	hash := Hash(secret)
+	t := Token{
+		ID:        hash[:idLength],
+		Hash:      hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Uses:      uses,
+		AllowedCN: allowedCN,
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return "", nil, err
+	}
+	defer unlock()
+
+	if t.EAB, err = s.seal(t.ID, EABKey(secret)); err != nil {
+		return "", nil, err
+	}
+	tokens, err := s.load()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.save(append(tokens, t), now); err != nil {
+		return "", nil, err
+	}
+	return secret, &t, nil
+}
+
+// find returns the token with secret if it is usable and allows every one of
+// names, see Allows
+func find(tokens []Token, secret string, names []string, now time.Time) (*Token, error) {
+	hash := Hash(secret)
+	for i := range tokens {
+		if subtle.ConstantTimeCompare([]byte(tokens[i].Hash), []byte(hash)) == 1 {
+			return usable(&tokens[i], names, now)
+		}
+	}
+	return nil, ErrInvalid
+}
+
+// findID is find for the token with the given ID
+func findID(tokens []Token, id string, names []string, now time.Time) (*Token, error) {
+	for i := range tokens {
+		if tokens[i].ID == id {
+			return usable(&tokens[i], names, now)
+		}
+	}
+	return nil, ErrInvalid
+}
+
+// usable returns t if it has not expired or been used up and allows every one
+// of names
+func usable(t *Token, names []string, now time.Time) (*Token, error) {
+	if t.Expired(now) || t.Remaining() <= 0 {
+		return nil, ErrInvalid
+	}
+	for _, name := range names {
+		if !t.Allows(name) {
+			return nil, fmt.Errorf("%w: %s", ErrScope, name)
+		}
+	}
+	return t, nil
+}
+
+// Check reports whether secret is a valid token allowing every one of names
+// without consuming a use, so requests can be authenticated before they are
+// validated and only redeemed once they are about to succeed
+func (s *Store) Check(secret string, names []string) (*Token, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	t, err := find(tokens, secret, names, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	checked := *t
+	return &checked, nil
+}
+
+// Redeem checks that secret is a valid token allowing every one of names, the
+// common name, DNS names and IP addresses of a CSR, and consumes one of its
+// uses. The check and the update happen under the store lock so a token can
+// not be used more often than allowed by concurrent requests.
+func (s *Store) Redeem(secret string, names []string) (*Token, error) {
+	return s.redeem(func(tokens []Token, now time.Time) (*Token, error) {
+		return find(tokens, secret, names, now)
+	})
+}
+
+// RedeemID is Redeem for the token with the given ID, which an ACME account was
+// bound to with CheckEAB
+func (s *Store) RedeemID(id string, names []string) (*Token, error) {
+	return s.redeem(func(tokens []Token, now time.Time) (*Token, error) {
+		return findID(tokens, id, names, now)
+	})
+}
+
+// redeem consumes one use of the token returned by lookup under the store lock
+func (s *Store) redeem(lookup func(tokens []Token, now time.Time) (*Token, error)) (*Token, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	t, err := lookup(tokens, now)
+	if err != nil {
+		return nil, err
+	}
+	t.Used++
+	redeemed := *t
+	if err := s.save(tokens, now); err != nil {
+		return nil, err
+	}
+	return &redeemed, nil
+}
+
+// CheckEAB reports whether verify accepts the external account binding key of
+// the usable token with the given ID, see EABKey. No use is consumed, every
+// certificate the bound account requests redeems one with RedeemID. Tokens
+// created before EAB keys were sealed in the store can not be checked this way.
+// The common name scope of the token is left to the caller.
+func (s *Store) CheckEAB(id string, verify func(key []byte) error) (*Token, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	t, err := findID(tokens, id, nil, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.open(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	checked := *t
+	return &checked, nil
+}
+
+// List returns every usable token, oldest first
+func (s *Store) List() ([]Token, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var usable []Token
+	for _, t := range tokens {
+		if !t.Expired(now) && t.Remaining() > 0 {
+			usable = append(usable, t)
+		}
+	}
+	sort.SliceStable(usable, func(i, j int) bool {
+		return usable[i].CreatedAt.Before(usable[j].CreatedAt)
+	})
+	return usable, nil
+}
+
+// Delete removes the token with the given ID
+func (s *Store) Delete(id string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, t := range tokens {
+		if t.ID == id {
+			return s.save(append(tokens[:i], tokens[i+1:]...), time.Now())
+		}
+	}
+	return fmt.Errorf("no token with id %s", id)
+}
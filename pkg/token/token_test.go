@@ -0,0 +1,266 @@
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/dcos-bootstrap-ca/pkg/gen"
+	"github.com/spf13/afero"
+)
+
+const testStorePath = "/test/.pki"
+
+func testStore(t *testing.T) *Store {
+	gen.AppFs = afero.NewMemMapFs()
+	if err := gen.InitStorage(testStorePath); err != nil {
+		t.Fatalf("error creating storage directory: %v", err)
+	}
+	return Open()
+}
+
+func TestCreateStoresHash(t *testing.T) {
+	s := testStore(t)
+	secret, tok, err := s.Create(time.Hour, 1, []string{"master-*"})
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	data, err := afero.ReadFile(gen.AppFs, gen.StorePath(gen.TokensFile))
+	if err != nil {
+		t.Fatalf("error reading token store: %v", err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Fatalf("token secret is stored in plain text")
+	}
+	if !strings.Contains(string(data), Hash(secret)) || tok.Hash != Hash(secret) {
+		t.Fatalf("token hash is not stored")
+	}
+
+	fileInfo, err := gen.AppFs.Stat(gen.StorePath(gen.TokensFile))
+	if err != nil {
+		t.Fatalf("could not stat token store: %v", err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Fatalf("token store has incorrect permissions: %s", fileInfo.Mode())
+	}
+
+	if _, _, err := s.Create(0, 1, nil); err == nil {
+		t.Fatalf("token without ttl was created")
+	}
+	if _, _, err := s.Create(time.Hour, 0, nil); err == nil {
+		t.Fatalf("token without uses was created")
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	s := testStore(t)
+	secret, _, err := s.Create(time.Hour, 2, []string{"master-*"})
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	if _, err := s.Redeem(secret, []string{"agent-1"}); !errors.Is(err, ErrScope) {
+		t.Fatalf("expected a scope error, got %v", err)
+	}
+	// every subject alternative name is in scope too
+	for _, san := range []string{"*.example.com", "10.0.0.1", "admin@example.com"} {
+		if _, err := s.Redeem(secret, []string{"master-1", san}); !errors.Is(err, ErrScope) {
+			t.Fatalf("expected a scope error for %s, got %v", san, err)
+		}
+	}
+	// checking a token does not consume it
+	for i := 0; i < 3; i++ {
+		if _, err := s.Check(secret, []string{"master-1"}); err != nil {
+			t.Fatalf("error checking token: %v", err)
+		}
+	}
+	if _, err := s.Redeem("wrong", []string{"master-1"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected an invalid token error, got %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		tok, err := s.Redeem(secret, []string{"master-1"})
+		if err != nil {
+			t.Fatalf("use %d: error redeeming token: %v", i, err)
+		}
+		if tok.Used != i {
+			t.Fatalf("use %d: token used %d times", i, tok.Used)
+		}
+	}
+	if _, err := s.Redeem(secret, []string{"master-1"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("used up token was accepted: %v", err)
+	}
+	if tokens, _ := s.List(); len(tokens) != 0 {
+		t.Fatalf("used up token is still listed: %v", tokens)
+	}
+}
+
+func TestRedeemExpired(t *testing.T) {
+	s := testStore(t)
+	secret, _, err := s.Create(time.Millisecond, 1, nil)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Redeem(secret, []string{"anything"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expired token was accepted: %v", err)
+	}
+}
+
+func TestRedeemConcurrent(t *testing.T) {
+	s := testStore(t)
+	secret, _, err := s.Create(time.Hour, 3, nil)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Redeem(secret, []string{"node"}); err == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if redeemed != 3 {
+		t.Fatalf("token with 3 uses was redeemed %d times", redeemed)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s := testStore(t)
+	secret, tok, err := s.Create(time.Hour, 1, nil)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	if err := s.Delete(tok.ID); err != nil {
+		t.Fatalf("error deleting token: %v", err)
+	}
+	if _, err := s.Redeem(secret, []string{"node"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("deleted token was accepted: %v", err)
+	}
+	if err := s.Delete(tok.ID); err == nil {
+		t.Fatalf("deleting an unknown token succeeded")
+	}
+}
+
+func TestStaleLockFile(t *testing.T) {
+	gen.AppFs = afero.NewOsFs()
+	defer func() { gen.AppFs = afero.NewMemMapFs() }()
+	_ = gen.InitStorage(t.TempDir())
+	s := Open()
+
+	// left behind by a process that crashed while holding the lock
+	if err := afero.WriteFile(gen.AppFs, gen.StorePath(gen.TokensFile)+".lock", nil, 0600); err != nil {
+		t.Fatalf("error writing lock file: %v", err)
+	}
+	secret, _, err := s.Create(time.Hour, 1, nil)
+	if err != nil {
+		t.Fatalf("error creating token with a stale lock file: %v", err)
+	}
+	if _, err := s.Redeem(secret, []string{"node"}); err != nil {
+		t.Fatalf("error redeeming token with a stale lock file: %v", err)
+	}
+
+	// stores of different processes only share the lock file
+	secret, _, _ = s.Create(time.Hour, 3, nil)
+	stores := []*Store{s, Open()}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(store *Store) {
+			defer wg.Done()
+			if _, err := store.Redeem(secret, []string{"node"}); err == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+	if redeemed != 3 {
+		t.Fatalf("token with 3 uses was redeemed %d times by two stores", redeemed)
+	}
+}
+
+func TestCheckEAB(t *testing.T) {
+	s := testStore(t)
+	secret, tok, err := s.Create(time.Hour, 1, nil)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	reject := func(key []byte) error { return errors.New("wrong MAC") }
+	if _, err := s.CheckEAB(tok.ID, reject); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected an invalid token error, got %v", err)
+	}
+
+	// the binding key is not derived from the stored hash, which prefixes the ID
+	eabKey := EABKey(secret)
+	if hash, _ := hex.DecodeString(tok.Hash); bytes.Equal(eabKey, hash) {
+		t.Fatalf("external account binding key is the token hash")
+	}
+	data, _ := afero.ReadFile(gen.AppFs, gen.StorePath(gen.TokensFile))
+	for _, encoded := range []string{hex.EncodeToString(eabKey), base64.StdEncoding.EncodeToString(eabKey),
+		base64.RawURLEncoding.EncodeToString(eabKey)} {
+		if strings.Contains(string(data), encoded) {
+			t.Fatalf("external account binding key is stored in plain text")
+		}
+	}
+	fileInfo, err := gen.AppFs.Stat(gen.StorePath(gen.TokensFile) + ".key")
+	if err != nil || fileInfo.Mode().Perm() != 0600 {
+		t.Fatalf("token store key is missing or has incorrect permissions: %v", err)
+	}
+
+	expected := string(eabKey)
+	accept := func(key []byte) error {
+		if string(key) != expected {
+			return errors.New("unexpected key")
+		}
+		return nil
+	}
+	if _, err := s.CheckEAB(tok.ID, accept); err != nil {
+		t.Fatalf("error checking token: %v", err)
+	}
+	// checking the binding uses nothing, certificates of the account redeem the token
+	if _, err := s.CheckEAB(tok.ID, accept); err != nil {
+		t.Fatalf("checking the binding used up the token: %v", err)
+	}
+	if _, err := s.RedeemID(tok.ID, []string{"node"}); err != nil {
+		t.Fatalf("error redeeming token: %v", err)
+	}
+	if _, err := s.CheckEAB(tok.ID, accept); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("used up token was accepted: %v", err)
+	}
+	if _, err := s.RedeemID(tok.ID, []string{"node"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("used up token was redeemed: %v", err)
+	}
+
+	// a key sealed for another token is refused
+	_, first, _ := s.Create(time.Hour, 1, nil)
+	_, second, _ := s.Create(time.Hour, 1, nil)
+	tokens, _ := s.load()
+	for i := range tokens {
+		if tokens[i].ID == second.ID {
+			tokens[i].EAB = first.EAB
+		}
+	}
+	_ = s.save(tokens, time.Now())
+	if _, err := s.CheckEAB(second.ID, func(key []byte) error { return nil }); err == nil {
+		t.Fatalf("token with the sealed key of another token was accepted")
+	}
+}